@@ -3,12 +3,17 @@ module github.com/benjaminkitt/shape-up-downloader
 go 1.23.4
 
 require (
-	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/go-shiori/go-epub v1.2.1
+	github.com/spf13/cobra v1.8.1
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.34.0
+)
+
+require (
+	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/gofrs/uuid/v5 v5.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.8.1
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/vincent-petithory/dataurl v1.0.0 // indirect
-	golang.org/x/net v0.34.0
+	golang.org/x/text v0.23.0 // indirect
 )
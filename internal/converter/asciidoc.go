@@ -0,0 +1,328 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+const asciidocMasterFile = "shape-up.adoc"
+
+// asciidocEscaper replaces characters that would otherwise start AsciiDoc
+// formatting, passthroughs or macros with their built-in attribute references
+var asciidocEscaper = strings.NewReplacer(
+	"*", "{asterisk}",
+	"`", "{backtick}",
+	"+", "{plus}",
+	"[", "{startsb}",
+	"]", "{endsb}",
+	"<", "{lt}",
+	"{", `\{`,
+)
+
+type AsciiDocConverter struct {
+	OutputDir string
+	baseConverter
+}
+
+func NewAsciiDocConverter(outputDir string) *AsciiDocConverter {
+	return &AsciiDocConverter{
+		OutputDir: outputDir,
+	}
+}
+
+func (a *AsciiDocConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	if err := os.MkdirAll(filepath.Join(a.OutputDir, bookChaptersDir), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, chapter := range chapters {
+		if err := a.writeChapter(chapter, chapters); err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+	}
+
+	return a.writeMaster(a.organizeParts(chapters))
+}
+
+func (a *AsciiDocConverter) writeChapter(chapter downloader.Chapter, chapters []downloader.Chapter) error {
+	processedContent, err := a.processChapterContent(chapter.Content)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return err
+	}
+
+	r := &asciidocRenderer{
+		chapter:  chapter,
+		chapters: chapters,
+		images:   bookImages(chapter.Assets),
+		imageDir: filepath.Join(a.OutputDir, bookImagesDir),
+	}
+
+	blocks := []string{"[#" + anchorID(chapter, "") + "]\n== " + asciidocEscaper.Replace(chapter.Title)}
+	for _, n := range blockNodes(body) {
+		// The chapter heading is written from the chapter title
+		if headingLevel(n) == 1 {
+			continue
+		}
+		if block := r.block(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to save image: %w", r.err)
+	}
+
+	path := filepath.Join(a.OutputDir, bookChaptersDir, chapterFileName(chapter, ".adoc"))
+	if err := os.WriteFile(path, []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write chapter: %w", err)
+	}
+	return nil
+}
+
+// writeMaster writes the book document, which includes the chapters in TOC
+// order under a level-0 section for each part
+func (a *AsciiDocConverter) writeMaster(parts []Part) error {
+	meta := a.metadata()
+	title := meta.Title
+	if meta.Subtitle != "" {
+		title += ": " + meta.Subtitle
+	}
+
+	var out strings.Builder
+	out.WriteString("= " + asciidocEscaper.Replace(title) + "\n")
+	out.WriteString(meta.Author + "\n")
+	out.WriteString(":doctype: book\n")
+	out.WriteString(":lang: " + meta.Language + "\n")
+	if meta.Date != "" {
+		out.WriteString(":revdate: " + meta.Date + "\n")
+	}
+	out.WriteString(":toc:\n")
+	out.WriteString(":toclevels: 2\n")
+	out.WriteString(":sectanchors:\n")
+	out.WriteString(":imagesdir: " + bookImagesDir + "\n")
+
+	for _, part := range parts {
+		out.WriteString("\n= " + asciidocEscaper.Replace(part.Title) + "\n")
+		for _, chapter := range part.Chapters {
+			out.WriteString("\ninclude::" + bookChaptersDir + "/" + chapterFileName(chapter, ".adoc") + "[]\n")
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(a.OutputDir, asciidocMasterFile), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write master document: %w", err)
+	}
+	return nil
+}
+
+// asciidocRenderer renders a chapter's HTML as AsciiDoc
+type asciidocRenderer struct {
+	chapter  downloader.Chapter
+	chapters []downloader.Chapter
+	images   bookImages
+	imageDir string
+
+	// depth is the nesting level of the list being rendered
+	depth int
+
+	// err holds the first error from saving an image
+	err error
+}
+
+// blocks renders the children of n as AsciiDoc blocks
+func (r *asciidocRenderer) blocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		if text := tidyInline(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (isBlockElement(c.Data) || c.Data == "img") {
+			flush()
+			if block := r.block(c); block != "" {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		inline.WriteString(r.inline(c))
+	}
+	flush()
+
+	return blocks
+}
+
+// block renders a single block-level node. Text nodes are treated as
+// paragraphs.
+func (r *asciidocRenderer) block(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return tidyInline(r.inline(n))
+	}
+
+	switch n.Data {
+	case "p":
+		return tidyInline(r.inlineChildren(n))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		// Chapters are level 1 sections, so h2 becomes level 2. AsciiDoc
+		// stops at level 5.
+		heading := strings.Repeat("=", min(headingLevel(n)+1, 6)) + " " + tidyInline(r.inlineChildren(n))
+		if id := getAttr(n, "id"); id != "" {
+			heading = "[#" + anchorID(r.chapter, id) + "]\n" + heading
+		}
+		return heading
+	case "ul", "ol":
+		return r.list(n)
+	case "blockquote":
+		return "____\n" + strings.Join(r.blocks(n), "\n\n") + "\n____"
+	case "pre":
+		return "----\n" + strings.TrimRight(extractText(n), "\n") + "\n----"
+	case "hr":
+		return "'''"
+	case "img":
+		return r.image(n, true)
+	case "figure":
+		return r.figure(n)
+	default:
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+}
+
+// figure renders an image with its caption as the block title
+func (r *asciidocRenderer) figure(n *html.Node) string {
+	img := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	})
+	if img == nil {
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+
+	var title string
+	if caption := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "figcaption"
+	}); caption != nil {
+		if text := tidyInline(r.inlineChildren(caption)); text != "" {
+			title = "." + text + "\n"
+		}
+	}
+	return title + r.image(img, true)
+}
+
+func (r *asciidocRenderer) list(n *html.Node) string {
+	r.depth++
+	defer func() { r.depth-- }()
+
+	marker := strings.Repeat("*", r.depth) + " "
+	if n.Data == "ol" {
+		marker = strings.Repeat(".", r.depth) + " "
+	}
+
+	var items []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		// Blocks after the first are attached to the item with a list
+		// continuation
+		blocks := r.blocks(c)
+		if len(blocks) == 0 {
+			continue
+		}
+		items = append(items, marker+strings.Join(blocks, "\n+\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+func (r *asciidocRenderer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(r.inline(c))
+	}
+	return text.String()
+}
+
+func (r *asciidocRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return asciidocEscaper.Replace(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	// Unconstrained marks work regardless of the surrounding characters
+	switch n.Data {
+	case "em", "i":
+		return emphasize("__", r.inlineChildren(n))
+	case "strong", "b":
+		return emphasize("**", r.inlineChildren(n))
+	case "code":
+		return "``" + asciidocEscaper.Replace(extractText(n)) + "``"
+	case "br":
+		return " +\n"
+	case "img":
+		return r.image(n, false)
+	case "a":
+		text := r.inlineChildren(n)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return text
+		}
+		lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+		trail := text[len(strings.TrimRight(text, " ")):]
+		return lead + r.link(getAttr(n, "href"), trimmed) + trail
+	case "script", "style", "template":
+		return ""
+	default:
+		return r.inlineChildren(n)
+	}
+}
+
+// link returns a cross reference for links within the book and a link macro
+// for everything else
+func (r *asciidocRenderer) link(href, text string) string {
+	chapter, fragment, external := resolveLink(href, r.chapter, r.chapters)
+	switch {
+	case chapter != nil:
+		return "<<" + anchorID(*chapter, fragment) + "," + text + ">>"
+	case !external:
+		return text
+	}
+	return "link:++" + href + "++[" + text + "]"
+}
+
+// image saves an embedded image, or keeps a linked one's URL, and returns
+// a block or inline image macro
+func (r *asciidocRenderer) image(n *html.Node, block bool) string {
+	target, err := r.images.place(r.imageDir, "", getAttr(n, "src"))
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return ""
+	}
+
+	macro := "image:"
+	if block {
+		macro = "image::"
+	}
+	return macro + target + "[" + strconv.Quote(normalizeSpace(getAttr(n, "alt"))) + "]"
+}
@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAsciiDocConverter_Convert verifies chapter files, cross references
+// and the master document
+func TestAsciiDocConverter_Convert(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "book")
+
+	conv := NewAsciiDocConverter(testDir)
+	if err := conv.Convert(testChapters(), ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	files := map[string][]string{
+		"shape-up.adoc": {
+			":doctype: book",
+			":imagesdir: images",
+			"= Contents\n\ninclude::chapters/01-principles-of-shaping.adoc[]\n\ninclude::chapters/02-set-boundaries.adoc[]",
+		},
+		"chapters/01-principles-of-shaping.adoc": {
+			"[#ch_1.1]\n== Principles of Shaping",
+			"<<ch_1.2_fixed-time,fixed time>> and __appetite__.",
+			"* Rough\n* Solved\n+\n.. Bounded",
+			"____\nQuote\n____",
+			`.A sketch` + "\nimage::",
+			`.png["Sketch"]`,
+			"link:++https://basecamp.com++[Basecamp]",
+		},
+		"chapters/02-set-boundaries.adoc": {
+			"[#ch_1.2_fixed-time]\n=== Fixed time",
+			"<<ch_1.2_fixed-time,the top>>",
+		},
+	}
+
+	for file, expected := range files {
+		content := readFile(t, filepath.Join(testDir, filepath.FromSlash(file)))
+		for _, want := range expected {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s missing %q:\n%s", file, want, content)
+			}
+		}
+	}
+
+	images, err := os.ReadDir(filepath.Join(testDir, bookImagesDir))
+	if err != nil || len(images) != 1 {
+		t.Errorf("expected one image, got %v (%v)", images, err)
+	}
+}
+
+// TestAsciiDocEscaper verifies formatting characters in text are escaped
+func TestAsciiDocEscaper(t *testing.T) {
+	got := asciidocEscaper.Replace("a *b* [c] {d} <<e>>")
+	want := `a {asterisk}b{asterisk} {startsb}c{endsb} \{d} {lt}{lt}e>>`
+	if got != want {
+		t.Errorf("asciidocEscaper = %q, want %q", got, want)
+	}
+}
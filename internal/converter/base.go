@@ -1,15 +1,84 @@
 package converter
 
 import (
+	"github.com/benjaminkitt/shape-up-downloader/internal/device"
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"golang.org/x/net/html"
 )
 
 type Converter interface {
 	Convert(chapters []downloader.Chapter, css string) error
+	SetMetadata(meta downloader.Metadata)
+	SetDevice(profile device.Profile)
 }
 
-type baseConverter struct{}
+type baseConverter struct {
+	meta    downloader.Metadata
+	profile *device.Profile
+}
+
+// SetMetadata sets the book metadata a converter writes. Empty fields fall
+// back to downloader.DefaultMetadata.
+func (b *baseConverter) SetMetadata(meta downloader.Metadata) {
+	b.meta = meta
+}
+
+// metadata returns the book metadata with defaults filled in
+func (b *baseConverter) metadata() downloader.Metadata {
+	return b.meta.Merge(downloader.DefaultMetadata)
+}
+
+// SetDevice sets the profile of the device the book is for
+func (b *baseConverter) SetDevice(profile device.Profile) {
+	b.profile = &profile
+}
+
+// device returns the device profile, device.Default unless one was set
+func (b *baseConverter) device() device.Profile {
+	if b.profile == nil {
+		return device.Default
+	}
+	return *b.profile
+}
+
+type Part struct {
+	Title    string
+	Chapters []downloader.Chapter
+}
+
+// organizeParts groups the chapters into the parts of the Shape Up book. A
+// book too short to hold them all has a single part.
+func (b *baseConverter) organizeParts(chapters []downloader.Chapter) []Part {
+	if len(chapters) < 19 {
+		return []Part{{
+			Title:    "Contents",
+			Chapters: chapters,
+		}}
+	}
+
+	return []Part{
+		{
+			Title:    "Introduction",
+			Chapters: chapters[0:3],
+		},
+		{
+			Title:    "Part 1: Shaping",
+			Chapters: chapters[3:8],
+		},
+		{
+			Title:    "Part 2: Betting",
+			Chapters: chapters[8:11],
+		},
+		{
+			Title:    "Part 3: Building",
+			Chapters: chapters[11:18],
+		},
+		{
+			Title:    "Appendices",
+			Chapters: chapters[18:],
+		},
+	}
+}
 
 // Shared DOM utilities
 func findNode(n *html.Node, criteria func(*html.Node) bool) *html.Node {
@@ -33,6 +102,24 @@ func getAttr(n *html.Node, key string) string {
 	return ""
 }
 
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapNode replaces an element with its children
+func unwrapNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = n.FirstChild {
+		n.RemoveChild(c)
+		n.Parent.InsertBefore(c, n)
+	}
+	n.Parent.RemoveChild(n)
+}
+
 func setAttr(n *html.Node, key, value string) {
 	for i := range n.Attr {
 		if n.Attr[i].Key == key {
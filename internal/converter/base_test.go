@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"golang.org/x/net/html"
 )
 
@@ -139,3 +140,57 @@ func TestSetAttr(t *testing.T) {
 		})
 	}
 }
+
+// TestBaseConverter_Metadata verifies converters use the metadata they are
+// given, with defaults for anything left out
+func TestBaseConverter_Metadata(t *testing.T) {
+	conv := NewTextConverter("book.txt", DefaultTextWidth)
+	if got := conv.metadata(); got != downloader.DefaultMetadata {
+		t.Errorf("metadata() = %+v, want the defaults", got)
+	}
+
+	conv.SetMetadata(downloader.Metadata{Title: "Shape Up Again", Author: "A. Reader"})
+	page := conv.titlePage([]downloader.Chapter{{Title: "Introduction", URL: "https://basecamp.com/shapeup/0.1-introduction", Number: 1}})
+
+	for _, want := range []string{"Shape Up Again", "by A. Reader", "Stop Running in Circles"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("titlePage() missing %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "Ryan Singer") {
+		t.Errorf("titlePage() should use the author given:\n%s", page)
+	}
+}
+
+// TestOrganizeParts verifies short books get a single part and every chapter
+// lands in exactly one part
+func TestOrganizeParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		chapters  int
+		wantParts int
+	}{
+		{"short book", 3, 1},
+		{"partial book", 10, 1},
+		{"one short of the book", 18, 1},
+		{"full book", 20, 5},
+	}
+
+	conv := &baseConverter{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chapters := make([]downloader.Chapter, tt.chapters)
+			parts := conv.organizeParts(chapters)
+			if len(parts) != tt.wantParts {
+				t.Errorf("organizeParts() got %d parts, want %d", len(parts), tt.wantParts)
+			}
+			total := 0
+			for _, part := range parts {
+				total += len(part.Chapters)
+			}
+			if total != tt.chapters {
+				t.Errorf("organizeParts() holds %d chapters, want %d", total, tt.chapters)
+			}
+		})
+	}
+}
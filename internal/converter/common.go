@@ -1,12 +1,29 @@
 package converter
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"golang.org/x/net/html"
 )
 
+// Directories used by outputs that write a master document plus one file per
+// chapter
+const (
+	bookChaptersDir = "chapters"
+	bookImagesDir   = "images"
+)
+
+// invalidIDChars matches characters not allowed in an XML ID
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
 // Implementation of baseConverter methods
 func (b *baseConverter) processChapterContent(content string) (string, error) {
 	doc, err := html.Parse(strings.NewReader(content))
@@ -79,6 +96,67 @@ func findAllNodes(n *html.Node, criteria func(*html.Node) bool) []*html.Node {
 	return nodes
 }
 
+// extractText gets all text content from a node
+func extractText(n *html.Node) string {
+	var text strings.Builder
+	var extract func(*html.Node)
+
+	extract = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			extract(c)
+		}
+	}
+
+	extract(n)
+	return strings.TrimSpace(text.String())
+}
+
+// blockNodes flattens structural containers and returns the block-level
+// elements of a chapter in document order
+func blockNodes(n *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.ElementNode && isContainerElement(c.Data):
+			nodes = append(nodes, blockNodes(c)...)
+		case c.Type == html.ElementNode:
+			nodes = append(nodes, c)
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) != "":
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func isContainerElement(tag string) bool {
+	switch tag {
+	case "div", "section", "article", "main", "header":
+		return true
+	}
+	return false
+}
+
+// headingLevel returns the level of a heading element, or 0 for any other
+// node
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode || len(n.Data) != 2 || n.Data[0] != 'h' {
+		return 0
+	}
+	level, err := strconv.Atoi(n.Data[1:])
+	if err != nil || level < 1 || level > 6 {
+		return 0
+	}
+	return level
+}
+
+// normalizeSpace collapses runs of whitespace into single spaces
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
 func hasClass(n *html.Node, class string) bool {
 	for _, attr := range n.Attr {
 		if attr.Key == "class" {
@@ -113,3 +191,280 @@ func findBookLinks(node *html.Node) []*html.Node {
 			strings.HasPrefix(href, "https://basecamp.com/shapeup/")
 	})
 }
+
+// processAnchorLinks rewrites book links to in-document anchors, for outputs
+// that hold the whole book in a single document
+func (b *baseConverter) processAnchorLinks(node *html.Node) {
+	links := findBookLinks(node)
+	for _, link := range links {
+		href := getAttr(link, "href")
+
+		// Strip basecamp prefix if present
+		href = strings.TrimPrefix(href, "https://basecamp.com/shapeup/")
+		href = strings.TrimPrefix(href, "/shapeup/")
+
+		// If href already contains a #, preserve only the section reference
+		if strings.Contains(href, "#") {
+			parts := strings.Split(href, "#")
+			href = "#" + parts[1]
+		} else {
+			href = "#" + href
+		}
+
+		setAttr(link, "href", href)
+	}
+}
+
+// findChapterByURL returns the chapter a book link points at, ignoring any
+// section reference, or nil if the link leaves the book
+func findChapterByURL(href string, chapters []downloader.Chapter) *downloader.Chapter {
+	// Strip any section reference and prefixes
+	href = strings.Split(href, "#")[0]
+	href = strings.TrimPrefix(href, "https://basecamp.com")
+	fullURL := "https://basecamp.com" + href
+
+	for i := range chapters {
+		if chapters[i].URL == fullURL {
+			return &chapters[i]
+		}
+	}
+	return nil
+}
+
+// resolveLink sorts a link found in the current chapter. A link into the
+// book returns the chapter it points at and the fragment within it, and a
+// link that leaves the book returns external. An empty link, or the chapter
+// title's link back to the contents, returns neither and is best rendered
+// as plain text.
+func resolveLink(href string, current downloader.Chapter, chapters []downloader.Chapter) (chapter *downloader.Chapter, fragment string, external bool) {
+	switch {
+	case href == "" || href == "#toc":
+		return nil, "", false
+	case strings.HasPrefix(href, "#"):
+		return &current, strings.TrimPrefix(href, "#"), false
+	}
+
+	if chapter = findChapterByURL(href, chapters); chapter != nil {
+		_, fragment, _ = strings.Cut(href, "#")
+		return chapter, fragment, false
+	}
+	return nil, "", true
+}
+
+// chapterID returns the anchor id used for a chapter in single-document outputs
+func chapterID(chapter downloader.Chapter) string {
+	return strings.TrimPrefix(chapter.URL, "https://basecamp.com/shapeup/")
+}
+
+// anchorID returns a document-unique id for a chapter or a fragment within
+// it. XML ids can't start with a digit, so every id gets a prefix.
+func anchorID(chapter downloader.Chapter, fragment string) string {
+	id := "ch_" + invalidIDChars.ReplaceAllString(chapterID(chapter), "_")
+	if fragment != "" {
+		id += "_" + invalidIDChars.ReplaceAllString(fragment, "_")
+	}
+	return id
+}
+
+// chapterFileName returns a file name for a chapter that sorts in TOC order
+func chapterFileName(chapter downloader.Chapter, ext string) string {
+	return fmt.Sprintf("%02d-%s%s", chapter.Number, slugify(chapter.Title), ext)
+}
+
+// parseBody parses an HTML fragment and returns its <body> element
+func parseBody(content string) (*html.Node, error) {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse content: %w", err)
+	}
+
+	body := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "body"
+	})
+	if body == nil {
+		return nil, fmt.Errorf("could not find document body")
+	}
+
+	return body, nil
+}
+
+// decodeDataURL returns the payload and media type of a data: URL
+func decodeDataURL(src string) ([]byte, string, error) {
+	if !strings.HasPrefix(src, "data:") {
+		return nil, "", fmt.Errorf("not a data URL")
+	}
+
+	header, payload, found := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	if !found {
+		return nil, "", fmt.Errorf("malformed data URL")
+	}
+
+	mediaType := strings.Split(header, ";")[0]
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasSuffix(header, ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode data URL: %w", err)
+		}
+		return data, mediaType, nil
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode data URL: %w", err)
+	}
+	return []byte(data), mediaType, nil
+}
+
+// slugify turns a title into a lowercase, hyphenated URL path segment
+func slugify(title string) string {
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+
+	if slug.Len() == 0 {
+		return "untitled"
+	}
+	return slug.String()
+}
+
+// bookImages holds the images the downloader found in the chapters, and
+// the fonts and images their CSS uses, by the placeholder URLs it gave them
+type bookImages map[string]downloader.Asset
+
+func newBookImages(chapters []downloader.Chapter) bookImages {
+	images := make(bookImages)
+	for _, chapter := range chapters {
+		for src, asset := range chapter.Assets {
+			images[src] = asset
+		}
+	}
+	return images
+}
+
+// isBookImage reports whether an image source is carried by the book, as an
+// asset or a data: URL, rather than linked
+func isBookImage(src string) bool {
+	return strings.HasPrefix(src, downloader.AssetScheme) || strings.HasPrefix(src, "data:")
+}
+
+// data returns the content and media type of an image source
+func (b bookImages) data(src string) ([]byte, string, error) {
+	if strings.HasPrefix(src, downloader.AssetScheme) {
+		asset, ok := b[src]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown image %s", src)
+		}
+		return asset.Data, asset.MediaType, nil
+	}
+	return decodeDataURL(src)
+}
+
+// dataURL returns an image source as a data: URL
+func (b bookImages) dataURL(src string) (string, error) {
+	if strings.HasPrefix(src, "data:") {
+		return src, nil
+	}
+	data, mediaType, err := b.data(src)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// cssAssetURL matches the url() references the downloader points at assets
+var cssAssetURL = regexp.MustCompile(`url\("(` + regexp.QuoteMeta(downloader.AssetScheme) + `[^"]+)"\)`)
+
+// rewriteCSS points the asset references in css at the URLs place returns
+// for them
+func (b bookImages) rewriteCSS(css string, place func(src string) (string, error)) (string, error) {
+	var err error
+	css = cssAssetURL.ReplaceAllStringFunc(css, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		var placed string
+		placed, err = place(cssAssetURL.FindStringSubmatch(ref)[1])
+		return `url("` + placed + `")`
+	})
+	return css, err
+}
+
+// embed points the book images in n at data: URLs
+func (b bookImages) embed(n *html.Node) error {
+	for _, img := range findAllNodes(n, isBookImageNode) {
+		src, err := b.dataURL(getAttr(img, "src"))
+		if err != nil {
+			return err
+		}
+		setAttr(img, "src", src)
+	}
+	return nil
+}
+
+// extract saves the book images in n to dir and points them at the saved
+// files, as seen from the document through prefix
+func (b bookImages) extract(n *html.Node, dir, prefix string) error {
+	for _, img := range findAllNodes(n, isBookImageNode) {
+		name, err := b.save(dir, getAttr(img, "src"))
+		if err != nil {
+			return err
+		}
+		setAttr(img, "src", prefix+name)
+	}
+	return nil
+}
+
+func isBookImageNode(n *html.Node) bool {
+	return n.Type == html.ElementNode && n.Data == "img" && isBookImage(getAttr(n, "src"))
+}
+
+// place saves a book image to dir and returns where it is as seen through
+// prefix. An image the book only links to keeps its URL.
+func (b bookImages) place(dir, prefix, src string) (string, error) {
+	if !isBookImage(src) {
+		return src, nil
+	}
+	name, err := b.save(dir, src)
+	if err != nil {
+		return "", err
+	}
+	return prefix + name, nil
+}
+
+// save writes an image to dir, named by its content hash so repeated
+// images are stored once, and returns the file name
+func (b bookImages) save(dir, src string) (string, error) {
+	data, mediaType, err := b.data(src)
+	if err != nil {
+		return "", err
+	}
+
+	name := downloader.NewAsset(data, mediaType).Name
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return name, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write image: %w", err)
+	}
+	return name, nil
+}
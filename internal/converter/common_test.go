@@ -1,9 +1,14 @@
 package converter
 
 import (
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"golang.org/x/net/html"
 )
 
@@ -135,3 +140,213 @@ func TestFindBookLinks(t *testing.T) {
 		}
 	}
 }
+
+// TestAnchorID verifies generated ids are valid XML ids
+func TestAnchorID(t *testing.T) {
+	chapter := downloader.Chapter{URL: "https://basecamp.com/shapeup/1.1-chapter-02"}
+
+	tests := []struct {
+		fragment string
+		want     string
+	}{
+		{"", "ch_1.1-chapter-02"},
+		{"fat marker", "ch_1.1-chapter-02_fat_marker"},
+	}
+
+	for _, tt := range tests {
+		if got := anchorID(chapter, tt.fragment); got != tt.want {
+			t.Errorf("anchorID(%q) = %s, want %s", tt.fragment, got, tt.want)
+		}
+	}
+}
+
+// TestBookImages verifies images are found whether they're downloaded
+// assets or data: URLs, and can be embedded or written out
+func TestBookImages(t *testing.T) {
+	asset := downloader.NewAsset([]byte("png-data"), "image/png")
+	images := newBookImages([]downloader.Chapter{
+		{Assets: map[string]downloader.Asset{asset.URL(): asset}},
+	})
+	dataURL := "data:image/gif;base64," + base64.StdEncoding.EncodeToString([]byte("gif-data"))
+
+	tests := []struct {
+		name     string
+		src      string
+		wantData string
+		wantType string
+		wantErr  bool
+	}{
+		{"asset", asset.URL(), "png-data", "image/png", false},
+		{"data URL", dataURL, "gif-data", "image/gif", false},
+		{"unknown asset", downloader.AssetScheme + "missing.png", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, mediaType, err := images.data(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("data() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(data) != tt.wantData || mediaType != tt.wantType {
+				t.Errorf("data() = %q, %s, want %q, %s", data, mediaType, tt.wantData, tt.wantType)
+			}
+		})
+	}
+
+	page := `<p><img src="` + asset.URL() + `"><img src="` + asset.URL() + `"><img src="https://example.com/a.png"></p>`
+
+	doc, _ := html.Parse(strings.NewReader(page))
+	if err := images.embed(doc); err != nil {
+		t.Fatalf("embed() error = %v", err)
+	}
+	srcs := imageSources(doc)
+	wantEmbedded := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png-data"))
+	if srcs[0] != wantEmbedded || srcs[2] != "https://example.com/a.png" {
+		t.Errorf("embed() sources = %v", srcs)
+	}
+
+	dir := t.TempDir()
+	doc, _ = html.Parse(strings.NewReader(page))
+	if err := images.extract(doc, dir, "images/"); err != nil {
+		t.Fatalf("extract() error = %v", err)
+	}
+	srcs = imageSources(doc)
+	if srcs[0] != srcs[1] || !strings.HasPrefix(srcs[0], "images/") || srcs[2] != "https://example.com/a.png" {
+		t.Errorf("extract() sources = %v", srcs)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("extract() wrote %v, %v, want one image", files, err)
+	}
+}
+
+func imageSources(doc *html.Node) []string {
+	var srcs []string
+	for _, img := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	}) {
+		srcs = append(srcs, getAttr(img, "src"))
+	}
+	return srcs
+}
+
+// TestLinkedImages verifies converters that write images out keep an
+// image the book only links to as a link to its URL
+func TestLinkedImages(t *testing.T) {
+	const src = "https://example.com/sketch.png"
+	tests := []struct {
+		name string
+		conv func(dir string) Converter
+		want string
+	}{
+		{"asciidoc", func(dir string) Converter { return NewAsciiDocConverter(dir) }, "image::" + src},
+		{"org", func(dir string) Converter { return NewOrgConverter(dir) }, "[[" + src + "]]"},
+		{"obsidian", func(dir string) Converter { return NewObsidianConverter(dir, false) }, "](" + src + ")"},
+		{"ssg", func(dir string) Converter { return NewSSGConverter(dir, "hugo") }, "](" + src + ")"},
+		{"gemini", func(dir string) Converter { return NewGeminiConverter(dir) }, "=> " + src},
+	}
+
+	chapters := []downloader.Chapter{{
+		Title:   "Principles of Shaping",
+		Content: `<div class="content"><p>Intro</p><figure><img src="` + src + `" alt="Sketch"></figure></div>`,
+		URL:     "https://basecamp.com/shapeup/1.1",
+		Number:  1,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := tt.conv(dir).Convert(chapters, ""); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			var found bool
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				data, err := os.ReadFile(path)
+				found = found || strings.Contains(string(data), tt.want)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if !found {
+				t.Errorf("Convert() output has no %s", tt.want)
+			}
+		})
+	}
+}
+
+// TestResolveLink verifies links are sorted into book links, external links
+// and links to render as text
+func TestResolveLink(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{Title: "Principles of Shaping", URL: "https://basecamp.com/shapeup/1.1", Number: 1},
+		{Title: "Set Boundaries", URL: "https://basecamp.com/shapeup/1.2", Number: 2},
+	}
+
+	tests := []struct {
+		name         string
+		href         string
+		wantChapter  string
+		wantFragment string
+		wantExternal bool
+	}{
+		{"empty", "", "", "", false},
+		{"back to the contents", "#toc", "", "", false},
+		{"same chapter", "#appetite", "Principles of Shaping", "appetite", false},
+		{"other chapter", "/shapeup/1.2", "Set Boundaries", "", false},
+		{"other chapter section", "https://basecamp.com/shapeup/1.2#fixed-time", "Set Boundaries", "fixed-time", false},
+		{"external", "https://basecamp.com", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chapter, fragment, external := resolveLink(tt.href, chapters[0], chapters)
+			title := ""
+			if chapter != nil {
+				title = chapter.Title
+			}
+			if title != tt.wantChapter || fragment != tt.wantFragment || external != tt.wantExternal {
+				t.Errorf("resolveLink(%q) = %q, %q, %v, want %q, %q, %v", tt.href, title, fragment, external, tt.wantChapter, tt.wantFragment, tt.wantExternal)
+			}
+		})
+	}
+}
+
+// testChapters returns two chapters the way the downloader hands them
+// over, with their image as an asset, links into the book and out of it,
+// and the chapter title linking back to the contents
+func testChapters() []downloader.Chapter {
+	image := downloader.NewAsset([]byte("png-data"), "image/png")
+
+	return []downloader.Chapter{
+		{
+			Title: "Principles of Shaping",
+			Content: `<div class="content">
+                        <h1 class="intro__title"><a href="#toc">Principles of Shaping</a></h1>
+                        <p>Read about <a href="/shapeup/1.2#fixed-time">fixed time</a> and <em>appetite</em>.</p>
+                        <ul><li>Rough</li><li>Solved<ol><li>Bounded</li></ol></li></ul>
+                        <blockquote><p>Quote</p></blockquote>
+                        <figure><img src="` + image.URL() + `" alt="Sketch"><figcaption>A sketch</figcaption></figure>
+                        <p>See <a href="https://basecamp.com">Basecamp</a>.</p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+			Assets: map[string]downloader.Asset{image.URL(): image},
+		},
+		{
+			Title: "Set Boundaries",
+			Content: `<div class="content">
+                        <h2 id="fixed-time">Fixed time, variable scope</h2>
+                        <p>Back to <a href="/shapeup/1.1">the principles</a> or <a href="#fixed-time">the top</a>.</p>
+                        <h2 id="appetite">Setting the appetite</h2>
+                        <p>Small batch or big batch.</p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.2",
+			Number: 2,
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size of the generated cover, in the 1:1.6 ratio most stores ask for
+const (
+	coverWidth  = 1600
+	coverHeight = 2560
+	coverMargin = 160
+)
+
+var (
+	coverBackground = color.RGBA{0x1d, 0x2d, 0x35, 0xff}
+	coverAccent     = color.RGBA{0xf9, 0xc9, 0x3d, 0xff}
+	coverText       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	coverMuted      = color.RGBA{0xc7, 0xd0, 0xd4, 0xff}
+)
+
+// coverImage returns the book's cover image as a data URI. It's the image
+// the downloader fetched if there is one, or a typographic cover rendered
+// from the metadata otherwise.
+func coverImage(meta downloader.Metadata) (string, error) {
+	if meta.CoverImage != "" {
+		return meta.CoverImage, nil
+	}
+
+	data, err := renderCover(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to render cover: %w", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// renderCover draws a typographic cover with the title, subtitle, author
+// and publisher and returns it as a PNG
+func renderCover(meta downloader.Metadata) ([]byte, error) {
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, coverWidth, coverHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(coverBackground), image.Point{}, draw.Src)
+
+	// An accent bar above the title, and another along the bottom edge
+	draw.Draw(img, image.Rect(coverMargin, 440, coverMargin+240, 464), image.NewUniform(coverAccent), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, coverHeight-48, coverWidth, coverHeight), image.NewUniform(coverAccent), image.Point{}, draw.Src)
+
+	y := 720
+	y, err = drawCoverText(img, bold, 200, coverText, meta.Title, y)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Subtitle != "" {
+		if _, err = drawCoverText(img, regular, 80, coverMuted, meta.Subtitle, y+80); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Author != "" {
+		if _, err = drawCoverText(img, bold, 96, coverText, meta.Author, coverHeight-520); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Publisher != "" {
+		if _, err = drawCoverText(img, regular, 64, coverAccent, meta.Publisher, coverHeight-280); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawCoverText draws text wrapped to the cover's margins with its first
+// baseline at y, and returns the baseline below the last line
+func drawCoverText(img draw.Image, f *opentype.Font, size float64, c color.Color, text string, y int) (int, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return y, err
+	}
+	defer face.Close()
+
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	lineHeight := face.Metrics().Height.Ceil() * 6 / 5
+	for _, line := range wrapCoverText(d, text, coverWidth-2*coverMargin) {
+		d.Dot = fixed.P(coverMargin, y)
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return y, nil
+}
+
+// wrapCoverText breaks text into lines no wider than width. A word wider
+// than width gets a line to itself.
+func wrapCoverText(d *font.Drawer, text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && d.MeasureString(candidate).Ceil() > width {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,159 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/validator"
+)
+
+// TestRenderCover verifies the typographic cover is a PNG of the expected
+// size with something drawn on it
+func TestRenderCover(t *testing.T) {
+	data, err := renderCover(downloader.DefaultMetadata)
+	if err != nil {
+		t.Fatalf("renderCover() error = %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("cover is not a PNG: %v", err)
+	}
+	if got := img.Bounds().Size(); got.X != coverWidth || got.Y != coverHeight {
+		t.Errorf("cover size = %v, want %dx%d", got, coverWidth, coverHeight)
+	}
+
+	// The title is drawn in white on the background
+	r, g, b, _ := img.At(coverWidth/2, coverHeight/2).RGBA()
+	background := [3]uint32{r, g, b}
+	drawn := false
+	for x := coverMargin; x < coverWidth-coverMargin && !drawn; x++ {
+		for y := 600; y < 900 && !drawn; y++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			drawn = [3]uint32{r, g, b} != background
+		}
+	}
+	if !drawn {
+		t.Error("cover has no title drawn on it")
+	}
+}
+
+// TestCoverImage verifies a fetched cover is used as is and a cover is
+// rendered when there isn't one
+func TestCoverImage(t *testing.T) {
+	fetched := "data:image/jpeg;base64,/9j/4AAQ"
+	got, err := coverImage(downloader.Metadata{CoverImage: fetched})
+	if err != nil {
+		t.Fatalf("coverImage() error = %v", err)
+	}
+	if got != fetched {
+		t.Errorf("coverImage() = %q, want %q", got, fetched)
+	}
+
+	got, err = coverImage(downloader.Metadata{Title: "Shape Up"})
+	if err != nil {
+		t.Fatalf("coverImage() error = %v", err)
+	}
+	if !strings.HasPrefix(got, "data:image/png;base64,") {
+		t.Errorf("coverImage() = %.40q, want a PNG data URI", got)
+	}
+}
+
+// TestEPUBConverter_Cover verifies the cover is the book's cover image and
+// comes first in the reading order, in both EPUB versions
+func TestEPUBConverter_Cover(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+			Number:  0,
+		},
+		{
+			Title:   "Chapter 1",
+			Content: `<div class="content"><h1>Chapter 1</h1><p>Text</p></div>`,
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		version  int
+		expected []string
+	}{
+		{
+			name:    "EPUB 3",
+			version: EPUBVersion3,
+			expected: []string{
+				`href="images/cover.png" media-type="image/png" properties="cover-image"`,
+				`<itemref idref="cover.xhtml"></itemref>`,
+			},
+		},
+		{
+			name:    "EPUB 2",
+			version: EPUBVersion2,
+			expected: []string{
+				`<meta name="cover" content="id`,
+				`href="images/cover.png" media-type="image/png"/>`,
+				`<itemref idref="cover.xhtml"/>`,
+				`<reference type="cover" title="Cover" href="xhtml/cover.xhtml"/>`,
+				`<reference type="title-page" title="Title Page" href="xhtml/section0001.xhtml"/>`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.epub")
+			conv := NewEPUBConverter(path)
+			conv.Version = tt.version
+			conv.SetMetadata(downloader.Metadata{Title: "Shape Up"})
+			if err := conv.Convert(chapters, ""); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			r, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatalf("failed to open EPUB: %v", err)
+			}
+			defer r.Close()
+
+			var opf string
+			for _, f := range r.File {
+				if f.Name == "EPUB/package.opf" {
+					rc, err := f.Open()
+					if err != nil {
+						t.Fatalf("failed to open %s: %v", f.Name, err)
+					}
+					data, _ := io.ReadAll(rc)
+					rc.Close()
+					opf = string(data)
+				}
+			}
+
+			for _, want := range tt.expected {
+				if !strings.Contains(opf, want) {
+					t.Errorf("package.opf missing %q:\n%s", want, opf)
+				}
+			}
+			if spine := opf[strings.Index(opf, "<spine"):]; strings.Index(spine, "cover.xhtml") > strings.Index(spine, "section0001.xhtml") {
+				t.Errorf("cover is not first in the spine:\n%s", spine)
+			}
+
+			problems, err := validator.ValidateEPUB(path)
+			if err != nil {
+				t.Fatalf("ValidateEPUB() error = %v", err)
+			}
+			for _, problem := range problems {
+				t.Errorf("unexpected problem: %s", problem)
+			}
+		})
+	}
+}
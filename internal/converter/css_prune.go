@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/cssprune"
+	"golang.org/x/net/html"
+)
+
+// cssPruning adds up the CSS a book's output had before and after its
+// unused rules were pruned
+type cssPruning struct {
+	before, after int
+}
+
+// prune returns css without the rules that match nothing in docs. Pruning
+// reformats what it keeps, so css is returned as it was if that's no
+// smaller.
+func (p *cssPruning) prune(css string, docs ...*html.Node) string {
+	pruned := cssprune.Prune(css, docs...)
+	if len(pruned) >= len(css) {
+		pruned = css
+	}
+	p.before += len(css)
+	p.after += len(pruned)
+	return pruned
+}
+
+// pruneStyleElements prunes the CSS of each <style> element in doc against
+// doc. Elements marked data-no-prune are left alone, for CSS that styles
+// elements scripts create.
+func (p *cssPruning) pruneStyleElements(doc *html.Node) {
+	for _, style := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "style" && !hasAttr(n, "data-no-prune")
+	}) {
+		if text := style.FirstChild; text != nil && text.Type == html.TextNode {
+			text.Data = p.prune(text.Data, doc)
+		}
+	}
+}
+
+// report writes how much smaller pruning made the CSS, if it had any
+func (p *cssPruning) report(w io.Writer) {
+	if w == nil || p.before == 0 {
+		return
+	}
+	saved := 100 - p.after*100/p.before
+	fmt.Fprintf(w, "Pruned unused CSS: %s -> %s (%d%% smaller)\n", formatSize(p.before), formatSize(p.after), saved)
+}
+
+// formatSize formats a byte count for people
+func formatSize(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f KB", float64(n)/1024)
+}
@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// TestCSSPruning_Report verifies the size report adds up every pruned
+// stylesheet and stays quiet when there was no CSS
+func TestCSSPruning_Report(t *testing.T) {
+	tests := []struct {
+		name    string
+		pruning cssPruning
+		want    string
+	}{
+		{"kilobytes", cssPruning{before: 20480, after: 5120}, "Pruned unused CSS: 20.0 KB -> 5.0 KB (75% smaller)\n"},
+		{"bytes", cssPruning{before: 200, after: 200}, "Pruned unused CSS: 200 B -> 200 B (0% smaller)\n"},
+		{"no CSS", cssPruning{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.pruning.report(&buf)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("report() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCSSPruning_Prune verifies CSS that pruning can't shrink is kept as it
+// was
+func TestCSSPruning_Prune(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<p class="note">Hi</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{"unused rule", "p{color:red}\n.nav{float:left}", "p {color:red}"},
+		{"nothing to prune", "p{color:red}", "p{color:red}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pruning cssPruning
+			if got := pruning.prune(tt.css, doc); got != tt.want {
+				t.Errorf("prune() = %q, want %q", got, tt.want)
+			}
+			if pruning.after > pruning.before {
+				t.Errorf("pruning grew the CSS from %d to %d bytes", pruning.before, pruning.after)
+			}
+		})
+	}
+}
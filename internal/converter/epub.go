@@ -1,10 +1,12 @@
 package converter
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
-	"net/http"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
@@ -14,6 +16,17 @@ import (
 
 type EPUBConverter struct {
 	OutputPath string
+	// Version is the EPUB version to write, EPUBVersion3 unless set
+	Version int
+	// A11y decides whether images without alt text fail the conversion
+	// (A11yStrict) or are reported to Warnings (A11yWarn)
+	A11y     string
+	Warnings io.Writer
+	// PruneCSS drops the stylesheet rules that match nothing in the book
+	PruneCSS bool
+	// Log receives notes on the conversion, such as how much pruning
+	// shrank the CSS
+	Log io.Writer
 	baseConverter
 }
 
@@ -24,29 +37,63 @@ func NewEPUBConverter(outputPath string) *EPUBConverter {
 
 	return &EPUBConverter{
 		OutputPath: outputPath,
+		Version:    EPUBVersion3,
+		A11y:       A11yWarn,
+		Warnings:   os.Stderr,
+		PruneCSS:   true,
+		Log:        os.Stdout,
 	}
 }
 
 func (e *EPUBConverter) Convert(chapters []downloader.Chapter, css string) error {
-	book, err := epub.NewEpub("Shape Up")
+	meta := e.metadata()
+	book, err := epub.NewEpub(meta.Title)
 	if err != nil {
 		return fmt.Errorf("failed to create new epub: %w", err)
 	}
 
 	// Set metadata
-	book.SetAuthor("Ryan Singer")
-	book.SetDescription("Stop Running in Circles and Ship Work that Matters")
-	book.SetLang("en")
+	book.SetAuthor(meta.Author)
+	book.SetDescription(meta.Description)
+	book.SetLang(meta.Language)
+	if meta.ISBN != "" {
+		book.SetIdentifier("urn:isbn:" + meta.ISBN)
+	}
+
+	// The downloaded images and fonts, and those already added to the book
+	// by file name
+	images := newBookImages(chapters)
+	addedImages := make(map[string]string)
+
+	// The book's fonts and the device's margins and workarounds apply to
+	// every section. The stylesheet is added once the sections are, so it
+	// can be pruned against them.
+	css = e.device().StyleSheet(fontRules(css))
+	stylesheet := ""
+	if css != "" {
+		stylesheet = epubStyleSheetPath
+	}
+	var sections []string
+
+	// The cover comes before everything else in the spine. go-epub names
+	// it cover.xhtml, so the sections keep their numbering.
+	if err := e.addCover(book, meta); err != nil {
+		return err
+	}
 
 	// Add title page as first section
-	titlePage, err := e.createTitlePage()
+	titlePage := e.createTitlePage()
+	titleXHTML, err := xhtmlFromHTML(titlePage)
 	if err != nil {
-		return fmt.Errorf("failed to create title page: %w", err)
+		return fmt.Errorf("failed to serialize title page as XHTML: %w", err)
 	}
-	_, err = book.AddSection(titlePage, "Title Page", "", "")
+	titleXHTML = epubLandmark("section", "titlepage", "", "", epubPageBreak(1)+titleXHTML)
+	sections = append(sections, titleXHTML)
+	titleFile, err := book.AddSection(titleXHTML, "Title Page", "", stylesheet)
 	if err != nil {
 		return fmt.Errorf("failed to add title page: %w", err)
 	}
+	pages := []epubPage{{Label: "1", Href: epubSectionHref(titleFile) + "#page-1"}}
 
 	// Extract and add TOC as first chapter
 	doc, err := html.Parse(strings.NewReader(chapters[0].Content))
@@ -64,11 +111,35 @@ func (e *EPUBConverter) Convert(chapters []downloader.Chapter, css string) error
 		return fmt.Errorf("failed to clean HTML: %w", err)
 	}
 
+	tocXHTML, err := xhtmlFromHTML(cleanToc)
+	if err != nil {
+		return fmt.Errorf("failed to serialize TOC as XHTML: %w", err)
+	}
+
 	// Add TOC as second section
-	_, err = book.AddSection(cleanToc, "Table of Contents", "", "")
+	tocXHTML = epubLandmark("nav", "toc", "doc-toc", "Table of Contents", epubPageBreak(2)+tocXHTML)
+	sections = append(sections, tocXHTML)
+	tocFile, err := book.AddSection(tocXHTML, "Table of Contents", "", stylesheet)
 	if err != nil {
 		return fmt.Errorf("failed to add TOC: %w", err)
 	}
+	pages = append(pages, epubPage{Label: "2", Href: epubSectionHref(tocFile) + "#page-2"})
+
+	// Each part opens with its title, unless the book is too short for parts
+	parts := e.organizeParts(chapters)
+	partOpeners := make(map[string]string)
+	if len(parts) > 1 {
+		for _, part := range parts {
+			if len(part.Chapters) > 0 {
+				partOpeners[chapterID(part.Chapters[0])] = part.Title
+			}
+		}
+	}
+	var missingAlt []string
+
+	// Navigation for EPUB 2, which has no nav document and nests the NCX by
+	// part, chapter and section
+	chapterNav := make(map[string]epubNavPoint)
 
 	// Process chapters
 	for _, chapter := range chapters {
@@ -90,52 +161,216 @@ func (e *EPUBConverter) Convert(chapters []downloader.Chapter, css string) error
 
 		// Process links in the chapter
 		e.processLinks(doc, chapters)
+		linkToTOC(doc, tocFile)
+		markEPUBFootnotes(doc)
 
 		// Process images in the chapter
-		if err := e.processImages(doc, book); err != nil {
+		if err := e.processImages(doc, book, images, addedImages); err != nil {
 			return fmt.Errorf("failed to process images in chapter %s: %w", chapter.Title, err)
 		}
 
-		// Render the processed content
-		var buf strings.Builder
-		if err := html.Render(&buf, doc); err != nil {
-			return fmt.Errorf("failed to render chapter content: %w", err)
+		for _, src := range missingAltText(doc) {
+			missingAlt = append(missingAlt, fmt.Sprintf("image %s in chapter %q has no alt text", src, chapter.Title))
 		}
 
+		// Serialize the processed content as well-formed XHTML
+		content, err := xhtmlFragment(doc)
+		if err != nil {
+			return fmt.Errorf("chapter %q (%s) is not valid XHTML: %w", chapter.Title, chapter.URL, err)
+		}
+
+		page := len(pages) + 1
+		content = epubLandmark("section", "chapter", "doc-chapter", chapter.Title, content)
+		if part, ok := partOpeners[chapterID(chapter)]; ok {
+			content = epubLandmark("section", "part", "doc-part", part, "<h1>"+xhtmlEscaper.Replace(part)+"</h1>") + content
+		}
+		content = epubPageBreak(page) + content
+
 		// Add processed chapter to epub
-		_, err = book.AddSection(buf.String(), chapter.Title, "", "")
+		sections = append(sections, content)
+		chapterFile, err := book.AddSection(content, chapter.Title, "", stylesheet)
 		if err != nil {
 			return fmt.Errorf("failed to add chapter %s: %w", chapter.Title, err)
 		}
+		chapterNav[chapterID(chapter)] = chapterNavPoint(chapter.Title, chapterFile, doc)
+		pages = append(pages, epubPage{Label: strconv.Itoa(page), Href: epubSectionHref(chapterFile) + "#page-" + strconv.Itoa(page)})
+	}
+
+	if len(missingAlt) > 0 && e.A11y == A11yStrict {
+		return fmt.Errorf("%d images have no alt text:\n%s", len(missingAlt), strings.Join(missingAlt, "\n"))
+	}
+	for _, warning := range missingAlt {
+		fmt.Fprintf(e.Warnings, "warning: %s\n", warning)
+	}
+
+	if err := e.addStyleSheet(book, css, sections, images, addedImages); err != nil {
+		return err
+	}
+
+	a11y := epubAccessibility{
+		Lang:    book.Lang(),
+		Pages:   pages,
+		AltText: len(missingAlt) == 0,
+	}
+
+	var nav []epubNavPoint
+	var guide []epubGuideReference
+
+	if e.Version == EPUBVersion2 {
+		nav = []epubNavPoint{
+			{Title: "Title Page", Href: epubSectionHref(titleFile)},
+			{Title: "Table of Contents", Href: epubSectionHref(tocFile)},
+		}
+		for _, part := range parts {
+			if len(part.Chapters) == 0 {
+				continue
+			}
+			point := epubNavPoint{Title: part.Title}
+			for _, chapter := range part.Chapters {
+				point.Children = append(point.Children, chapterNav[chapterID(chapter)])
+			}
+			point.Href = point.Children[0].Href
+			nav = append(nav, point)
+		}
+
+		guide = []epubGuideReference{
+			{Type: "cover", Title: "Cover", Href: epubSectionHref(epubCoverFile)},
+			{Type: "title-page", Title: "Title Page", Href: epubSectionHref(titleFile)},
+			{Type: "toc", Title: "Table of Contents", Href: epubSectionHref(tocFile)},
+			{Type: "text", Title: "Start", Href: chapterNav[chapterID(chapters[0])].Href},
+		}
+	}
+
+	return e.write(book, meta, a11y, nav, guide)
+}
+
+// write adds the metadata go-epub can't set to its EPUB 3 output and writes
+// it, converted to EPUB 2 if that's the version asked for
+func (e *EPUBConverter) write(book *epub.Epub, meta downloader.Metadata, a11y epubAccessibility, nav []epubNavPoint, guide []epubGuideReference) error {
+	var epub3 bytes.Buffer
+	if _, err := book.WriteTo(&epub3); err != nil {
+		return fmt.Errorf("failed to write epub: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := finishEPUB3(&out, epub3.Bytes(), meta, a11y); err != nil {
+		return fmt.Errorf("failed to add metadata: %w", err)
+	}
+
+	data := out.Bytes()
+	if e.Version == EPUBVersion2 {
+		var epub2 bytes.Buffer
+		if err := downgradeToEPUB2(&epub2, data, a11y, nav, guide); err != nil {
+			return err
+		}
+		data = epub2.Bytes()
+	}
+	return os.WriteFile(e.OutputPath, data, 0644)
+}
+
+// chapterNavPoint returns a chapter's navigation entry, with its h2
+// sections as children
+func chapterNavPoint(title, file string, doc *html.Node) epubNavPoint {
+	point := epubNavPoint{Title: title, Href: epubSectionHref(file)}
+	for _, heading := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "h2" && getAttr(n, "id") != ""
+	}) {
+		point.Children = append(point.Children, epubNavPoint{
+			Title: normalizeSpace(extractText(heading)),
+			Href:  point.Href + "#" + getAttr(heading, "id"),
+		})
+	}
+	return point
+}
+
+// epubSectionHref returns the path of a go-epub section relative to the
+// package document
+func epubSectionHref(file string) string {
+	return "xhtml/" + file
+}
+
+// epubStyleSheetPath is where go-epub puts the book's stylesheet, relative
+// to the sections
+const epubStyleSheetPath = "../css/book.css"
+
+// addStyleSheet adds the book's stylesheet: its fonts, from the site's CSS,
+// and the device's rules. The rest of the site's CSS is left out, since its
+// layout doesn't suit a reader. Unless PruneCSS is off, the rules that match
+// nothing in sections are dropped first.
+func (e *EPUBConverter) addStyleSheet(book *epub.Epub, css string, sections []string, images bookImages, added map[string]string) error {
+	if css == "" {
+		return nil
+	}
+	if e.PruneCSS {
+		var docs []*html.Node
+		for _, section := range sections {
+			doc, err := html.Parse(strings.NewReader(section))
+			if err != nil {
+				return fmt.Errorf("failed to parse section: %w", err)
+			}
+			docs = append(docs, doc)
+		}
+		var pruning cssPruning
+		css = pruning.prune(css, docs...)
+		pruning.report(e.Log)
 	}
 
-	return book.Write(e.OutputPath)
+	css, err := images.rewriteCSS(css, func(src string) (string, error) {
+		return addBookAsset(book, images, src, added)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add stylesheet assets: %w", err)
+	}
+	path, err := book.AddCSS("data:text/css;base64,"+base64.StdEncoding.EncodeToString([]byte(css)), "book.css")
+	if err != nil {
+		return fmt.Errorf("failed to add stylesheet: %w", err)
+	}
+	if path != epubStyleSheetPath {
+		return fmt.Errorf("stylesheet was added as %s, not %s", path, epubStyleSheetPath)
+	}
+	return nil
 }
 
-func (e *EPUBConverter) createTitlePage() (string, error) {
-	resp, err := http.Get("https://basecamp.com/assets/images/books/shapeup/cover_summary.jpeg")
+// epubCoverFile is the section go-epub creates for the cover image
+const epubCoverFile = "cover.xhtml"
+
+// addCover adds the cover image and makes it the book's cover
+func (e *EPUBConverter) addCover(book *epub.Epub, meta downloader.Metadata) error {
+	cover, err := coverImage(meta)
+	if err != nil {
+		return err
+	}
+	_, mediaType, err := decodeDataURL(cover)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to read cover: %w", err)
 	}
-	defer resp.Body.Close()
 
-	imgData, err := io.ReadAll(resp.Body)
+	coverPath, err := book.AddImage(cover, "cover"+downloader.Extension(mediaType))
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to add cover image: %w", err)
+	}
+	if err := book.SetCover(coverPath, ""); err != nil {
+		return fmt.Errorf("failed to set cover: %w", err)
 	}
+	return nil
+}
+
+func (e *EPUBConverter) createTitlePage() string {
+	meta := e.metadata()
 
-	b64Data := base64.StdEncoding.EncodeToString(imgData)
-	imgSrc := fmt.Sprintf("data:image/jpeg;base64,%s", b64Data)
+	subtitle := ""
+	if meta.Subtitle != "" {
+		subtitle = `<p class="landing-subtitle">` + html.EscapeString(meta.Subtitle) + "</p>"
+	}
 
 	return fmt.Sprintf(`
 			<div class="content" style="display: flex; flex-direction: column; justify-content: center; align-items: center; min-height: 100vh;">
-					<img src="%s" alt="Shape Up Cover" style="max-width: 80%%; margin-bottom: 2em;">
 					<div style="width: 80%%; text-align: left;">
-							<h1 class="landing-title landing-title--large">Shape Up</h1>
-							<p class="landing-subtitle">Stop Running in Circles<br class="linebreak"> and Ship Work that Matters</p>
-							<p class="landing-author"><em>by Ryan Singer</em></p>
+							<h1 class="landing-title landing-title--large">%s</h1>
+							%s
+							<p class="landing-author"><em>by %s</em></p>
 					</div>
-			</div>`, imgSrc), nil
+			</div>`, html.EscapeString(meta.Title), subtitle, html.EscapeString(meta.Author))
 }
 
 func (e *EPUBConverter) processLinks(node *html.Node, chapters []downloader.Chapter) {
@@ -154,16 +389,19 @@ func (e *EPUBConverter) processLinks(node *html.Node, chapters []downloader.Chap
 	}
 }
 
-func findChapterNumberByURL(href string, chapters []downloader.Chapter) int {
-	// Strip any section reference and prefixes
-	href = strings.Split(href, "#")[0]
-	href = strings.TrimPrefix(href, "https://basecamp.com")
-	fullURL := "https://basecamp.com" + href
+// linkToTOC points the chapter title's link back to the contents at the
+// table of contents section
+func linkToTOC(node *html.Node, tocFile string) {
+	for _, link := range findAllNodes(node, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "a" && getAttr(n, "href") == "#toc"
+	}) {
+		setAttr(link, "href", tocFile)
+	}
+}
 
-	for _, chapter := range chapters {
-		if chapter.URL == fullURL {
-			return chapter.Number + 2 // Account for title page and TOC
-		}
+func findChapterNumberByURL(href string, chapters []downloader.Chapter) int {
+	if chapter := findChapterByURL(href, chapters); chapter != nil {
+		return chapter.Number + 2 // Account for title page and TOC
 	}
 	return 3 // Default to first section if not found
 }
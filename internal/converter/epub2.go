@@ -0,0 +1,472 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// EPUB versions supported by EPUBConverter
+const (
+	EPUBVersion2 = 2
+	EPUBVersion3 = 3
+)
+
+const (
+	epubContentDir = "EPUB"
+	xhtml11Doctype = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`
+)
+
+// epubNavPoint is an entry in the book's navigation. Href is relative to the
+// package document.
+type epubNavPoint struct {
+	Title    string
+	Href     string
+	Children []epubNavPoint
+}
+
+// epubGuideReference is an entry in the EPUB 2 <guide>
+type epubGuideReference struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+// opfPackage is the part of go-epub's package document carried over to the
+// EPUB 2 package
+type opfPackage struct {
+	Metadata struct {
+		Identifier  string   `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Title       string   `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Language    string   `xml:"http://purl.org/dc/elements/1.1/ language"`
+		Description string   `xml:"http://purl.org/dc/elements/1.1/ description"`
+		Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Publisher   string   `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+		Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Rights      string   `xml:"http://purl.org/dc/elements/1.1/ rights"`
+		Source      string   `xml:"http://purl.org/dc/elements/1.1/ source"`
+		Meta        []struct {
+			Property string `xml:"property,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// xhtml11Replacements maps HTML5-only elements to their XHTML 1.1 stand-ins
+var xhtml11Replacements = map[string]string{
+	"section":    "div",
+	"article":    "div",
+	"nav":        "div",
+	"header":     "div",
+	"footer":     "div",
+	"main":       "div",
+	"aside":      "div",
+	"figure":     "div",
+	"figcaption": "div",
+	"mark":       "span",
+	"time":       "span",
+}
+
+// xhtml11Attributes lists the attributes kept when converting to XHTML 1.1
+var xhtml11Attributes = map[string]bool{
+	"id": true, "class": true, "title": true, "style": true, "dir": true,
+	"href": true, "src": true, "alt": true, "width": true, "height": true,
+	"colspan": true, "rowspan": true, "scope": true, "abbr": true,
+	"summary": true, "cite": true,
+}
+
+// downgradeToEPUB2 rewrites an EPUB 3 package produced by go-epub as EPUB 2:
+// an OPF 2.0 package with a guide, an NCX built from nav with a. Pages as its
+// page list, no EPUB 3 navigation document and XHTML 1.1 content documents
+// in a.Lang
+func downgradeToEPUB2(w io.Writer, epub3 []byte, a epubAccessibility, nav []epubNavPoint, guide []epubGuideReference) error {
+	src, err := zip.NewReader(bytes.NewReader(epub3), int64(len(epub3)))
+	if err != nil {
+		return fmt.Errorf("failed to read EPUB: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	var names []string
+	for _, f := range src.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+		files[f.Name] = data
+		names = append(names, f.Name)
+	}
+
+	var pkg opfPackage
+	if err := xml.Unmarshal(files[epubContentDir+"/package.opf"], &pkg); err != nil {
+		return fmt.Errorf("failed to parse package document: %w", err)
+	}
+
+	dst := zip.NewWriter(w)
+
+	// The mimetype must come first and be stored uncompressed
+	if err := writeZipFile(dst, "mimetype", []byte("application/epub+zip"), zip.Store); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		data := files[name]
+		switch {
+		case name == "mimetype":
+			continue
+		case name == epubContentDir+"/package.opf":
+			data = []byte(epub2Package(pkg, guide))
+		case name == epubContentDir+"/toc.ncx":
+			data = []byte(epub2NCX(pkg, nav, a.Pages))
+		case isNavDocument(pkg, name):
+			continue
+		case strings.HasSuffix(name, ".xhtml"):
+			data, err = xhtml11Document(data, a.Lang)
+			if err != nil {
+				return fmt.Errorf("failed to convert %s to XHTML 1.1: %w", path.Base(name), err)
+			}
+		}
+		if err := writeZipFile(dst, name, data, zip.Deflate); err != nil {
+			return err
+		}
+	}
+
+	return dst.Close()
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	return data, nil
+}
+
+func writeZipFile(w *zip.Writer, name string, data []byte, method uint16) error {
+	f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// isNavDocument reports whether name is the EPUB 3 navigation document
+func isNavDocument(pkg opfPackage, name string) bool {
+	for _, item := range pkg.Manifest {
+		if item.Properties == "nav" && epubContentDir+"/"+item.Href == name {
+			return true
+		}
+	}
+	return false
+}
+
+// epub2Package returns an OPF 2.0 package document with go-epub's metadata,
+// manifest and spine
+func epub2Package(pkg opfPackage, guide []epubGuideReference) string {
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	out.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="pub-id">` + "\n")
+	out.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">` + "\n")
+	out.WriteString(`    <dc:identifier id="pub-id">` + escapeXML(pkg.Metadata.Identifier) + "</dc:identifier>\n")
+	out.WriteString("    <dc:title>" + escapeXML(pkg.Metadata.Title) + "</dc:title>\n")
+	for _, creator := range pkg.Metadata.Creators {
+		out.WriteString(`    <dc:creator opf:role="aut">` + escapeXML(creator) + "</dc:creator>\n")
+	}
+	out.WriteString("    <dc:language>" + escapeXML(pkg.Metadata.Language) + "</dc:language>\n")
+	if pkg.Metadata.Description != "" {
+		out.WriteString("    <dc:description>" + escapeXML(pkg.Metadata.Description) + "</dc:description>\n")
+	}
+	for _, element := range [][2]string{
+		{"dc:publisher", pkg.Metadata.Publisher},
+		{"dc:date", pkg.Metadata.Date},
+		{"dc:rights", pkg.Metadata.Rights},
+		{"dc:source", pkg.Metadata.Source},
+	} {
+		if element[1] != "" {
+			out.WriteString("    <" + element[0] + ">" + escapeXML(element[1]) + "</" + element[0] + ">\n")
+		}
+	}
+	for _, item := range pkg.Manifest {
+		if item.Properties == "cover-image" {
+			out.WriteString(`    <meta name="cover" content="` + escapeXML(item.ID) + `"/>` + "\n")
+		}
+	}
+	// Accessibility metadata uses name and content attributes in EPUB 2
+	for _, meta := range pkg.Metadata.Meta {
+		if strings.HasPrefix(meta.Property, "schema:") {
+			out.WriteString(`    <meta name="` + escapeXML(meta.Property) + `" content="` + escapeXML(meta.Value) + `"/>` + "\n")
+		}
+	}
+	out.WriteString("  </metadata>\n")
+
+	// EPUB 2 has no manifest properties, and no navigation document
+	out.WriteString("  <manifest>\n")
+	for _, item := range pkg.Manifest {
+		if item.Properties == "nav" {
+			continue
+		}
+		out.WriteString(`    <item id="` + escapeXML(item.ID) + `" href="` + escapeXML(item.Href) + `" media-type="` + escapeXML(item.MediaType) + `"/>` + "\n")
+	}
+	out.WriteString("  </manifest>\n")
+
+	out.WriteString(`  <spine toc="ncx">` + "\n")
+	for _, itemref := range pkg.Spine {
+		out.WriteString(`    <itemref idref="` + escapeXML(itemref.IDRef) + `"/>` + "\n")
+	}
+	out.WriteString("  </spine>\n")
+
+	out.WriteString("  <guide>\n")
+	for _, ref := range guide {
+		out.WriteString(`    <reference type="` + escapeXML(ref.Type) + `" title="` + escapeXML(ref.Title) + `" href="` + escapeXML(ref.Href) + `"/>` + "\n")
+	}
+	out.WriteString("  </guide>\n")
+	out.WriteString("</package>\n")
+	return out.String()
+}
+
+// epub2NCX returns an NCX with nav as nested navPoints and pages as its page
+// list. Entries pointing at the same place share a playOrder, as the NCX
+// spec requires.
+func epub2NCX(pkg opfPackage, nav []epubNavPoint, pages []epubPage) string {
+	playOrders := make(map[string]int)
+	count := 0
+
+	var points strings.Builder
+	var write func(nav []epubNavPoint, indent string) int
+	write = func(nav []epubNavPoint, indent string) int {
+		depth := 0
+		for _, point := range nav {
+			order, ok := playOrders[point.Href]
+			if !ok {
+				order = len(playOrders) + 1
+				playOrders[point.Href] = order
+			}
+			count++
+
+			points.WriteString(indent + `<navPoint id="navPoint-` + strconv.Itoa(count) + `" playOrder="` + strconv.Itoa(order) + `">` + "\n")
+			points.WriteString(indent + "  <navLabel><text>" + escapeXML(point.Title) + "</text></navLabel>\n")
+			points.WriteString(indent + `  <content src="` + escapeXML(point.Href) + `"/>` + "\n")
+			depth = max(depth, write(point.Children, indent+"  ")+1)
+			points.WriteString(indent + "</navPoint>\n")
+		}
+		return depth
+	}
+	depth := write(nav, "    ")
+
+	var pageList strings.Builder
+	for i, page := range pages {
+		// A page break opens its section, so it plays with the section
+		href, _, _ := strings.Cut(page.Href, "#")
+		order, ok := playOrders[href]
+		if !ok {
+			order = len(playOrders) + 1
+			playOrders[href] = order
+		}
+
+		pageList.WriteString(`    <pageTarget id="pageTarget-` + strconv.Itoa(i+1) + `" type="normal" value="` + escapeXML(page.Label) + `" playOrder="` + strconv.Itoa(order) + `">` + "\n")
+		pageList.WriteString("      <navLabel><text>" + escapeXML(page.Label) + "</text></navLabel>\n")
+		pageList.WriteString(`      <content src="` + escapeXML(page.Href) + `"/>` + "\n")
+		pageList.WriteString("    </pageTarget>\n")
+	}
+
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	out.WriteString(`<!DOCTYPE ncx PUBLIC "-//NISO//DTD ncx 2005-1//EN" "http://www.daisy.org/z3986/2005/ncx-2005-1.dtd">` + "\n")
+	out.WriteString(`<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">` + "\n")
+	out.WriteString("  <head>\n")
+	out.WriteString(`    <meta name="dtb:uid" content="` + escapeXML(pkg.Metadata.Identifier) + `"/>` + "\n")
+	out.WriteString(`    <meta name="dtb:depth" content="` + strconv.Itoa(depth) + `"/>` + "\n")
+	out.WriteString(`    <meta name="dtb:totalPageCount" content="` + strconv.Itoa(len(pages)) + `"/>` + "\n")
+	out.WriteString(`    <meta name="dtb:maxPageNumber" content="` + strconv.Itoa(len(pages)) + `"/>` + "\n")
+	out.WriteString("  </head>\n")
+	out.WriteString("  <docTitle><text>" + escapeXML(pkg.Metadata.Title) + "</text></docTitle>\n")
+	for _, creator := range pkg.Metadata.Creators {
+		out.WriteString("  <docAuthor><text>" + escapeXML(creator) + "</text></docAuthor>\n")
+	}
+	out.WriteString("  <navMap>\n")
+	out.WriteString(points.String())
+	out.WriteString("  </navMap>\n")
+	if len(pages) > 0 {
+		out.WriteString("  <pageList>\n")
+		out.WriteString("    <navLabel><text>Pages</text></navLabel>\n")
+		out.WriteString(pageList.String())
+		out.WriteString("  </pageList>\n")
+	}
+	out.WriteString("</ncx>\n")
+	return out.String()
+}
+
+// xhtml11Document rewrites a go-epub content document as XHTML 1.1 in the
+// book's language
+func xhtml11Document(data []byte, lang string) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	head := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "head"
+	})
+	body := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "body"
+	})
+	if head == nil || body == nil {
+		return nil, fmt.Errorf("document has no head or body")
+	}
+
+	var title string
+	if t := findNode(head, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "title"
+	}); t != nil {
+		title = extractText(t)
+	}
+
+	toXHTML11(body)
+
+	var out bytes.Buffer
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	out.WriteString(xhtml11Doctype + "\n")
+	out.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="` + escapeXML(lang) + `">` + "\n")
+	out.WriteString("<head>\n")
+	out.WriteString("<title>" + escapeXML(title) + "</title>\n")
+	for _, link := range findAllNodes(head, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "link" && getAttr(n, "rel") == "stylesheet"
+	}) {
+		out.WriteString(`<link rel="stylesheet" type="text/css" href="` + escapeXML(getAttr(link, "href")) + `"/>` + "\n")
+	}
+	out.WriteString("</head>\n<body>\n")
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := renderXHTML(&out, c); err != nil {
+			return nil, err
+		}
+	}
+	out.WriteString("\n</body>\n</html>\n")
+
+	return out.Bytes(), nil
+}
+
+// toXHTML11 replaces HTML5-only elements, drops attributes and elements
+// XHTML 1.1 doesn't allow and wraps loose inline content where only blocks
+// are allowed
+func toXHTML11(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		switch c.Type {
+		case html.CommentNode:
+			n.RemoveChild(c)
+		case html.ElementNode:
+			switch c.Data {
+			case "video", "audio", "source", "track", "canvas", "template", "script", "svg", "wbr":
+				n.RemoveChild(c)
+				c = next
+				continue
+			case "picture":
+				// Keep the fallback image
+				img := findNode(c, func(n *html.Node) bool {
+					return n.Type == html.ElementNode && n.Data == "img"
+				})
+				if img == nil {
+					n.RemoveChild(c)
+					c = next
+					continue
+				}
+				img.Parent.RemoveChild(img)
+				n.InsertBefore(img, c)
+				n.RemoveChild(c)
+				c = img
+			}
+
+			if replacement, ok := xhtml11Replacements[c.Data]; ok {
+				addClass(c, c.Data)
+				c.Data = replacement
+				c.DataAtom = 0
+			}
+
+			var attrs []html.Attribute
+			hasLang := false
+			for _, attr := range c.Attr {
+				switch {
+				// The HTML parser keeps xml:lang as a plain attribute name
+				case attr.Key == "lang" || attr.Key == "xml:lang":
+					if !hasLang {
+						attrs = append(attrs, html.Attribute{Namespace: "xml", Key: "lang", Val: attr.Val})
+						hasLang = true
+					}
+				case attr.Namespace == "" && xhtml11Attributes[attr.Key]:
+					attrs = append(attrs, attr)
+				}
+			}
+			c.Attr = attrs
+
+			toXHTML11(c)
+		}
+
+		c = next
+	}
+
+	if n.Type == html.ElementNode && (n.Data == "body" || n.Data == "blockquote") {
+		wrapInlineRuns(n)
+	}
+}
+
+// wrapInlineRuns wraps text and inline elements that sit directly inside n in
+// paragraphs
+func wrapInlineRuns(n *html.Node) {
+	var p *html.Node
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		// Whitespace only joins a paragraph that's already open
+		inline := (c.Type == html.TextNode && (p != nil || strings.TrimSpace(c.Data) != "")) ||
+			(c.Type == html.ElementNode && !isBlockElement(c.Data) && c.Data != "dl" && c.Data != "hr")
+		if !inline {
+			p = nil
+			c = next
+			continue
+		}
+
+		if p == nil {
+			p = &html.Node{Type: html.ElementNode, Data: "p"}
+			n.InsertBefore(p, c)
+		}
+		n.RemoveChild(c)
+		p.AppendChild(c)
+		c = next
+	}
+}
+
+// addClass adds a class to an element's class attribute
+func addClass(n *html.Node, class string) {
+	for i, attr := range n.Attr {
+		if attr.Key == "class" {
+			n.Attr[i].Val = strings.TrimSpace(attr.Val + " " + class)
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: "class", Val: class})
+}
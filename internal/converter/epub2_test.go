@@ -0,0 +1,189 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-epub"
+	"golang.org/x/net/html"
+)
+
+// TestDowngradeToEPUB2 verifies the package, NCX and content documents of
+// an EPUB 2 conversion
+func TestDowngradeToEPUB2(t *testing.T) {
+	book, err := epub.NewEpub("Shape Up")
+	if err != nil {
+		t.Fatalf("NewEpub() error = %v", err)
+	}
+	book.SetAuthor("Ryan Singer")
+
+	titleFile, err := book.AddSection(`<div class="content"><h1>Shape Up</h1></div>`, "Title Page", "", "")
+	if err != nil {
+		t.Fatalf("AddSection() error = %v", err)
+	}
+	chapterFile, err := book.AddSection(`<html><head></head><body>
+        <section data-x="1"><h2 id="fixed-time">Fixed time</h2>
+        <figure><picture><source srcset="a.webp"><img src="../images/a.png" alt="Sketch" loading="lazy"></picture><figcaption>Caption</figcaption></figure>
+        <blockquote>Loose <em>quote</em></blockquote>
+        <!-- comment --></section></body></html>`, "Chapter 1", "", "")
+	if err != nil {
+		t.Fatalf("AddSection() error = %v", err)
+	}
+
+	var epub3 bytes.Buffer
+	if _, err := book.WriteTo(&epub3); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	chapter := epubNavPoint{Title: "Chapter 1", Href: epubSectionHref(chapterFile), Children: []epubNavPoint{
+		{Title: "Fixed time", Href: epubSectionHref(chapterFile) + "#fixed-time"},
+	}}
+	nav := []epubNavPoint{
+		{Title: "Title Page", Href: epubSectionHref(titleFile)},
+		{Title: "Part 1", Href: chapter.Href, Children: []epubNavPoint{chapter}},
+	}
+	guide := []epubGuideReference{
+		{Type: "cover", Title: "Cover", Href: epubSectionHref(titleFile)},
+		{Type: "text", Title: "Start", Href: chapter.Href},
+	}
+
+	a11y := epubAccessibility{Lang: "de", Pages: []epubPage{
+		{Label: "1", Href: epubSectionHref(titleFile) + "#page-1"},
+		{Label: "2", Href: epubSectionHref(chapterFile) + "#page-2"},
+	}}
+
+	var out bytes.Buffer
+	if err := downgradeToEPUB2(&out, epub3.Bytes(), a11y, nav, guide); err != nil {
+		t.Fatalf("downgradeToEPUB2() error = %v", err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if r.File[0].Name != "mimetype" || r.File[0].Method != zip.Store {
+		t.Errorf("mimetype must be the first, uncompressed entry, got %s", r.File[0].Name)
+	}
+
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	if _, ok := files["EPUB/nav.xhtml"]; ok {
+		t.Error("EPUB 2 package still contains the EPUB 3 nav document")
+	}
+
+	expected := map[string][]string{
+		"EPUB/package.opf": {
+			`version="2.0"`,
+			`<dc:creator opf:role="aut">Ryan Singer</dc:creator>`,
+			`<reference type="cover" title="Cover" href="xhtml/section0001.xhtml"/>`,
+			`<reference type="text" title="Start" href="xhtml/section0002.xhtml"/>`,
+		},
+		"EPUB/toc.ncx": {
+			`<meta name="dtb:depth" content="3"/>`,
+			// The part and its first chapter share a playOrder
+			`<navPoint id="navPoint-2" playOrder="2">`,
+			`<navPoint id="navPoint-3" playOrder="2">`,
+			`<navPoint id="navPoint-4" playOrder="3">`,
+			`<content src="xhtml/section0002.xhtml#fixed-time"/>`,
+			`<meta name="dtb:totalPageCount" content="2"/>`,
+			// Page breaks open their section, so they share its playOrder
+			`<pageTarget id="pageTarget-1" type="normal" value="1" playOrder="1">`,
+			`<pageTarget id="pageTarget-2" type="normal" value="2" playOrder="2">`,
+			`<content src="xhtml/section0002.xhtml#page-2"/>`,
+		},
+		"EPUB/xhtml/section0002.xhtml": {
+			xhtml11Doctype,
+			`<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="de">`,
+			`<div class="section"><h2 id="fixed-time">Fixed time</h2>`,
+			`<div class="figure"><img src="../images/a.png" alt="Sketch"/><div class="figcaption">Caption</div></div>`,
+			`<blockquote><p>Loose <em>quote</em></p></blockquote>`,
+		},
+	}
+	for file, wants := range expected {
+		for _, want := range wants {
+			if !strings.Contains(files[file], want) {
+				t.Errorf("%s missing %q:\n%s", file, want, files[file])
+			}
+		}
+	}
+
+	if strings.Contains(files["EPUB/package.opf"], "properties=") {
+		t.Error("EPUB 2 manifest must not use properties")
+	}
+	for _, unwanted := range []string{"<section", "<figure", "<picture", "data-x", "loading=", "<!--"} {
+		if strings.Contains(files["EPUB/xhtml/section0002.xhtml"], unwanted) {
+			t.Errorf("content document still contains %q", unwanted)
+		}
+	}
+
+	// Every rewritten document must be well-formed XML
+	for _, name := range []string{"EPUB/package.opf", "EPUB/toc.ncx", "EPUB/xhtml/section0001.xhtml", "EPUB/xhtml/section0002.xhtml"} {
+		decoder := xml.NewDecoder(strings.NewReader(files[name]))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+		}
+	}
+}
+
+// TestXHTML11Document_Lang verifies language attributes, whichever way
+// they are written, become a single xml:lang
+func TestXHTML11Document_Lang(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"lang", `<p lang="fr">Bonjour</p>`, `<p xml:lang="fr">Bonjour</p>`},
+		{"xml:lang", `<p xml:lang="fr">Bonjour</p>`, `<p xml:lang="fr">Bonjour</p>`},
+		{"both", `<p lang="es" xml:lang="es">Hola</p>`, `<p xml:lang="es">Hola</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xhtml11Document([]byte(`<html><head><title>T</title></head><body>`+tt.input+`</body></html>`), "en")
+			if err != nil {
+				t.Fatalf("xhtml11Document() error = %v", err)
+			}
+			if !strings.Contains(string(got), tt.want) {
+				t.Errorf("xhtml11Document() = %s, want it to contain %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWrapInlineRuns verifies loose inline content is wrapped in paragraphs
+func TestWrapInlineRuns(t *testing.T) {
+	body, err := parseBody("Text <em>em</em> <p>Block</p> tail")
+	if err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	wrapInlineRuns(body)
+
+	var buf strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	want := "<p>Text <em>em</em> </p><p>Block</p><p> tail</p>"
+	if buf.String() != want {
+		t.Errorf("wrapInlineRuns() = %q, want %q", buf.String(), want)
+	}
+}
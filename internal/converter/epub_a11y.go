@@ -0,0 +1,182 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// Accessibility modes for EPUBConverter, which decide what happens when an
+// image has no alt text
+const (
+	A11yWarn   = "warn"
+	A11yStrict = "strict"
+)
+
+// A11yModes lists the supported accessibility modes
+var A11yModes = []string{A11yWarn, A11yStrict}
+
+// htmlStartTag matches the start of a content document's root element
+var htmlStartTag = regexp.MustCompile(`<html\b`)
+
+// epubPage is an entry in the page list. The web edition has no print
+// pages, so each section of the book starts a new page.
+type epubPage struct {
+	Label string
+	Href  string
+}
+
+// epubAccessibility describes what the package metadata and navigation
+// document should say about the book
+type epubAccessibility struct {
+	Lang  string
+	Pages []epubPage
+	// AltText is true when every image has a text alternative
+	AltText bool
+}
+
+// epubPageBreak returns the page break marker for a page
+func epubPageBreak(page int) string {
+	label := strconv.Itoa(page)
+	return `<span id="page-` + label + `" epub:type="pagebreak" role="doc-pagebreak" aria-label="` + label + `"></span>`
+}
+
+// epubLandmark wraps XHTML content in an element with an EPUB structural
+// type and an ARIA role. Either may be empty.
+func epubLandmark(tag, epubType, role, label, content string) string {
+	var out strings.Builder
+	out.WriteString("<" + tag)
+	if epubType != "" {
+		out.WriteString(` epub:type="` + epubType + `"`)
+	}
+	if role != "" {
+		out.WriteString(` role="` + role + `"`)
+	}
+	if label != "" {
+		out.WriteString(` aria-label="` + xhtmlEscaper.Replace(label) + `"`)
+	}
+	out.WriteString(">" + content + "</" + tag + ">")
+	return out.String()
+}
+
+// missingAltText returns the src of each image in doc without alt text
+func missingAltText(doc *html.Node) []string {
+	var missing []string
+	for _, img := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	}) {
+		if strings.TrimSpace(getAttr(img, "alt")) == "" {
+			missing = append(missing, getAttr(img, "src"))
+		}
+	}
+	return missing
+}
+
+// accessibilityMetadata returns the schema.org accessibility metadata for
+// an EPUB 3 package document. It claims no conformance, since nothing
+// evaluates the book against WCAG, and no page navigation, since the page
+// list follows the sections rather than a print edition.
+func accessibilityMetadata(a epubAccessibility) string {
+	features := []string{"structuralNavigation", "tableOfContents", "readingOrder"}
+	sufficient := "textual,visual"
+	summary := "Chapters and sections are marked up with headings and ARIA roles, and the book has a table of contents."
+	if a.AltText {
+		features = append(features, "alternativeText")
+		sufficient = "textual"
+		summary += " Every image has a text alternative."
+	} else {
+		summary += " Some images have no text alternative."
+	}
+
+	var out strings.Builder
+	meta := func(property, value string) {
+		out.WriteString(`    <meta property="` + property + `">` + escapeXML(value) + "</meta>\n")
+	}
+	meta("schema:accessMode", "textual")
+	meta("schema:accessMode", "visual")
+	meta("schema:accessModeSufficient", sufficient)
+	for _, feature := range features {
+		meta("schema:accessibilityFeature", feature)
+	}
+	meta("schema:accessibilityHazard", "none")
+	meta("schema:accessibilitySummary", summary)
+	return out.String()
+}
+
+// publicationMetadata returns the Dublin Core elements for the publisher,
+// date, rights and source of the book
+func publicationMetadata(meta downloader.Metadata) string {
+	var out strings.Builder
+	for _, element := range [][2]string{
+		{"dc:publisher", meta.Publisher},
+		{"dc:date", meta.Date},
+		{"dc:rights", meta.Copyright},
+		{"dc:source", meta.URL},
+	} {
+		if element[1] != "" {
+			out.WriteString("    <" + element[0] + ">" + escapeXML(element[1]) + "</" + element[0] + ">\n")
+		}
+	}
+	return out.String()
+}
+
+// pageListNav returns the page-list navigation for the navigation document
+func pageListNav(pages []epubPage) string {
+	var out strings.Builder
+	out.WriteString(`    <nav epub:type="page-list" role="doc-pagelist" hidden="hidden">` + "\n")
+	out.WriteString("      <h2>Pages</h2>\n")
+	out.WriteString("      <ol>\n")
+	for _, page := range pages {
+		out.WriteString(`        <li><a href="` + escapeXML(page.Href) + `">` + escapeXML(page.Label) + "</a></li>\n")
+	}
+	out.WriteString("      </ol>\n")
+	out.WriteString("    </nav>\n")
+	return out.String()
+}
+
+// finishEPUB3 rewrites an EPUB 3 package produced by go-epub with the
+// metadata go-epub has no setters for, accessibility metadata, a page list,
+// an ARIA role on the table of contents and a language on every content
+// document
+func finishEPUB3(w io.Writer, epub []byte, meta downloader.Metadata, a epubAccessibility) error {
+	src, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		return fmt.Errorf("failed to read EPUB: %w", err)
+	}
+
+	lang := `<html lang="` + escapeXML(a.Lang) + `" xml:lang="` + escapeXML(a.Lang) + `"`
+
+	dst := zip.NewWriter(w)
+	for _, f := range src.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+
+		content := string(data)
+		switch {
+		case f.Name == epubContentDir+"/package.opf":
+			content = strings.Replace(content, "</metadata>", publicationMetadata(meta)+accessibilityMetadata(a)+"  </metadata>", 1)
+		case f.Name == epubContentDir+"/nav.xhtml":
+			content = strings.Replace(content, `<nav epub:type="toc">`, `<nav epub:type="toc" role="doc-toc">`, 1)
+			content = strings.Replace(content, "</body>", pageListNav(a.Pages)+"  </body>", 1)
+		}
+		if strings.HasSuffix(f.Name, ".xhtml") {
+			content = htmlStartTag.ReplaceAllLiteralString(content, lang)
+		}
+
+		// The mimetype is always the first file and must stay uncompressed
+		if err := writeZipFile(dst, f.Name, []byte(content), f.Method); err != nil {
+			return err
+		}
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,135 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/validator"
+	"github.com/go-shiori/go-epub"
+	"golang.org/x/net/html"
+)
+
+// TestFinishEPUB3 verifies the metadata, page list, roles and languages
+// added to an EPUB 3 package, and that the result validates
+func TestFinishEPUB3(t *testing.T) {
+	book, err := epub.NewEpub("Shape Up")
+	if err != nil {
+		t.Fatalf("NewEpub() error = %v", err)
+	}
+	book.SetLang("en")
+
+	chapter := epubPageBreak(1) + epubLandmark("section", "chapter", "doc-chapter", `Principles of "Shaping"`, `<h1>Principles of Shaping</h1>`)
+	chapterFile, err := book.AddSection(chapter, "Principles of Shaping", "", "")
+	if err != nil {
+		t.Fatalf("AddSection() error = %v", err)
+	}
+
+	var epub3 bytes.Buffer
+	if _, err := book.WriteTo(&epub3); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	a11y := epubAccessibility{
+		Lang:    "en",
+		Pages:   []epubPage{{Label: "1", Href: epubSectionHref(chapterFile) + "#page-1"}},
+		AltText: true,
+	}
+	var out bytes.Buffer
+	meta := downloader.Metadata{Publisher: "Basecamp", Date: "2019", Copyright: "© 2019 Basecamp & Ryan Singer"}
+	if err := finishEPUB3(&out, epub3.Bytes(), meta, a11y); err != nil {
+		t.Fatalf("finishEPUB3() error = %v", err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	expected := map[string][]string{
+		"EPUB/package.opf": {
+			`<dc:publisher>Basecamp</dc:publisher>`,
+			`<dc:date>2019</dc:date>`,
+			`<dc:rights>© 2019 Basecamp &amp; Ryan Singer</dc:rights>`,
+			`<meta property="schema:accessMode">textual</meta>`,
+			`<meta property="schema:accessModeSufficient">textual</meta>`,
+			`<meta property="schema:accessibilityFeature">alternativeText</meta>`,
+			`<meta property="schema:accessibilityHazard">none</meta>`,
+			`<meta property="schema:accessibilitySummary">`,
+		},
+		"EPUB/nav.xhtml": {
+			`<html lang="en" xml:lang="en"`,
+			`<nav epub:type="toc" role="doc-toc">`,
+			`<nav epub:type="page-list" role="doc-pagelist" hidden="hidden">`,
+			`<li><a href="xhtml/section0001.xhtml#page-1">1</a></li>`,
+		},
+		"EPUB/xhtml/section0001.xhtml": {
+			`<html lang="en" xml:lang="en"`,
+			`<span id="page-1" epub:type="pagebreak" role="doc-pagebreak" aria-label="1"></span>`,
+			`<section epub:type="chapter" role="doc-chapter" aria-label="Principles of &quot;Shaping&quot;">`,
+		},
+	}
+	for name, wants := range expected {
+		for _, want := range wants {
+			if !strings.Contains(files[name], want) {
+				t.Errorf("%s missing %q:\n%s", name, want, files[name])
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write EPUB: %v", err)
+	}
+	problems, err := validator.ValidateEPUB(path)
+	if err != nil {
+		t.Fatalf("ValidateEPUB() error = %v", err)
+	}
+	for _, problem := range problems {
+		t.Errorf("unexpected problem: %s", problem)
+	}
+}
+
+// TestAccessibilityMetadata_MissingAltText verifies books with undescribed
+// images don't claim alternative text
+func TestAccessibilityMetadata_MissingAltText(t *testing.T) {
+	metadata := accessibilityMetadata(epubAccessibility{Lang: "en"})
+
+	for _, unwanted := range []string{"alternativeText"} {
+		if strings.Contains(metadata, unwanted) {
+			t.Errorf("metadata should not contain %q:\n%s", unwanted, metadata)
+		}
+	}
+	if !strings.Contains(metadata, `<meta property="schema:accessModeSufficient">textual,visual</meta>`) {
+		t.Errorf("metadata should not claim text alone is sufficient:\n%s", metadata)
+	}
+}
+
+// TestMissingAltText verifies images without alt text are found
+func TestMissingAltText(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<img src="a.png" alt="Sketch"><img src="b.png"><img src="c.png" alt="  "><img src="d.png" alt="">`))
+	if err != nil {
+		t.Fatalf("failed to parse HTML: %v", err)
+	}
+
+	got := missingAltText(doc)
+	want := []string{"b.png", "c.png", "d.png"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("missingAltText() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	cssComment    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssFontFace   = regexp.MustCompile(`@font-face\s*\{[^}]*\}`)
+	cssRule       = regexp.MustCompile(`([^{}@;]+)\{([^{}]*)\}`)
+	cssFontFamily = regexp.MustCompile(`font-family\s*:\s*([^;}]+)`)
+)
+
+// fontRules returns the @font-face rules in css, followed by the selector
+// and font-family of each rule that uses one of their fonts, so the book's
+// typography carries over without the rest of the site's layout
+func fontRules(css string) string {
+	css = cssComment.ReplaceAllString(css, "")
+
+	faces := cssFontFace.FindAllString(css, -1)
+	families := make(map[string]bool)
+	for _, face := range faces {
+		if m := cssFontFamily.FindStringSubmatch(face); m != nil {
+			families[fontName(m[1])] = true
+		}
+	}
+
+	rules := faces
+	for _, m := range cssRule.FindAllStringSubmatch(cssFontFace.ReplaceAllString(css, ""), -1) {
+		family := cssFontFamily.FindStringSubmatch(m[2])
+		if family == nil {
+			continue
+		}
+		first, _, _ := strings.Cut(family[1], ",")
+		if !families[fontName(first)] {
+			continue
+		}
+		rules = append(rules, strings.TrimSpace(m[1])+" { font-family: "+strings.TrimSpace(family[1])+"; }")
+	}
+	return strings.Join(rules, "\n")
+}
+
+// fontName normalizes a font family name for comparison
+func fontName(family string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(family), `"'`))
+}
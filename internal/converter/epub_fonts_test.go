@@ -0,0 +1,183 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/validator"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// TestFontRules verifies only the font faces and the rules that use them
+// are kept from the site's CSS
+func TestFontRules(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{
+			name: "faces and their uses",
+			css: `/* Book fonts */
+@font-face { font-family: "Book Sans"; src: url("asset:a.woff2"); }
+body { margin: 2em; font-family: 'Book Sans', sans-serif; color: #222; }
+.nav, .sidebar { float: left; }
+@media (min-width: 40em) { h1 { font-family: "book sans"; font-size: 3em; } }
+code { font-family: Menlo, monospace; }`,
+			want: `@font-face { font-family: "Book Sans"; src: url("asset:a.woff2"); }
+body { font-family: 'Book Sans', sans-serif; }
+h1 { font-family: "book sans"; }`,
+		},
+		{"no fonts", "body { font-family: Georgia; }", ""},
+		{"no CSS", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fontRules(tt.css); got != tt.want {
+				t.Errorf("fontRules() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEPUBConverter_Fonts verifies the book's fonts are added to the EPUB
+// and its stylesheet points at them, in both EPUB versions
+func TestEPUBConverter_Fonts(t *testing.T) {
+	font := downloader.NewAsset(goregular.TTF, "font/ttf")
+	css := `@font-face { font-family: Go; src: url("` + font.URL() + `"); }
+body { font-family: Go, serif; float: left; }`
+
+	for _, version := range []int{EPUBVersion2, EPUBVersion3} {
+		t.Run(fmt.Sprintf("EPUB %d", version), func(t *testing.T) {
+			chapters := []downloader.Chapter{
+				{
+					Title:   "Table of Contents",
+					Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+					URL:     "https://basecamp.com/shapeup/toc",
+					Assets:  map[string]downloader.Asset{font.URL(): font},
+				},
+				{
+					Title:   "Chapter 1",
+					Content: "<div class='content'><h1>Test Content</h1></div>",
+					URL:     "https://basecamp.com/shapeup/1.1",
+					Number:  1,
+				},
+			}
+
+			path := filepath.Join(t.TempDir(), "book.epub")
+			conv := NewEPUBConverter(path)
+			conv.Version = version
+			if err := conv.Convert(chapters, css); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			reader, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatalf("Failed to open EPUB file: %v", err)
+			}
+			defer reader.Close()
+
+			files := make(map[string]string)
+			for _, f := range reader.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatalf("failed to open %s: %v", f.Name, err)
+				}
+				data, _ := io.ReadAll(rc)
+				rc.Close()
+				files[f.Name] = string(data)
+			}
+
+			if files["EPUB/fonts/"+font.Name] != string(goregular.TTF) {
+				t.Errorf("EPUB is missing font %s", font.Name)
+			}
+			stylesheet := files["EPUB/css/book.css"]
+			want := `@font-face { font-family: Go; src: url("../fonts/` + font.Name + `"); }
+body { font-family: Go, serif; }`
+			if strings.TrimSpace(stylesheet) != want {
+				t.Errorf("book.css = %q, want %q", stylesheet, want)
+			}
+
+			problems, err := validator.ValidateEPUB(path)
+			if err != nil {
+				t.Fatalf("ValidateEPUB() error = %v", err)
+			}
+			for _, problem := range problems {
+				t.Errorf("unexpected problem: %s", problem)
+			}
+		})
+	}
+}
+
+// TestEPUBConverter_PruneCSS verifies fonts and rules the sections don't use
+// are left out of the EPUB
+func TestEPUBConverter_PruneCSS(t *testing.T) {
+	used := downloader.NewAsset(goregular.TTF, "font/ttf")
+	unused := downloader.NewAsset(append(bytes.Clone(goregular.TTF), 0), "font/ttf")
+	css := `@font-face { font-family: Go; src: url("` + used.URL() + `"); }
+@font-face { font-family: Unused; src: url("` + unused.URL() + `"); }
+h1 { font-family: Go; }
+.sidebar h2 { font-family: Go; }`
+
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+			Assets:  map[string]downloader.Asset{used.URL(): used, unused.URL(): unused},
+		},
+		{
+			Title:   "Chapter 1",
+			Content: "<div class='content'><h1>Test Content</h1></div>",
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "book.epub")
+	var log bytes.Buffer
+	conv := NewEPUBConverter(path)
+	conv.Log = &log
+	if err := conv.Convert(chapters, css); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB file: %v", err)
+	}
+	defer reader.Close()
+
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	if _, ok := files["EPUB/fonts/"+used.Name]; !ok {
+		t.Errorf("EPUB is missing used font %s", used.Name)
+	}
+	if _, ok := files["EPUB/fonts/"+unused.Name]; ok {
+		t.Errorf("EPUB has unused font %s", unused.Name)
+	}
+	want := `@font-face { font-family: Go; src: url("../fonts/` + used.Name + `"); }
+h1 { font-family: Go; }`
+	if got := strings.TrimSpace(files["EPUB/css/book.css"]); got != want {
+		t.Errorf("book.css = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(log.String(), "Pruned unused CSS: ") {
+		t.Errorf("report = %q, want the CSS sizes", log.String())
+	}
+}
@@ -10,11 +10,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"github.com/go-shiori/go-epub"
 	"golang.org/x/net/html"
 )
 
-func (e *EPUBConverter) processImages(doc *html.Node, book *epub.Epub) error {
+// processImages adds the images in doc to the book and points them at the
+// added files. added maps the file names already in the book to their
+// paths, so an image used in several chapters is stored once.
+func (e *EPUBConverter) processImages(doc *html.Node, book *epub.Epub, assets bookImages, added map[string]string) error {
 	images := findAllNodes(doc, func(n *html.Node) bool {
 		return n.Type == html.ElementNode && n.Data == "img"
 	})
@@ -25,11 +29,10 @@ func (e *EPUBConverter) processImages(doc *html.Node, book *epub.Epub) error {
 			continue
 		}
 
-		// Handle data URLs directly
-		if strings.HasPrefix(src, "data:") {
-			imgPath, err := book.AddImage(src, fmt.Sprintf("image_%d.jpg", time.Now().UnixNano()))
+		if isBookImage(src) {
+			imgPath, err := addBookAsset(book, assets, src, added)
 			if err != nil {
-				continue
+				return err
 			}
 			setAttr(img, "src", imgPath)
 			continue
@@ -46,6 +49,32 @@ func (e *EPUBConverter) processImages(doc *html.Node, book *epub.Epub) error {
 	return nil
 }
 
+// addBookAsset adds a downloaded image or font to the book under its
+// content hash, once, and returns its path
+func addBookAsset(book *epub.Epub, assets bookImages, src string, added map[string]string) (string, error) {
+	data, mediaType, err := assets.data(src)
+	if err != nil {
+		return "", err
+	}
+	name := downloader.NewAsset(data, mediaType).Name
+	if path, ok := added[name]; ok {
+		return path, nil
+	}
+
+	add, kind := book.AddImage, "image"
+	if strings.HasPrefix(mediaType, "font/") {
+		// go-epub's data URL parser rejects font/ types, and it detects
+		// the type from the content anyway
+		add, kind, mediaType = book.AddFont, "font", "application/octet-stream"
+	}
+	path, err := add("data:"+mediaType+";base64,"+base64.StdEncoding.EncodeToString(data), name)
+	if err != nil {
+		return "", fmt.Errorf("failed to add %s: %w", kind, err)
+	}
+	added[name] = path
+	return path, nil
+}
+
 func (e *EPUBConverter) downloadAndAddImage(src string, book *epub.Epub) (string, error) {
 	resp, err := http.Get(src)
 	if err != nil {
@@ -41,7 +41,7 @@ func TestEPUBConverter_ProcessImages(t *testing.T) {
 
 	// Process images in the document
 	conv := NewEPUBConverter("test.epub")
-	err = conv.processImages(doc, book)
+	err = conv.processImages(doc, book, nil, make(map[string]string))
 	if err != nil {
 		t.Fatalf("processImages() error = %v", err)
 	}
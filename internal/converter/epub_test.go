@@ -2,11 +2,14 @@ package converter
 
 import (
 	"archive/zip"
+	"io"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/device"
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/validator"
 	"golang.org/x/net/html"
 )
 
@@ -74,14 +77,63 @@ func TestEPUBConverter_Convert(t *testing.T) {
 	}
 }
 
+// TestEPUBConverter_Device verifies every section links the device
+// profile's stylesheet
+func TestEPUBConverter_Device(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.epub")
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+			Number:  0,
+		},
+		{
+			Title:   "Chapter 1",
+			Content: "<div class='content'><h1>Test Content</h1></div>",
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	conv := NewEPUBConverter(testFile)
+	conv.SetDevice(device.Builtin["kindle"])
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	reader, err := zip.OpenReader(testFile)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB file: %v", err)
+	}
+	defer reader.Close()
+
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	if css := files["EPUB/css/book.css"]; !strings.Contains(css, "body { margin: 0; }") {
+		t.Errorf("book.css = %q, want the device's margins", css)
+	}
+	for _, section := range []string{"section0001.xhtml", "section0002.xhtml", "section0003.xhtml"} {
+		if !strings.Contains(files["EPUB/xhtml/"+section], `href="../css/book.css"`) {
+			t.Errorf("%s does not link the device stylesheet", section)
+		}
+	}
+}
+
 // TestEPUBConverter_CreateTitlePage verifies the generation of the EPUB title page
 // including all required metadata and formatting
 func TestEPUBConverter_CreateTitlePage(t *testing.T) {
 	conv := NewEPUBConverter("test.epub")
-	titlePage, err := conv.createTitlePage()
-	if err != nil {
-		t.Fatalf("createTitlePage() error = %v", err)
-	}
+	titlePage := conv.createTitlePage()
 
 	// Define required elements for the title page
 	expectedElements := []string{
@@ -140,3 +192,53 @@ func TestEPUBConverter_ProcessLinks(t *testing.T) {
 		}
 	}
 }
+
+// TestEPUBConverter_TOCLink verifies the chapter title's link back to the
+// contents points at the table of contents section, so the book has no
+// broken links
+func TestEPUBConverter_TOCLink(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book.epub")
+	chapters := epubTestChapters()
+
+	conv := NewEPUBConverter(testFile)
+	conv.Log = io.Discard
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	reader, err := zip.OpenReader(testFile)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB file: %v", err)
+	}
+	defer reader.Close()
+	for _, f := range reader.File {
+		if f.Name != "EPUB/xhtml/section0003.xhtml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if !strings.Contains(string(data), `<a href="section0002.xhtml">Principles of Shaping</a>`) {
+			t.Errorf("chapter title doesn't link to the table of contents:\n%s", data)
+		}
+	}
+
+	problems, err := validator.ValidateEPUB(testFile)
+	if err != nil {
+		t.Fatalf("ValidateEPUB() error = %v", err)
+	}
+	for _, problem := range problems {
+		t.Errorf("unexpected problem: %s", problem)
+	}
+}
+
+// epubTestChapters returns testChapters with the site's table of contents
+// beside the first chapter, where the EPUB converter reads it from
+func epubTestChapters() []downloader.Chapter {
+	chapters := testChapters()
+	chapters[0].Content = `<div class="toc"><a href="/shapeup/1.1">Principles of Shaping</a><a href="/shapeup/1.2">Set Boundaries</a></div>` + chapters[0].Content
+	return chapters
+}
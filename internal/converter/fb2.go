@@ -0,0 +1,435 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+const (
+	fb2Namespace   = "http://www.gribuser.ru/xml/fictionbook/2.0"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+type FB2Converter struct {
+	OutputPath string
+	baseConverter
+}
+
+func NewFB2Converter(outputPath string) *FB2Converter {
+	if !strings.HasSuffix(outputPath, ".fb2") {
+		outputPath = outputPath + ".fb2"
+	}
+
+	return &FB2Converter{
+		OutputPath: outputPath,
+	}
+}
+
+func (f *FB2Converter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	w := &fb2Writer{
+		chapters: chapters,
+		images:   make(map[string]string),
+	}
+
+	w.raw(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	w.raw(`<FictionBook xmlns="` + fb2Namespace + `" xmlns:l="` + xlinkNamespace + `">` + "\n")
+	meta := f.metadata()
+	w.description(chapters, meta)
+
+	w.raw("<body>\n")
+	w.raw("<title><p>" + escapeXML(meta.Title) + "</p></title>\n")
+	for _, part := range f.organizeParts(chapters) {
+		w.raw("<section>\n")
+		w.raw("<title><p>" + escapeXML(part.Title) + "</p></title>\n")
+		for _, chapter := range part.Chapters {
+			if err := f.writeChapter(w, chapter); err != nil {
+				return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+			}
+		}
+		w.raw("</section>\n")
+	}
+	w.raw("</body>\n")
+
+	w.notesBody()
+	w.binaries()
+	w.raw("</FictionBook>\n")
+
+	if err := os.WriteFile(f.OutputPath, []byte(w.buf.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *FB2Converter) writeChapter(w *fb2Writer, chapter downloader.Chapter) error {
+	processedContent, err := f.processChapterContent(chapter.Content)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return err
+	}
+
+	w.current = chapter
+	w.raw(`<section id="` + anchorID(chapter, "") + `">` + "\n")
+	w.raw("<title><p>" + escapeXML(chapter.Title) + "</p></title>\n")
+
+	// FB2 sections need at least one block after the title
+	before := w.buf.Len()
+	w.blocks(body)
+	w.flushIDs()
+	if w.buf.Len() == before {
+		w.raw("<empty-line/>\n")
+	}
+
+	w.raw("</section>\n")
+	return nil
+}
+
+// fb2Writer serializes chapter HTML into FB2 markup, collecting images and
+// external links along the way
+type fb2Writer struct {
+	buf      strings.Builder
+	chapters []downloader.Chapter
+	current  downloader.Chapter
+
+	// pendingIDs carries the ids of elements FB2 cannot express (e.g. a
+	// div or a span) to the next block that is written
+	pendingIDs []string
+
+	// images maps content hashes to binary ids, in order of first use
+	images     map[string]string
+	binaryList []fb2Binary
+
+	notes []string
+}
+
+type fb2Binary struct {
+	ID          string
+	ContentType string
+	Data        []byte
+}
+
+func (w *fb2Writer) raw(s string) {
+	w.buf.WriteString(s)
+}
+
+// description writes the <description> block with the book's metadata
+func (w *fb2Writer) description(chapters []downloader.Chapter, meta downloader.Metadata) {
+	// Derive a stable document id from the chapter list
+	sum := sha256.New()
+	for _, chapter := range chapters {
+		sum.Write([]byte(chapter.URL))
+	}
+	docID := hex.EncodeToString(sum.Sum(nil))[:32]
+	today := time.Now().Format("2006-01-02")
+
+	w.raw("<description>\n<title-info>\n")
+	w.raw("<genre>management</genre>\n")
+	// FB2 wants the author's names separately
+	if first, last, found := strings.Cut(meta.Author, " "); found {
+		w.raw("<author><first-name>" + escapeXML(first) + "</first-name><last-name>" + escapeXML(last) + "</last-name></author>\n")
+	} else {
+		w.raw("<author><nickname>" + escapeXML(meta.Author) + "</nickname></author>\n")
+	}
+	w.raw("<book-title>" + escapeXML(meta.Title) + "</book-title>\n")
+	if meta.Subtitle != "" {
+		w.raw("<annotation><p>" + escapeXML(meta.Subtitle) + "</p></annotation>\n")
+	}
+	if meta.Date != "" {
+		w.raw("<date>" + escapeXML(meta.Date) + "</date>\n")
+	}
+	w.raw("<lang>" + escapeXML(meta.Language) + "</lang>\n")
+	w.raw("</title-info>\n<document-info>\n")
+	w.raw("<author><nickname>shape-up-downloader</nickname></author>\n")
+	w.raw("<program-used>shape-up-downloader</program-used>\n")
+	w.raw(`<date value="` + today + `">` + today + "</date>\n")
+	w.raw("<src-url>" + escapeXML(meta.URL) + "</src-url>\n")
+	w.raw("<id>" + docID + "</id>\n")
+	w.raw("<version>1.0</version>\n")
+	w.raw("</document-info>\n")
+	w.raw("<publish-info><publisher>" + escapeXML(meta.Publisher) + "</publisher>")
+	if len(meta.Date) >= 4 {
+		w.raw("<year>" + meta.Date[:4] + "</year>")
+	}
+	if meta.ISBN != "" {
+		w.raw("<isbn>" + escapeXML(meta.ISBN) + "</isbn>")
+	}
+	w.raw("</publish-info>\n")
+	w.raw("</description>\n")
+}
+
+// blocks writes the children of n as FB2 block elements
+func (w *fb2Writer) blocks(n *html.Node) {
+	var inline strings.Builder
+	flush := func() {
+		if strings.TrimSpace(inline.String()) != "" {
+			w.paragraph("p", strings.TrimSpace(inline.String()))
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (isBlockElement(c.Data) || c.Data == "img") {
+			flush()
+			w.block(c)
+			continue
+		}
+		inline.WriteString(w.inline(c))
+	}
+	flush()
+}
+
+func (w *fb2Writer) block(n *html.Node) {
+	w.addID(n)
+
+	switch n.Data {
+	case "h1":
+		// The chapter title is already the section title, so its id and
+		// any before it go to the next block
+	case "h2", "h3", "h4", "h5", "h6":
+		w.paragraph("subtitle", w.inlineChildren(n))
+	case "p":
+		w.paragraph("p", w.inlineChildren(n))
+	case "ul", "ol":
+		w.list(n)
+	case "blockquote":
+		w.raw("<cite" + w.takeID() + ">\n")
+		before := w.buf.Len()
+		w.blocks(n)
+		if w.buf.Len() == before {
+			w.raw("<empty-line/>\n")
+		}
+		w.raw("</cite>\n")
+	case "pre":
+		for _, line := range strings.Split(strings.TrimRight(extractText(n), "\n"), "\n") {
+			w.paragraph("p", "<code>"+escapeXML(line)+"</code>")
+		}
+	case "hr":
+		w.raw("<empty-line/>\n")
+	case "img":
+		if href := w.addImage(n); href != "" {
+			w.raw(`<image l:href="` + href + `"` + w.takeID() + "/>\n")
+		}
+	case "figcaption":
+		if text := w.inlineChildren(n); strings.TrimSpace(text) != "" {
+			w.paragraph("p", "<emphasis>"+text+"</emphasis>")
+		}
+	default:
+		w.blocks(n)
+	}
+}
+
+// list writes list items as paragraphs, since FB2 has no list markup
+func (w *fb2Writer) list(n *html.Node) {
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+		w.addID(c)
+		marker := "• "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		w.paragraph("p", marker+strings.TrimSpace(w.inlineChildren(c)))
+	}
+}
+
+// paragraph writes a text block, dropping it if it has no content
+func (w *fb2Writer) paragraph(tag, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	w.raw("<" + tag + w.takeID() + ">" + strings.TrimSpace(content) + "</" + tag + ">\n")
+}
+
+// addID queues the id of n, if it has one, for the next block
+func (w *fb2Writer) addID(n *html.Node) {
+	if id := getAttr(n, "id"); id != "" {
+		w.pendingIDs = append(w.pendingIDs, anchorID(w.current, id))
+	}
+}
+
+// takeID returns the innermost pending id as an attribute for the block
+// about to be written. The ids of the elements around it go on empty
+// paragraphs written first, so links to them still land here.
+func (w *fb2Writer) takeID() string {
+	if len(w.pendingIDs) == 0 {
+		return ""
+	}
+	last := len(w.pendingIDs) - 1
+	outer, id := w.pendingIDs[:last], w.pendingIDs[last]
+	w.pendingIDs = outer
+	w.flushIDs()
+	return ` id="` + id + `"`
+}
+
+// flushIDs writes the pending ids on empty paragraphs, for ids that have
+// no block left to go on
+func (w *fb2Writer) flushIDs() {
+	for _, id := range w.pendingIDs {
+		w.raw(`<p id="` + id + `"/>` + "\n")
+	}
+	w.pendingIDs = nil
+}
+
+func (w *fb2Writer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(w.inline(c))
+	}
+	return text.String()
+}
+
+// inline returns the FB2 markup for an inline node
+func (w *fb2Writer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escapeXML(collapseSpace(n.Data))
+	case html.ElementNode:
+		w.addID(n)
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "em", "i":
+		return wrapInline("emphasis", w.inlineChildren(n))
+	case "strong", "b":
+		return wrapInline("strong", w.inlineChildren(n))
+	case "s", "del", "strike":
+		return wrapInline("strikethrough", w.inlineChildren(n))
+	case "sup":
+		return wrapInline("sup", w.inlineChildren(n))
+	case "sub":
+		return wrapInline("sub", w.inlineChildren(n))
+	case "code":
+		return wrapInline("code", escapeXML(extractText(n)))
+	case "br":
+		return " "
+	case "img":
+		if href := w.addImage(n); href != "" {
+			return `<image l:href="` + href + `"/>`
+		}
+		return ""
+	case "a":
+		return w.link(n)
+	case "script", "style", "template":
+		return ""
+	default:
+		return w.inlineChildren(n)
+	}
+}
+
+// link maps book links to in-document ids and external links to notes
+func (w *fb2Writer) link(n *html.Node) string {
+	text := w.inlineChildren(n)
+	href := getAttr(n, "href")
+
+	chapter, fragment, external := resolveLink(href, w.current, w.chapters)
+	switch {
+	case chapter != nil:
+		return `<a l:href="#` + anchorID(*chapter, fragment) + `">` + text + "</a>"
+	case !external:
+		return text
+	}
+
+	w.notes = append(w.notes, href)
+	return text + fmt.Sprintf(`<a l:href="#note%d" type="note">[%d]</a>`, len(w.notes), len(w.notes))
+}
+
+// addImage records an image carried by the book as a binary and returns its
+// reference. Linked images are skipped.
+func (w *fb2Writer) addImage(n *html.Node) string {
+	data, mediaType, err := bookImages(w.current.Assets).data(getAttr(n, "src"))
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if id, ok := w.images[hash]; ok {
+		return "#" + id
+	}
+
+	id := fmt.Sprintf("img%d%s", len(w.binaryList)+1, downloader.Extension(mediaType))
+	w.images[hash] = id
+	w.binaryList = append(w.binaryList, fb2Binary{ID: id, ContentType: mediaType, Data: data})
+	return "#" + id
+}
+
+// notesBody writes external link targets as FB2 notes
+func (w *fb2Writer) notesBody() {
+	if len(w.notes) == 0 {
+		return
+	}
+
+	w.raw(`<body name="notes">` + "\n<title><p>Notes</p></title>\n")
+	for i, note := range w.notes {
+		w.raw(fmt.Sprintf(`<section id="note%d"><title><p>%d</p></title>`, i+1, i+1))
+		w.raw("<p>" + escapeXML(note) + "</p></section>\n")
+	}
+	w.raw("</body>\n")
+}
+
+func (w *fb2Writer) binaries() {
+	for _, binary := range w.binaryList {
+		w.raw(`<binary id="` + binary.ID + `" content-type="` + escapeXML(binary.ContentType) + `">`)
+		w.raw(base64.StdEncoding.EncodeToString(binary.Data))
+		w.raw("</binary>\n")
+	}
+}
+
+func wrapInline(tag, content string) string {
+	if content == "" {
+		return ""
+	}
+	return "<" + tag + ">" + content + "</" + tag + ">"
+}
+
+func escapeXML(s string) string {
+	var buf strings.Builder
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// collapseSpace replaces runs of whitespace with a single space, keeping a
+// leading or trailing space so adjacent inline elements stay separated
+func collapseSpace(s string) string {
+	collapsed := normalizeSpace(s)
+	if collapsed == "" {
+		if s != "" {
+			return " "
+		}
+		return ""
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		collapsed = " " + collapsed
+	}
+	if strings.TrimRight(s, " \t\r\n") != s {
+		collapsed += " "
+	}
+	return collapsed
+}
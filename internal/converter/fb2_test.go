@@ -0,0 +1,118 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestFB2Converter_Convert verifies the FB2 document is well-formed and
+// contains metadata, nested sections, binaries, links and notes
+func TestFB2Converter_Convert(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book")
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png-data"))
+
+	chapters := []downloader.Chapter{
+		{
+			Title: "Chapter 1",
+			Content: `<div class="content">
+                        <h1 class="intro__title"><a href="#toc">Chapter 1</a></h1>
+                        <p>Read <a href="/shapeup/1.2#risks">the risks</a> &amp; <a href="https://basecamp.com">more</a>.</p>
+                        <ul><li>One</li><li>Two</li></ul>
+                        <figure><img src="` + image + `" alt="Sketch"></figure>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+		{
+			Title:   "Chapter 2",
+			Content: "<div class='content'><h2 id='risks'>Risks</h2><blockquote><p>Quote</p></blockquote></div>",
+			URL:     "https://basecamp.com/shapeup/1.2",
+			Number:  2,
+		},
+	}
+
+	conv := NewFB2Converter(testFile)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testFile + ".fb2")
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	// Verify the document is well-formed XML
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Output is not well-formed XML: %v", err)
+		}
+	}
+
+	output := string(data)
+	expected := []string{
+		"<book-title>Shape Up</book-title>",
+		"<last-name>Singer</last-name>",
+		`<section id="ch_1.1">`,
+		`<subtitle id="ch_1.2_risks">Risks</subtitle>`,
+		`<a l:href="#ch_1.2_risks">the risks</a>`,
+		`<a l:href="#note1" type="note">[1]</a>`,
+		`<section id="note1">`,
+		"<p>• One</p>",
+		"<cite>",
+		`<image l:href="#img1.png"/>`,
+		`<binary id="img1.png" content-type="image/png">` + base64.StdEncoding.EncodeToString([]byte("png-data")),
+	}
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("Convert() output missing %s", want)
+		}
+	}
+}
+
+// TestFB2Converter_Anchors verifies links to the ids of containers, nested
+// blocks and inline elements all find an element with that id
+func TestFB2Converter_Anchors(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book")
+	chapters := []downloader.Chapter{
+		{
+			Title: "Chapter 1",
+			Content: `<div class="content">
+                        <h1 id="title">Chapter 1</h1>
+                        <div id="case-study"><p id="intro">A <span id="term">term</span> to know.</p></div>
+                        <p>See <a href="#case-study">the case</a>, <a href="#term">the term</a> and <a href="#title">the title</a>.</p>
+                        <p>Last <a id="end" href="#intro">words</a></p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+	}
+
+	conv := NewFB2Converter(testFile)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	data, err := os.ReadFile(testFile + ".fb2")
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	output := string(data)
+
+	for _, id := range []string{"title", "case-study", "intro", "term", "end"} {
+		if n := strings.Count(output, `id="ch_1.1_`+id+`"`); n != 1 {
+			t.Errorf("Convert() output has %d elements with id %s, want 1:\n%s", n, id, output)
+		}
+	}
+	if !strings.Contains(output, `<p id="ch_1.1_term">A term to know.</p>`) {
+		t.Errorf("Convert() output doesn't put the innermost id on the paragraph:\n%s", output)
+	}
+}
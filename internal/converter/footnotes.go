@@ -0,0 +1,281 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// footnoteID matches the ids Markdown renderers and CMSs give footnotes
+var footnoteID = regexp.MustCompile(`^(fn|footnote|endnote|note)[-_:]?\d+$`)
+
+// footnoteContainerClasses mark the block a chapter's notes are listed in
+var footnoteContainerClasses = []string{"footnotes", "footnote", "endnotes", "notes"}
+
+// footnoteCSS shows a footnote's text when its reference is hovered or
+// focused in the single-page HTML outputs
+const footnoteCSS = `
+.footnote { position: relative; }
+.footnote-popup { display: none; position: absolute; z-index: 10; left: 0; top: 1.5em; width: 20em; max-width: 80vw; padding: 0.5em 0.75em; background: #fff; color: #222; border: 1px solid #ccc; border-radius: 4px; box-shadow: 0 2px 8px rgba(0, 0, 0, 0.15); font-size: 0.9rem; font-weight: normal; line-height: 1.4; text-align: left; }
+.footnote:hover .footnote-popup, .footnote:focus-within .footnote-popup { display: block; }
+`
+
+// footnote is a note and the links that reference it
+type footnote struct {
+	ID   string
+	Refs []*html.Node
+	// Note holds the text of the note. Container is the list or section of
+	// notes it belongs to, if any.
+	Note      *html.Node
+	Container *html.Node
+}
+
+// findFootnotes returns the notes in doc that in-page links point at, in the
+// order they are first referenced. A link target is a note if it sits in a
+// block of footnotes or has a footnote-style id.
+func findFootnotes(doc *html.Node) []*footnote {
+	targets := make(map[string]*html.Node)
+	for _, n := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && getAttr(n, "id") != ""
+	}) {
+		if _, ok := targets[getAttr(n, "id")]; !ok {
+			targets[getAttr(n, "id")] = n
+		}
+	}
+
+	var notes []*footnote
+	byID := make(map[string]*footnote)
+	for _, link := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "a" && strings.HasPrefix(getAttr(n, "href"), "#")
+	}) {
+		id := strings.TrimPrefix(getAttr(link, "href"), "#")
+		target := targets[id]
+		if target == nil || headingLevel(target) > 0 {
+			continue
+		}
+
+		container := footnoteContainer(target)
+		if container == nil && !footnoteID.MatchString(id) {
+			continue
+		}
+		// Links inside the notes are backlinks or cross-references
+		if footnoteContainer(link) != nil || isAncestor(target, link) {
+			continue
+		}
+
+		note, ok := byID[id]
+		if !ok {
+			note = &footnote{ID: id, Note: target, Container: container}
+			// An empty anchor marks the note it sits in
+			if target.Data == "a" && target.FirstChild == nil && target.Parent != nil {
+				note.Note = target.Parent
+			}
+			// Asides can't go in a list, so a list of notes is replaced whole
+			if note.Container == nil && note.Note.Data == "li" {
+				note.Container = note.Note.Parent
+			}
+			byID[id] = note
+			notes = append(notes, note)
+		}
+		note.Refs = append(note.Refs, link)
+	}
+	return notes
+}
+
+// footnoteContainer returns the block of notes n belongs to, or nil
+func footnoteContainer(n *html.Node) *html.Node {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		// Only blocks count, since kramdown classes references as footnotes
+		if !isBlockElement(p.Data) {
+			continue
+		}
+		for _, class := range footnoteContainerClasses {
+			if hasClass(p, class) {
+				return p
+			}
+		}
+		role := getAttr(p, "role")
+		epubType := getAttr(p, "epub:type")
+		if role == "doc-endnotes" || role == "doc-footnote" ||
+			strings.Contains(epubType, "footnote") || strings.Contains(epubType, "endnote") {
+			return p
+		}
+	}
+	return nil
+}
+
+// isAncestor reports whether a contains n
+func isAncestor(a, n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p == a {
+			return true
+		}
+	}
+	return false
+}
+
+// isBacklink reports whether n is a link from a note back to its reference
+func isBacklink(n *html.Node, note *footnote) bool {
+	if n.Type != html.ElementNode || n.Data != "a" {
+		return false
+	}
+	if getAttr(n, "role") == "doc-backlink" || hasClass(n, "footnote-back") || hasClass(n, "footnote-backref") {
+		return true
+	}
+	// References carry their id themselves or on the <sup> around them
+	href := strings.TrimPrefix(getAttr(n, "href"), "#")
+	for _, ref := range note.Refs {
+		if href != "" && (href == getAttr(ref, "id") || href == getAttr(ref.Parent, "id")) {
+			return true
+		}
+	}
+	return strings.HasPrefix(strings.TrimSpace(extractText(n)), "↩")
+}
+
+// markEPUBFootnotes turns footnotes into EPUB 3 semantic notes: references
+// become noterefs and each note becomes an aside, which readers such as
+// Apple Books and KOReader show as a pop-up
+func markEPUBFootnotes(doc *html.Node) {
+	notes := findFootnotes(doc)
+	for _, note := range notes {
+		for _, ref := range note.Refs {
+			setAttr(ref, "epub:type", "noteref")
+			setAttr(ref, "role", "doc-noteref")
+		}
+
+		aside := &html.Node{Type: html.ElementNode, Data: "aside", Attr: []html.Attribute{
+			{Key: "id", Val: note.ID},
+			{Key: "epub:type", Val: "footnote"},
+			{Key: "role", Val: "doc-footnote"},
+		}}
+
+		// Notes keep their content but give up their id to the aside
+		target := findNode(note.Note, func(n *html.Node) bool {
+			return n.Type == html.ElementNode && getAttr(n, "id") == note.ID
+		})
+		if target != nil && target != note.Note && target.Data == "a" && target.FirstChild == nil {
+			target.Parent.RemoveChild(target)
+		}
+		removeAttr(note.Note, "id")
+
+		position := note.Note
+		if note.Container != nil {
+			position = note.Container
+		}
+		position.Parent.InsertBefore(aside, position)
+
+		if note.Note.Data == "li" {
+			// The list numbering is lost, so the note is labelled instead
+			label := &html.Node{Type: html.TextNode, Data: strings.Trim(normalizeSpace(extractText(note.Refs[0])), "[]") + ". "}
+			for c := note.Note.FirstChild; c != nil; {
+				next := c.NextSibling
+				note.Note.RemoveChild(c)
+				aside.AppendChild(c)
+				c = next
+			}
+			if first := firstElement(aside); first != nil && first.Data == "p" {
+				first.InsertBefore(label, first.FirstChild)
+			} else {
+				aside.InsertBefore(label, aside.FirstChild)
+			}
+			note.Note.Parent.RemoveChild(note.Note)
+		} else {
+			note.Note.Parent.RemoveChild(note.Note)
+			aside.AppendChild(note.Note)
+		}
+	}
+
+	// Drop the blocks the notes came from once they're empty
+	for _, note := range notes {
+		if c := note.Container; c != nil && c.Parent != nil && strings.TrimSpace(extractText(c)) == "" {
+			c.Parent.RemoveChild(c)
+		}
+	}
+}
+
+// inlineFootnotes adds a copy of each note's text next to its references,
+// shown on hover or focus by footnoteCSS. It reports whether any were found.
+func inlineFootnotes(doc *html.Node) bool {
+	notes := findFootnotes(doc)
+	for _, note := range notes {
+		for _, ref := range note.Refs {
+			popup := &html.Node{Type: html.ElementNode, Data: "span", Attr: []html.Attribute{
+				{Key: "class", Val: "footnote-popup"},
+				{Key: "role", Val: "note"},
+			}}
+			for c := note.Note.FirstChild; c != nil; c = c.NextSibling {
+				if copied := inlineCopy(c, note); copied != nil {
+					popup.AppendChild(copied)
+				}
+			}
+
+			// Keep the popup out of the reference's superscript
+			anchor := ref
+			if ref.Parent.Data == "sup" {
+				anchor = ref.Parent
+			}
+			wrapper := &html.Node{Type: html.ElementNode, Data: "span", Attr: []html.Attribute{{Key: "class", Val: "footnote"}}}
+			anchor.Parent.InsertBefore(wrapper, anchor)
+			anchor.Parent.RemoveChild(anchor)
+			wrapper.AppendChild(anchor)
+			wrapper.AppendChild(popup)
+		}
+	}
+	return len(notes) > 0
+}
+
+// inlineCopy copies n for use inside a paragraph: block elements become
+// spans, ids are dropped so they aren't duplicated and backlinks are left out
+func inlineCopy(n *html.Node, note *footnote) *html.Node {
+	if isBacklink(n, note) {
+		return nil
+	}
+
+	copied := &html.Node{Type: n.Type, Data: n.Data, DataAtom: n.DataAtom, Namespace: n.Namespace}
+	if n.Type == html.ElementNode {
+		if isBlockElement(n.Data) || n.Data == "li" {
+			copied.Data = "span"
+			copied.DataAtom = 0
+		}
+		for _, attr := range n.Attr {
+			if attr.Key != "id" {
+				copied.Attr = append(copied.Attr, attr)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if child := inlineCopy(c, note); child != nil {
+			copied.AppendChild(child)
+		}
+	}
+	// Separate what were blocks
+	if copied.Data == "span" && n.Data != "span" && n.NextSibling != nil {
+		copied.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+	}
+	return copied
+}
+
+// firstElement returns the first element child of n, or nil
+func firstElement(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// removeAttr removes an attribute from an element
+func removeAttr(n *html.Node, key string) {
+	var attrs []html.Attribute
+	for _, attr := range n.Attr {
+		if attr.Key != key {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = attrs
+}
@@ -0,0 +1,131 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// footnoteTestCases covers the footnote markup of common Markdown renderers
+var footnoteTestCases = []struct {
+	name    string
+	input   string
+	epub    []string
+	inline  []string
+	noNotes bool
+}{
+	{
+		name: "pandoc",
+		input: `<p>Shaping is fixed time<a href="#fn1" class="footnote-ref" id="fnref1" role="doc-noteref"><sup>1</sup></a>.</p>
+            <section class="footnotes" role="doc-endnotes"><hr><ol><li id="fn1"><p>Six weeks.<a href="#fnref1" class="footnote-back" role="doc-backlink">↩︎</a></p></li></ol></section>`,
+		epub: []string{
+			`<a href="#fn1" class="footnote-ref" id="fnref1" role="doc-noteref" epub:type="noteref"><sup>1</sup></a>`,
+			`<aside id="fn1" epub:type="footnote" role="doc-footnote"><p>1. Six weeks.<a href="#fnref1" class="footnote-back" role="doc-backlink">↩︎</a></p></aside>`,
+		},
+		inline: []string{
+			`<span class="footnote"><a href="#fn1" class="footnote-ref" id="fnref1" role="doc-noteref"><sup>1</sup></a><span class="footnote-popup" role="note"><span>Six weeks.</span></span></span>.`,
+		},
+	},
+	{
+		name: "python-markdown",
+		input: `<p>Appetite<sup id="fnref:1"><a class="footnote-ref" href="#fn:1">1</a></sup> and bets<sup id="fnref:2"><a class="footnote-ref" href="#fn:2">2</a></sup></p>
+            <div class="footnote"><ol><li id="fn:1"><p>Time we want to spend.&#160;<a class="footnote-backref" href="#fnref:1">&#8617;</a></p></li><li id="fn:2"><p>At the betting table.</p></li></ol></div>`,
+		epub: []string{
+			`<sup id="fnref:1"><a class="footnote-ref" href="#fn:1" epub:type="noteref" role="doc-noteref">1</a></sup>`,
+			`<aside id="fn:1" epub:type="footnote" role="doc-footnote"><p>1. Time we want to spend.`,
+			`<aside id="fn:2" epub:type="footnote" role="doc-footnote"><p>2. At the betting table.</p></aside>`,
+		},
+		inline: []string{
+			`<span class="footnote"><sup id="fnref:1"><a class="footnote-ref" href="#fn:1">1</a></sup><span class="footnote-popup" role="note"><span>Time we want to spend.` + "\u00a0" + `</span></span></span>`,
+			`<span class="footnote-popup" role="note"><span>At the betting table.</span></span>`,
+		},
+	},
+	{
+		name: "kramdown",
+		input: `<p>Hill charts<sup id="fnref:hill"><a href="#fn:hill" class="footnote" rel="footnote">1</a></sup></p>
+            <div class="footnotes" role="doc-endnotes"><ol><li id="fn:hill"><p>Uphill and downhill.</p></li></ol></div>`,
+		epub: []string{
+			`<a href="#fn:hill" class="footnote" rel="footnote" epub:type="noteref" role="doc-noteref">1</a>`,
+			`<aside id="fn:hill" epub:type="footnote" role="doc-footnote"><p>1. Uphill and downhill.</p></aside>`,
+		},
+		inline: []string{
+			`<span class="footnote-popup" role="note"><span>Uphill and downhill.</span></span>`,
+		},
+	},
+	{
+		name:  "note outside a list",
+		input: `<p>Betting<a href="#note-1">*</a></p><p id="note-1">Not a backlog.</p>`,
+		epub: []string{
+			`<p>Betting<a href="#note-1" epub:type="noteref" role="doc-noteref">*</a></p><aside id="note-1" epub:type="footnote" role="doc-footnote"><p>Not a backlog.</p></aside>`,
+		},
+		inline: []string{
+			`<span class="footnote-popup" role="note">Not a backlog.</span>`,
+		},
+	},
+	{
+		name:    "section links are not notes",
+		input:   `<p>See <a href="#fixed-time">fixed time</a> and <a href="#summary">the summary</a>.</p><h2 id="fixed-time">Fixed time</h2><div id="summary">Summary</div>`,
+		noNotes: true,
+	},
+}
+
+// TestMarkEPUBFootnotes verifies footnotes become EPUB 3 noterefs and asides
+func TestMarkEPUBFootnotes(t *testing.T) {
+	for _, tt := range footnoteTestCases {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("failed to parse HTML: %v", err)
+			}
+
+			markEPUBFootnotes(doc)
+			got, err := xhtmlFragment(doc)
+			if err != nil {
+				t.Fatalf("xhtmlFragment() error = %v", err)
+			}
+
+			for _, want := range tt.epub {
+				if !strings.Contains(got, want) {
+					t.Errorf("output missing %q:\n%s", want, got)
+				}
+			}
+			if tt.noNotes && strings.Contains(got, "epub:type") {
+				t.Errorf("output should have no notes:\n%s", got)
+			}
+			if !tt.noNotes && (strings.Contains(got, "<ol>") || strings.Contains(got, "<hr/>")) {
+				t.Errorf("the emptied list of notes should be removed:\n%s", got)
+			}
+		})
+	}
+}
+
+// TestInlineFootnotes verifies footnote references get a hover copy of the
+// note's text
+func TestInlineFootnotes(t *testing.T) {
+	for _, tt := range footnoteTestCases {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("failed to parse HTML: %v", err)
+			}
+
+			found := inlineFootnotes(doc)
+			if found == tt.noNotes {
+				t.Errorf("inlineFootnotes() = %v, want %v", found, !tt.noNotes)
+			}
+
+			var buf strings.Builder
+			if err := html.Render(&buf, doc); err != nil {
+				t.Fatalf("failed to render HTML: %v", err)
+			}
+			got := buf.String()
+
+			for _, want := range tt.inline {
+				if !strings.Contains(got, want) {
+					t.Errorf("output missing %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+const geminiIndexFile = "index.gmi"
+
+type GeminiConverter struct {
+	OutputDir string
+	baseConverter
+}
+
+func NewGeminiConverter(outputDir string) *GeminiConverter {
+	return &GeminiConverter{
+		OutputDir: outputDir,
+	}
+}
+
+func (g *GeminiConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	if err := os.MkdirAll(g.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for i, chapter := range chapters {
+		if err := g.writeChapter(i, chapters); err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+	}
+
+	return g.writeIndex(g.organizeParts(chapters))
+}
+
+func (g *GeminiConverter) writeChapter(index int, chapters []downloader.Chapter) error {
+	chapter := chapters[index]
+
+	processedContent, err := g.processChapterContent(chapter.Content)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return err
+	}
+
+	r := &geminiRenderer{
+		chapters: chapters,
+		images:   bookImages(chapter.Assets),
+		imageDir: filepath.Join(g.OutputDir, bookImagesDir),
+	}
+
+	blocks := []string{"# " + chapter.Title}
+	for _, n := range blockNodes(body) {
+		// The chapter heading is written from the chapter title
+		if headingLevel(n) == 1 {
+			continue
+		}
+		if block := r.block(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to save image: %w", r.err)
+	}
+
+	// Gemini has no sidebar, so every page links its neighbours
+	var nav []string
+	if index > 0 {
+		prev := chapters[index-1]
+		nav = append(nav, "=> "+chapterFileName(prev, ".gmi")+" Previous: "+prev.Title)
+	}
+	nav = append(nav, "=> "+geminiIndexFile+" Contents")
+	if index < len(chapters)-1 {
+		next := chapters[index+1]
+		nav = append(nav, "=> "+chapterFileName(next, ".gmi")+" Next: "+next.Title)
+	}
+	blocks = append(blocks, strings.Join(nav, "\n"))
+
+	path := filepath.Join(g.OutputDir, chapterFileName(chapter, ".gmi"))
+	if err := os.WriteFile(path, []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write chapter: %w", err)
+	}
+	return nil
+}
+
+// writeIndex writes the capsule's front page, which follows the TOC
+func (g *GeminiConverter) writeIndex(parts []Part) error {
+	meta := g.metadata()
+
+	var out strings.Builder
+	out.WriteString("# " + meta.Title + "\n\n")
+	if meta.Subtitle != "" {
+		out.WriteString(meta.Subtitle + "\n")
+	}
+	out.WriteString("by " + meta.Author + "\n")
+
+	for _, part := range parts {
+		out.WriteString("\n## " + part.Title + "\n\n")
+		for _, chapter := range part.Chapters {
+			out.WriteString("=> " + chapterFileName(chapter, ".gmi") + " " + chapter.Title + "\n")
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(g.OutputDir, geminiIndexFile), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// geminiRenderer renders a chapter's HTML as gemtext. Gemtext has no inline
+// links, so links and images met in a block are collected and written as
+// link lines after it.
+type geminiRenderer struct {
+	chapters []downloader.Chapter
+	images   bookImages
+	imageDir string
+
+	// links holds the link lines for the block being rendered
+	links []string
+
+	// err holds the first error from saving an image
+	err error
+}
+
+// blocks renders the children of n as gemtext blocks
+func (r *geminiRenderer) blocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		if block := r.withLinks(tidyInline(inline.String())); block != "" {
+			blocks = append(blocks, block)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (isBlockElement(c.Data) || c.Data == "img") {
+			flush()
+			if block := r.block(c); block != "" {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		inline.WriteString(r.inline(c))
+	}
+	flush()
+
+	return blocks
+}
+
+// block renders a single block-level node. Text nodes are treated as
+// paragraphs.
+func (r *geminiRenderer) block(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return r.withLinks(tidyInline(r.inline(n)))
+	}
+
+	switch n.Data {
+	case "p":
+		return r.withLinks(tidyInline(r.inlineChildren(n)))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		// Gemtext has three heading levels and the first is the chapter
+		return strings.Repeat("#", min(headingLevel(n), 3)) + " " + normalizeSpace(extractText(n))
+	case "ul", "ol":
+		return r.withLinks(strings.Join(r.listItems(n), "\n"))
+	case "blockquote":
+		var lines []string
+		for _, block := range r.blocks(n) {
+			for _, line := range strings.Split(block, "\n") {
+				if !strings.HasPrefix(line, "=>") {
+					line = "> " + line
+				}
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	case "pre":
+		return "```\n" + strings.TrimRight(extractText(n), "\n") + "\n```"
+	case "hr":
+		return ""
+	case "img":
+		return r.imageLink(n, "")
+	case "figure":
+		return r.figure(n)
+	default:
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+}
+
+// figure renders an image as a link line labelled with its caption
+func (r *geminiRenderer) figure(n *html.Node) string {
+	img := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	})
+	if img == nil {
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+
+	var caption string
+	if figcaption := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "figcaption"
+	}); figcaption != nil {
+		caption = normalizeSpace(extractText(figcaption))
+	}
+	return r.imageLink(img, caption)
+}
+
+// listItems flattens a list into list lines, since gemtext lists don't
+// nest. Ordered items keep their number in the text.
+func (r *geminiRenderer) listItems(n *html.Node) []string {
+	var lines []string
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		var text strings.Builder
+		var nested []string
+		for gc := c.FirstChild; gc != nil; gc = gc.NextSibling {
+			if gc.Type == html.ElementNode && (gc.Data == "ul" || gc.Data == "ol") {
+				nested = append(nested, r.listItems(gc)...)
+				continue
+			}
+			text.WriteString(r.inline(gc) + " ")
+		}
+
+		marker := "* "
+		if n.Data == "ol" {
+			marker += strconv.Itoa(number) + ". "
+			number++
+		}
+		lines = append(lines, marker+tidyInline(text.String()))
+		lines = append(lines, nested...)
+	}
+
+	return lines
+}
+
+// withLinks appends the link lines collected while rendering text
+func (r *geminiRenderer) withLinks(text string) string {
+	links := r.links
+	r.links = nil
+
+	if len(links) == 0 {
+		return text
+	}
+	if text == "" {
+		return strings.Join(links, "\n")
+	}
+	return text + "\n" + strings.Join(links, "\n")
+}
+
+func (r *geminiRenderer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(r.inline(c))
+	}
+	return text.String()
+}
+
+func (r *geminiRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return collapseSpace(n.Data)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return " "
+	case "img":
+		if link := r.imageLink(n, ""); link != "" {
+			r.links = append(r.links, link)
+		}
+		return ""
+	case "a":
+		text := r.inlineChildren(n)
+		if target := r.linkTarget(getAttr(n, "href")); target != "" {
+			r.links = append(r.links, "=> "+target+" "+normalizeSpace(text))
+		}
+		return text
+	case "script", "style", "template":
+		return ""
+	default:
+		return r.inlineChildren(n)
+	}
+}
+
+// linkTarget returns where a link line should point, or "" for links that
+// only make sense inside the HTML page. Gemtext has no fragments, so links
+// into a chapter point at the chapter.
+func (r *geminiRenderer) linkTarget(href string) string {
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	if chapter := findChapterByURL(href, r.chapters); chapter != nil {
+		return chapterFileName(*chapter, ".gmi")
+	}
+	return href
+}
+
+// imageLink saves an embedded image, or keeps a linked one's URL, and
+// returns a link line to it
+func (r *geminiRenderer) imageLink(n *html.Node, label string) string {
+	target, err := r.images.place(r.imageDir, bookImagesDir+"/", getAttr(n, "src"))
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return ""
+	}
+
+	if label == "" {
+		label = normalizeSpace(getAttr(n, "alt"))
+	}
+	if label == "" {
+		label = "Image"
+	}
+	return "=> " + target + " " + label
+}
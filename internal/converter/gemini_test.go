@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGeminiConverter_Convert verifies chapter pages, collected link lines,
+// image links and the index page
+func TestGeminiConverter_Convert(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "capsule")
+
+	conv := NewGeminiConverter(testDir)
+	if err := conv.Convert(testChapters(), ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	files := map[string][]string{
+		"index.gmi": {
+			"# Shape Up",
+			"## Contents\n\n=> 01-principles-of-shaping.gmi Principles of Shaping\n=> 02-set-boundaries.gmi Set Boundaries",
+		},
+		"01-principles-of-shaping.gmi": {
+			"# Principles of Shaping",
+			"Read about fixed time and appetite.\n=> 02-set-boundaries.gmi fixed time",
+			"* Rough\n* Solved\n* 1. Bounded",
+			"> Quote",
+			"=> images/",
+			".png A sketch",
+			"See Basecamp.\n=> https://basecamp.com Basecamp",
+			"=> index.gmi Contents\n=> 02-set-boundaries.gmi Next: Set Boundaries",
+		},
+		"02-set-boundaries.gmi": {
+			"## Fixed time",
+			"Back to the principles or the top.\n=> 01-principles-of-shaping.gmi the principles\n",
+			"=> 01-principles-of-shaping.gmi Previous: Principles of Shaping",
+		},
+	}
+
+	for file, expected := range files {
+		content := readFile(t, filepath.Join(testDir, file))
+		for _, want := range expected {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s missing %q:\n%s", file, want, content)
+			}
+		}
+	}
+
+	images, err := os.ReadDir(filepath.Join(testDir, bookImagesDir))
+	if err != nil || len(images) != 1 {
+		t.Errorf("expected one image, got %v (%v)", images, err)
+	}
+}
@@ -2,55 +2,50 @@ package converter
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/search"
 	"golang.org/x/net/html"
 )
 
-const htmlTemplate = `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="utf-8">
-    <title>Shape Up</title>
-    <style>{{.CSS}}</style>
-</head>
-<body>
-    <div class="content">
-        <h1 class="landing-title landing-title--large">Shape Up</h1>
-        <p class="landing-subtitle">Stop Running in Circles<br class="linebreak"> and Ship Work that Matters</p>
-        <p class="landing-author"><em>by Ryan Singer</em></p>
-        <div id="toc" class="toc">{{.TOC}}</div>
-    </div>
-    <main>
-        {{range .Parts}}
-            {{range .Chapters}}
-            <article id="{{trimPrefix .URL "https://basecamp.com/shapeup/"}}">
-                {{.Content}}
-            </article>
-            {{end}}
-        {{end}}
-    </main>
-</body>
-</html>`
-
-type Part struct {
-	Title    string
-	Chapters []downloader.Chapter
-}
-
 type HTMLConverter struct {
 	OutputDir string
+	// EmbedImages inlines images as data: URLs, so index.html is the whole
+	// book, instead of writing them to the images directory
+	EmbedImages bool
+	// PruneCSS drops the stylesheet rules that match nothing in the book
+	PruneCSS bool
+	// Log receives notes on the conversion, such as how much pruning
+	// shrank the CSS
+	Log io.Writer
+	// Theme names a theme from HTMLThemes or the template directory, whose
+	// CSS is added to the site's
+	Theme string
+	// TemplateDir is a directory of templates that replace the built-in
+	// ones of the same name, with optional themes and assets folders
+	TemplateDir string
+	// Search adds a search box backed by an index of the book's sections
+	Search bool
+	// Print adds CSS Paged Media rules from print.css, and marks the page's
+	// cross-references and external links for them, so the book prints with
+	// chapter page breaks, running headers and page numbers
+	Print bool
+	// PWA makes the book an installable web app that works offline, with a
+	// web app manifest, icons made from the cover and a service worker
+	PWA bool
 	baseConverter
 }
 
 func NewHTMLConverter(outputDir string) *HTMLConverter {
 	return &HTMLConverter{
 		OutputDir: outputDir,
+		PruneCSS:  true,
+		Log:       os.Stdout,
+		Search:    true,
 	}
 }
 
@@ -63,115 +58,201 @@ func (c *HTMLConverter) Convert(chapters []downloader.Chapter, css string) error
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	images := newBookImages(chapters)
+	placeImages := func(doc *html.Node) error {
+		if c.EmbedImages {
+			return images.embed(doc)
+		}
+		return images.extract(doc, filepath.Join(c.OutputDir, bookImagesDir), bookImagesDir+"/")
+	}
+
+	if c.Theme != "" {
+		theme, err := themeCSS(c.TemplateDir, c.Theme)
+		if err != nil {
+			return err
+		}
+		css = strings.TrimSuffix(css, "\n") + "\n" + theme
+	}
+	if c.Print {
+		printCSS, err := templateFile(c.TemplateDir, htmlPrintStyleSheet)
+		if err != nil {
+			return err
+		}
+		css = strings.TrimSuffix(css, "\n") + "\n" + printCSS
+	}
+
+	// The stylesheet's fonts and images are always inlined, so it stands on
+	// its own
+	css, err := images.rewriteCSS(c.device().StyleSheet(css), images.dataURL)
+	if err != nil {
+		return fmt.Errorf("failed to inline stylesheet assets: %w", err)
+	}
+
+	data := HTMLTemplateData{CSS: css}
+	if c.TemplateDir != "" {
+		if data.Assets, err = copyTemplateAssets(c.TemplateDir, c.OutputDir); err != nil {
+			return err
+		}
+	}
+	if c.PWA {
+		if data.PWA, err = c.writePWAManifest(); err != nil {
+			return err
+		}
+	}
+
+	page, err := c.renderBook(chapters, data, placeImages)
+	if err != nil {
+		return err
+	}
+
+	if c.PruneCSS || c.Print {
+		if page, err = c.finishPage(page); err != nil {
+			return err
+		}
+	}
+
+	outputPath := filepath.Join(c.OutputDir, "index.html")
+	if err := os.WriteFile(outputPath, []byte(page), 0644); err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	// The service worker goes last, once every file it caches is written
+	if c.PWA {
+		files, err := c.pwaFiles(data.Assets)
+		if err != nil {
+			return err
+		}
+		if err := c.writeServiceWorker(files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finishPage prepares the rendered page's links for printing if Print is
+// set, then drops the rules of its <style> elements that match nothing on
+// it if PruneCSS is
+func (c *HTMLConverter) finishPage(page string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse rendered book: %w", err)
+	}
+
+	if c.Print {
+		preparePrint(doc)
+	}
+	if c.PruneCSS {
+		var pruning cssPruning
+		pruning.pruneStyleElements(doc)
+		pruning.report(c.Log)
+	}
+
+	var buf strings.Builder
+	if err := html.Render(&buf, doc); err != nil {
+		return "", fmt.Errorf("failed to render book: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderBook renders the whole book as a single HTML page with the book.html
+// template. data holds what the caller decides: the stylesheet, inlined
+// unless StylesheetHref is given, the template assets and the PWA files;
+// renderBook fills in the rest. placeImages, if given, rewrites the images
+// in each chapter.
+func (c *HTMLConverter) renderBook(chapters []downloader.Chapter, data HTMLTemplateData, placeImages func(*html.Node) error) (string, error) {
 	// Extract TOC from first chapter
 	doc, err := html.Parse(strings.NewReader(chapters[0].Content))
 	if err != nil {
-		return fmt.Errorf("failed to parse main page: %w", err)
+		return "", fmt.Errorf("failed to parse main page: %w", err)
 	}
 
 	tocHTML, err := c.extractTOC(doc)
 	if err != nil {
-		return fmt.Errorf("failed to extract TOC: %w", err)
+		return "", fmt.Errorf("failed to extract TOC: %w", err)
 	}
 
 	// Process chapters
+	footnotes := false
+	var bookChapters []HTMLChapter
+	var sections []search.Section
 	for i := range chapters {
 		processedContent, err := c.processChapterContent(chapters[i].Content)
 		if err != nil {
-			return fmt.Errorf("failed to process chapter %s: %w", chapters[i].Title, err)
+			return "", fmt.Errorf("failed to process chapter %s: %w", chapters[i].Title, err)
 		}
 		doc, err := html.Parse(strings.NewReader(processedContent))
 		if err != nil {
-			return fmt.Errorf("failed to parse processed content: %w", err)
+			return "", fmt.Errorf("failed to parse processed content: %w", err)
 		}
 		c.processLinks(doc)
+		if inlineFootnotes(doc) {
+			footnotes = true
+		}
+		if placeImages != nil {
+			if err := placeImages(doc); err != nil {
+				return "", fmt.Errorf("failed to process images in chapter %s: %w", chapters[i].Title, err)
+			}
+		}
 
 		// Render the processed document back to string
 		var buf strings.Builder
 		if err := html.Render(&buf, doc); err != nil {
-			return fmt.Errorf("failed to render processed content: %w", err)
+			return "", fmt.Errorf("failed to render processed content: %w", err)
 		}
 		chapters[i].Content = buf.String()
+		bookChapters = append(bookChapters, HTMLChapter{
+			Title:    chapters[i].Title,
+			URL:      chapters[i].URL,
+			Number:   chapters[i].Number,
+			ID:       htmlChapterID(chapters[i]),
+			Content:  chapters[i].Content,
+			Sections: htmlSections(doc),
+		})
+		if c.Search {
+			sections = append(sections, searchSections(bookChapters[i], doc)...)
+		}
 	}
 
-	data := struct {
-		CSS   string
-		TOC   string
-		Parts []Part
-	}{
-		CSS:   css,
-		TOC:   tocHTML,
-		Parts: c.organizeParts(chapters),
+	data.Meta = c.metadata()
+	data.Theme = c.Theme
+	data.TOC = tocHTML
+	data.Chapters = bookChapters
+	data.Footnotes = footnotes
+	data.FootnoteCSS = footnoteCSS
+	if c.Search {
+		if data.SearchIndex, err = search.Build(sections).JSON(); err != nil {
+			return "", fmt.Errorf("failed to build search index: %w", err)
+		}
+		data.SearchScript = search.Script
+		data.SearchCSS = search.CSS
 	}
 
-	tmpl, err := template.New("book").Funcs(template.FuncMap{
-		"trimPrefix": strings.TrimPrefix,
-	}).Parse(htmlTemplate)
-	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+	// Parts are consecutive runs of chapters
+	next := 0
+	for _, part := range c.organizeParts(chapters) {
+		data.Parts = append(data.Parts, HTMLPart{
+			Title:    part.Title,
+			Chapters: bookChapters[next : next+len(part.Chapters)],
+		})
+		next += len(part.Chapters)
 	}
 
-	outputPath := filepath.Join(c.OutputDir, "index.html")
-	f, err := os.Create(outputPath)
+	tmpl, err := parseHTMLTemplates(c.TemplateDir)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return "", err
 	}
-	defer f.Close()
-
-	return tmpl.Execute(f, data)
-}
 
-func (c *HTMLConverter) organizeParts(chapters []downloader.Chapter) []Part {
-	if len(chapters) < 5 {
-		return []Part{{
-			Title:    "Contents",
-			Chapters: chapters,
-		}}
-	}
-
-	return []Part{
-		{
-			Title:    "Introduction",
-			Chapters: chapters[0:3],
-		},
-		{
-			Title:    "Part 1: Shaping",
-			Chapters: chapters[3:8],
-		},
-		{
-			Title:    "Part 2: Betting",
-			Chapters: chapters[8:11],
-		},
-		{
-			Title:    "Part 3: Building",
-			Chapters: chapters[11:18],
-		},
-		{
-			Title:    "Appendices",
-			Chapters: chapters[18:],
-		},
+	var page strings.Builder
+	if err := tmpl.ExecuteTemplate(&page, htmlMainTemplate, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
 	}
+
+	return page.String(), nil
 }
 
 func (c *HTMLConverter) processLinks(node *html.Node) {
-	links := findBookLinks(node)
-	for _, link := range links {
-		href := getAttr(link, "href")
-
-		// Strip basecamp prefix if present
-		href = strings.TrimPrefix(href, "https://basecamp.com/shapeup/")
-		href = strings.TrimPrefix(href, "/shapeup/")
-
-		// If href already contains a #, preserve only the section reference
-		if strings.Contains(href, "#") {
-			parts := strings.Split(href, "#")
-			href = "#" + parts[1]
-		} else {
-			href = "#" + href
-		}
-
-		setAttr(link, "href", href)
-	}
+	c.processAnchorLinks(node)
 }
 
 func (c *HTMLConverter) extractTOC(doc *html.Node) (string, error) {
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// preparePrint marks the links in the book's chapters for print.css: links
+// to a chapter or section become cross-references, which print with the
+// page they point to, and external links get a copy of their URL, which
+// prints as a footnote. Links in a table of contents get their page from
+// print.css already.
+func preparePrint(doc *html.Node) {
+	body := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "main"
+	})
+	if body == nil {
+		return
+	}
+
+	targets := make(map[string]bool)
+	for _, n := range findAllNodes(body, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && getAttr(n, "id") != "" &&
+			(n.Data == "article" || n.Data == "h1" || n.Data == "h2" || n.Data == "h3")
+	}) {
+		targets[getAttr(n, "id")] = true
+	}
+
+	for _, link := range findAllNodes(body, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "a" && getAttr(n, "href") != ""
+	}) {
+		if inClass(link, "footnote-popup", "toc") {
+			continue
+		}
+		href := getAttr(link, "href")
+		switch {
+		case strings.HasPrefix(href, "#") && targets[href[1:]]:
+			addClass(link, "print-xref")
+		case strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://"):
+			// A link that shows its URL already needs no footnote
+			if normalizeSpace(extractText(link)) == href {
+				continue
+			}
+			url := &html.Node{Type: html.ElementNode, Data: "span", Attr: []html.Attribute{{Key: "class", Val: "print-url"}}}
+			url.AppendChild(&html.Node{Type: html.TextNode, Data: href})
+			link.Parent.InsertBefore(url, link.NextSibling)
+		}
+	}
+}
+
+// inClass reports whether n is inside an element with one of the classes
+func inClass(n *html.Node, classes ...string) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		for _, class := range classes {
+			if hasClass(p, class) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package converter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// TestPreparePrint verifies links to chapters and sections become
+// cross-references and external links get their URL, but not in tables of
+// contents or footnote pop-ups
+func TestPreparePrint(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"chapter", `<a href="#1.1">Principles</a>`, `<a href="#1.1" class="print-xref">Principles</a>`},
+		{"section", `<a href="#scope">scope</a>`, `<a href="#scope" class="print-xref">scope</a>`},
+		{"unknown anchor", `<a href="#fn1">1</a>`, `<a href="#fn1">1</a>`},
+		{"external", `<a href="https://basecamp.com">Basecamp</a>`, `<a href="https://basecamp.com">Basecamp</a><span class="print-url">https://basecamp.com</span>`},
+		{"external showing its URL", `<a href="https://basecamp.com">https://basecamp.com</a>`, `<a href="https://basecamp.com">https://basecamp.com</a>`},
+		{"mail", `<a href="mailto:ryan@example.com">Ryan</a>`, `<a href="mailto:ryan@example.com">Ryan</a>`},
+		{"table of contents", `<div class="toc"><a href="#1.1">Principles</a></div>`, `<div class="toc"><a href="#1.1">Principles</a></div>`},
+		{"footnote pop-up", `<span class="footnote-popup"><a href="https://basecamp.com">Basecamp</a></span>`, `<span class="footnote-popup"><a href="https://basecamp.com">Basecamp</a></span>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(`<main><article id="1.1"><h2 id="scope">Scope</h2><div>` + tt.link + `</div></article></main>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			preparePrint(doc)
+			var buf bytes.Buffer
+			if err := html.Render(&buf, doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); !strings.Contains(got, "<div>"+tt.want+"</div>") {
+				t.Errorf("preparePrint() = %s, want it to contain %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHTMLConverter_Print verifies print mode adds the paged media rules
+// and marks the chapters' links, and pruning drops the rules for links the
+// book doesn't have
+func TestHTMLConverter_Print(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+		{
+			Title:   "Chapter 1",
+			Content: `<div class="content"><h1>Chapter 1</h1><p>Read <a href="https://basecamp.com/books">more</a>.</p></div>`,
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	outputDir := t.TempDir()
+	conv := NewHTMLConverter(outputDir)
+	conv.Print = true
+	conv.Log = io.Discard
+	if err := conv.Convert(chapters, "h1 { margin: 0; }"); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	for _, want := range []string{
+		"@bottom-center",
+		"string-set: chapter content(text)",
+		"target-counter(attr(href url), page)",
+		`<span class="print-url">https://basecamp.com/books</span>`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Convert() output missing %s:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "print-xref") {
+		t.Error("Convert() kept the cross-reference rule for a book without any")
+	}
+}
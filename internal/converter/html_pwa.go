@@ -0,0 +1,224 @@
+package converter
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
+)
+
+// pwaWorker is the service worker script, without the asset manifest the
+// converter puts above it
+//
+//go:embed pwa_worker.js
+var pwaWorker string
+
+// Files an installable HTML book adds beside index.html
+const (
+	pwaManifestFile      = "manifest.webmanifest"
+	pwaWorkerFile        = "sw.js"
+	pwaAssetManifestFile = "asset-manifest.json"
+	pwaIconsDir          = "icons"
+)
+
+// pwaIconSizes are the icon sizes browsers ask a web app manifest for
+var pwaIconSizes = []int{192, 512}
+
+// HTMLPWA is what the templates need to make the page installable
+type HTMLPWA struct {
+	// Manifest and ServiceWorker are the paths of the web app manifest and
+	// the service worker to register
+	Manifest      string
+	ServiceWorker string
+	// Icon is the path of the icon for home screens that ignore the
+	// manifest
+	Icon       string
+	ThemeColor string
+}
+
+// pwaIcon is an icon entry of the web app manifest
+type pwaIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// pwaManifest is the web app manifest
+type pwaManifest struct {
+	Name            string    `json:"name"`
+	ShortName       string    `json:"short_name"`
+	Description     string    `json:"description,omitempty"`
+	Lang            string    `json:"lang,omitempty"`
+	StartURL        string    `json:"start_url"`
+	Scope           string    `json:"scope"`
+	Display         string    `json:"display"`
+	BackgroundColor string    `json:"background_color"`
+	ThemeColor      string    `json:"theme_color"`
+	Icons           []pwaIcon `json:"icons"`
+}
+
+// pwaAssetManifest lists the files the service worker precaches, with a
+// hash of each one's content, and a version that changes with any of them
+type pwaAssetManifest struct {
+	Version string            `json:"version"`
+	Files   map[string]string `json:"files"`
+}
+
+// writePWAManifest writes icons made from the cover and the web app
+// manifest to the output directory
+func (c *HTMLConverter) writePWAManifest() (*HTMLPWA, error) {
+	meta := c.metadata()
+	cover, err := pwaCover(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.OutputDir, pwaIconsDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create icons directory: %w", err)
+	}
+	var icons []pwaIcon
+	var background color.RGBA
+	for _, size := range pwaIconSizes {
+		var data []byte
+		data, background, err = imaging.Icon(cover, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make %dpx icon: %w", size, err)
+		}
+		name := pwaIconsDir + "/icon-" + strconv.Itoa(size) + ".png"
+		if err := os.WriteFile(filepath.Join(c.OutputDir, filepath.FromSlash(name)), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write icon: %w", err)
+		}
+		icons = append(icons, pwaIcon{Src: name, Sizes: fmt.Sprintf("%dx%d", size, size), Type: "image/png"})
+	}
+
+	themeColor := fmt.Sprintf("#%02x%02x%02x", background.R, background.G, background.B)
+	manifest, err := json.MarshalIndent(pwaManifest{
+		Name:            meta.Title,
+		ShortName:       meta.Title,
+		Description:     meta.Description,
+		Lang:            meta.Language,
+		StartURL:        "./",
+		Scope:           "./",
+		Display:         "standalone",
+		BackgroundColor: themeColor,
+		ThemeColor:      themeColor,
+		Icons:           icons,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode web app manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(c.OutputDir, pwaManifestFile), manifest, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write web app manifest: %w", err)
+	}
+
+	return &HTMLPWA{
+		Manifest:      pwaManifestFile,
+		ServiceWorker: pwaWorkerFile,
+		Icon:          icons[0].Src,
+		ThemeColor:    themeColor,
+	}, nil
+}
+
+// pwaCover returns the cover image icons are made from. A cover Go can't
+// decode, such as an SVG, is replaced by the generated one.
+func pwaCover(meta downloader.Metadata) ([]byte, error) {
+	if meta.CoverImage != "" {
+		data, mediaType, err := decodeDataURL(meta.CoverImage)
+		if err == nil && mediaType != "image/svg+xml" {
+			return data, nil
+		}
+	}
+	data, err := renderCover(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cover: %w", err)
+	}
+	return data, nil
+}
+
+// writeServiceWorker hashes the files the page needs, writes them out as
+// the asset manifest, and writes the service worker that precaches them
+func (c *HTMLConverter) writeServiceWorker(files []string) error {
+	manifest := pwaAssetManifest{Files: make(map[string]string)}
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(c.OutputDir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		manifest.Files[name] = contentHash(data)
+	}
+
+	// The version hashes the file list with each file's hash
+	sort.Strings(files)
+	var all strings.Builder
+	for _, name := range files {
+		all.WriteString(name + " " + manifest.Files[name] + "\n")
+	}
+	manifest.Version = contentHash([]byte(all.String()))
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode asset manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(c.OutputDir, pwaAssetManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write asset manifest: %w", err)
+	}
+
+	filesJSON, err := json.Marshal(manifest.Files)
+	if err != nil {
+		return fmt.Errorf("failed to encode asset manifest: %w", err)
+	}
+	worker := fmt.Sprintf("const VERSION = %q;\nconst FILES = %s;\n\n%s", manifest.Version, filesJSON, pwaWorker)
+	if err := os.WriteFile(filepath.Join(c.OutputDir, pwaWorkerFile), []byte(worker), 0644); err != nil {
+		return fmt.Errorf("failed to write service worker: %w", err)
+	}
+	return nil
+}
+
+// pwaFiles returns the files of the book the service worker precaches:
+// the page, the manifest and icons, and the images and template assets,
+// as paths relative to the output directory
+func (c *HTMLConverter) pwaFiles(assets []string) ([]string, error) {
+	files := []string{"index.html", pwaManifestFile}
+	dirs := []string{pwaIconsDir}
+	if !c.EmbedImages {
+		dirs = append(dirs, bookImagesDir)
+	}
+	for _, dir := range dirs {
+		root := filepath.Join(c.OutputDir, dir)
+		if _, err := os.Stat(root); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(c.OutputDir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+	}
+	return append(files, assets...), nil
+}
+
+// contentHash returns a short hash of data for cache busting
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
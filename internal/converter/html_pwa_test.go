@@ -0,0 +1,121 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestHTMLConverter_PWA verifies an installable book gets a manifest, icons
+// and a service worker whose asset manifest changes only for the files that
+// changed
+func TestHTMLConverter_PWA(t *testing.T) {
+	image := downloader.NewAsset([]byte("png-data"), "image/png")
+	chapters := func(text string) []downloader.Chapter {
+		return []downloader.Chapter{
+			{
+				Title:   "Table of Contents",
+				Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+				URL:     "https://basecamp.com/shapeup/toc",
+			},
+			{
+				Title:   "Chapter 1",
+				Content: `<div class="content"><p>` + text + `</p><p><img src="` + image.URL() + `" alt="Sketch"></p></div>`,
+				URL:     "https://basecamp.com/shapeup/1.1",
+				Number:  1,
+				Assets:  map[string]downloader.Asset{image.URL(): image},
+			},
+		}
+	}
+
+	testDir := t.TempDir()
+	convert := func(text string) (pwaAssetManifest, string) {
+		t.Helper()
+		conv := NewHTMLConverter(testDir)
+		conv.PWA = true
+		conv.Log = io.Discard
+		conv.SetMetadata(downloader.DefaultMetadata)
+		if err := conv.Convert(chapters(text), ""); err != nil {
+			t.Fatalf("Convert() error = %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(testDir, pwaAssetManifestFile))
+		if err != nil {
+			t.Fatalf("Failed to read asset manifest: %v", err)
+		}
+		var manifest pwaAssetManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatalf("asset manifest doesn't decode: %v", err)
+		}
+		worker, err := os.ReadFile(filepath.Join(testDir, pwaWorkerFile))
+		if err != nil {
+			t.Fatalf("Failed to read service worker: %v", err)
+		}
+		return manifest, string(worker)
+	}
+
+	first, worker := convert("Shaping")
+
+	for _, name := range []string{"index.html", pwaManifestFile, "icons/icon-192.png", "icons/icon-512.png", bookImagesDir + "/" + image.Name} {
+		if first.Files[name] == "" {
+			t.Errorf("asset manifest is missing %s: %v", name, first.Files)
+		}
+	}
+	if !strings.HasPrefix(worker, `const VERSION = "`+first.Version+`";`) {
+		t.Errorf("service worker doesn't start with the version %s:\n%.200s", first.Version, worker)
+	}
+
+	page, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<link rel="manifest" href="manifest.webmanifest"/>`, `register("sw.js")`, `<meta name="theme-color"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("index.html missing %s", want)
+		}
+	}
+
+	var manifest pwaManifest
+	data, err := os.ReadFile(filepath.Join(testDir, pwaManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("web app manifest doesn't decode: %v", err)
+	}
+	if manifest.Name != downloader.DefaultMetadata.Title || manifest.StartURL != "./" || len(manifest.Icons) != len(pwaIconSizes) {
+		t.Errorf("unexpected web app manifest %+v", manifest)
+	}
+	for i, icon := range manifest.Icons {
+		data, err := os.ReadFile(filepath.Join(testDir, filepath.FromSlash(icon.Src)))
+		if err != nil {
+			t.Fatalf("Failed to read icon: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil || img.Bounds().Dx() != pwaIconSizes[i] {
+			t.Errorf("icon %s isn't a %dpx PNG (%v)", icon.Src, pwaIconSizes[i], err)
+		}
+	}
+
+	second, _ := convert("Betting")
+	if second.Version == first.Version || second.Files["index.html"] == first.Files["index.html"] {
+		t.Error("changing a chapter should change the page's hash and the version")
+	}
+	if name := bookImagesDir + "/" + image.Name; second.Files[name] != first.Files[name] {
+		t.Error("an unchanged image should keep its hash")
+	}
+
+	if node, err := exec.LookPath("node"); err == nil {
+		if out, err := exec.Command(node, "--check", filepath.Join(testDir, pwaWorkerFile)).CombinedOutput(); err != nil {
+			t.Errorf("service worker doesn't parse: %v\n%s", err, out)
+		}
+	}
+}
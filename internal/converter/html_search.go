@@ -0,0 +1,60 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/search"
+	"golang.org/x/net/html"
+)
+
+// inlineElements are the elements whose text runs on from their
+// neighbours'
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "cite": true, "code": true, "em": true,
+	"i": true, "kbd": true, "mark": true, "q": true, "s": true, "small": true,
+	"span": true, "strong": true, "sub": true, "sup": true, "u": true,
+}
+
+// searchSections splits a processed chapter into the sections the search
+// index points at: its opening, up to the first anchored h2, and each
+// anchored h2 with the text that follows it
+func searchSections(chapter HTMLChapter, doc *html.Node) []search.Section {
+	sections := []search.Section{{ID: chapter.ID, Chapter: chapter.Title, Title: chapter.Title}}
+	var text []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text = append(text, n.Data)
+			return
+		case n.Type != html.ElementNode && n.Type != html.DocumentNode:
+			return
+		case n.Data == "script" || n.Data == "style" || hasClass(n, "footnote-popup"):
+			// Footnote pop-ups repeat the notes at the end of the chapter
+			return
+		case n.Data == "h2" && getAttr(n, "id") != "":
+			sections[len(sections)-1].Text = normalizeSpace(strings.Join(text, ""))
+			sections = append(sections, search.Section{
+				ID:      getAttr(n, "id"),
+				Chapter: chapter.Title,
+				Title:   normalizeSpace(extractText(n)),
+			})
+			text = nil
+			return
+		}
+		// Blocks are kept apart by spaces, so their words don't run together
+		block := n.Type == html.ElementNode && !inlineElements[n.Data]
+		if block {
+			text = append(text, " ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			text = append(text, " ")
+		}
+	}
+	walk(doc)
+	sections[len(sections)-1].Text = normalizeSpace(strings.Join(text, ""))
+	return sections
+}
@@ -0,0 +1,102 @@
+package converter
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/search"
+	"golang.org/x/net/html"
+)
+
+// TestSearchSections verifies a chapter is split at its anchored h2s and
+// footnote pop-ups aren't indexed twice
+func TestSearchSections(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div class="content">
+		<h1>Set Boundaries</h1><p>Start with an <em>appetite</em>.</p>
+		<h2 id="fixed-time">Fixed time, variable scope</h2><p>Hammer the scope.<span class="footnote-popup">Note text</span></p>
+		<h2>Unanchored</h2><p>Still fixed time.</p>
+		<h2 id="narrow">Narrow down</h2>
+	</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := searchSections(HTMLChapter{ID: "1.3", Title: "Set Boundaries"}, doc)
+	want := []search.Section{
+		{ID: "1.3", Chapter: "Set Boundaries", Title: "Set Boundaries", Text: "Set Boundaries Start with an appetite."},
+		{ID: "fixed-time", Chapter: "Set Boundaries", Title: "Fixed time, variable scope", Text: "Hammer the scope. Unanchored Still fixed time."},
+		{ID: "narrow", Chapter: "Set Boundaries", Title: "Narrow down"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchSections() = %+v, want %+v", got, want)
+	}
+}
+
+// TestHTMLConverter_Search verifies the page carries the search box, its
+// unpruned CSS and an index of the chapters, unless Search is off
+func TestHTMLConverter_Search(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+		{
+			Title:   "Principles of Shaping",
+			Content: `<div class="content"><h1>Principles of Shaping</h1><h2 id="breadboarding">Breadboarding</h2><p>Places, affordances and connection lines.</p></div>`,
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	for _, enabled := range []bool{true, false} {
+		testDir := t.TempDir()
+		conv := NewHTMLConverter(testDir)
+		conv.Search = enabled
+		conv.Log = io.Discard
+		if err := conv.Convert(chapters, ""); err != nil {
+			t.Fatalf("Convert() error = %v", err)
+		}
+		content, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+		if err != nil {
+			t.Fatalf("Failed to read output file: %v", err)
+		}
+		page := string(content)
+
+		if !enabled {
+			if strings.Contains(page, search.IndexElementID) || strings.Contains(page, `id="search-input"`) {
+				t.Error("Convert() added search with Search off")
+			}
+			continue
+		}
+
+		for _, want := range []string{`id="search-input"`, `id="search-results"`, ".search-snippet {", "function stem(word)"} {
+			if !strings.Contains(page, want) {
+				t.Errorf("Convert() output missing %s", want)
+			}
+		}
+
+		doc, err := html.Parse(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		element := findNode(doc, func(n *html.Node) bool { return getAttr(n, "id") == search.IndexElementID })
+		if element == nil || element.FirstChild == nil {
+			t.Fatal("Convert() output has no search index")
+		}
+		var index search.Index
+		if err := json.Unmarshal([]byte(element.FirstChild.Data), &index); err != nil {
+			t.Fatalf("search index doesn't decode: %v", err)
+		}
+		postings := index.Terms[search.Stem("affordances")]
+		if len(postings) != 1 || index.Sections[postings[0]].ID != "breadboarding" {
+			t.Errorf("affordances found in %v, want the breadboarding section", postings)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package converter
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// defaultTemplates holds the built-in HTML templates and themes, under
+// htmlTemplatesRoot
+//
+//go:embed templates/html
+var defaultTemplates embed.FS
+
+const (
+	htmlTemplatesRoot = "templates/html"
+	// htmlMainTemplate is the template that renders index.html
+	htmlMainTemplate = "book.html"
+	// htmlPrintStyleSheet holds the CSS Paged Media rules of print mode
+	htmlPrintStyleSheet = "print.css"
+	// htmlThemesDir and htmlAssetsDir are folders of a template directory
+	htmlThemesDir = "themes"
+	htmlAssetsDir = "assets"
+)
+
+// HTMLThemes lists the built-in themes for HTML output
+var HTMLThemes = []string{"light", "dark", "sepia", "print"}
+
+// HTMLTemplateData is what HTML templates are executed with. Templates are
+// Go text/templates, so text must be escaped with the html function.
+type HTMLTemplateData struct {
+	// Meta is the book's metadata
+	Meta downloader.Metadata
+	// CSS is the stylesheet to inline, unless StylesheetHref is set
+	CSS            string
+	StylesheetHref string
+	// Theme names the theme whose CSS is part of the stylesheet, if any
+	Theme string
+	// TOC is the table of contents as HTML
+	TOC string
+	// Parts holds the chapters grouped into the book's parts, and Chapters
+	// holds them all in order
+	Parts    []HTMLPart
+	Chapters []HTMLChapter
+	// Assets lists the files copied from the template directory's assets
+	// folder, as paths relative to index.html
+	Assets []string
+	// Footnotes is set when a chapter has footnotes, which need FootnoteCSS
+	Footnotes   bool
+	FootnoteCSS string
+	// SearchIndex is the search index as JSON, if the page has a search
+	// box, which SearchScript and SearchCSS run and style. The script
+	// reads the index from the element with the id search-index.
+	SearchIndex  string
+	SearchScript string
+	SearchCSS    string
+	// PWA holds the web app manifest and service worker of an installable
+	// book, or nil
+	PWA *HTMLPWA
+}
+
+// HTMLPart is a part of the book in HTMLTemplateData
+type HTMLPart struct {
+	Title    string
+	Chapters []HTMLChapter
+}
+
+// HTMLChapter is a chapter in HTMLTemplateData
+type HTMLChapter struct {
+	Title  string
+	URL    string
+	Number int
+	// ID is the chapter's anchor on the page, which links point at
+	ID string
+	// Content is the chapter's processed HTML
+	Content string
+	// Sections lists the chapter's headings that have an anchor
+	Sections []HTMLSection
+}
+
+// HTMLSection is a heading inside a chapter
+type HTMLSection struct {
+	ID    string
+	Title string
+}
+
+// htmlChapterID returns the anchor of a chapter on the page
+func htmlChapterID(chapter downloader.Chapter) string {
+	return strings.TrimPrefix(chapter.URL, "https://basecamp.com/shapeup/")
+}
+
+// htmlSections returns the h2 headings of a chapter that have an id
+func htmlSections(doc *html.Node) []HTMLSection {
+	var sections []HTMLSection
+	for _, heading := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "h2" && getAttr(n, "id") != ""
+	}) {
+		sections = append(sections, HTMLSection{
+			ID:    getAttr(heading, "id"),
+			Title: normalizeSpace(extractText(heading)),
+		})
+	}
+	return sections
+}
+
+// builtinTemplates returns the built-in templates as a file system rooted
+// like a template directory
+func builtinTemplates() fs.FS {
+	fsys, err := fs.Sub(defaultTemplates, htmlTemplatesRoot)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// parseHTMLTemplates parses the built-in templates, then those in dir, if
+// given, so a directory only needs the templates it changes
+func parseHTMLTemplates(dir string) (*template.Template, error) {
+	tmpl := template.New(htmlMainTemplate).Funcs(template.FuncMap{
+		"trimPrefix": strings.TrimPrefix,
+		"hasSuffix":  strings.HasSuffix,
+	})
+	tmpl, err := tmpl.ParseFS(builtinTemplates(), "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built-in templates: %w", err)
+	}
+	if dir == "" {
+		return tmpl, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("failed to read template directory: %w", err)
+		}
+		return tmpl, nil
+	}
+	if tmpl, err = tmpl.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("failed to parse templates in %s: %w", dir, err)
+	}
+	return tmpl, nil
+}
+
+// themeCSS returns the CSS of a theme, from the template directory's themes
+// folder if it has one by that name, or else a built-in theme
+func themeCSS(dir, name string) (string, error) {
+	css, err := templateFile(dir, path.Join(htmlThemesDir, name+".css"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("unknown theme: %q (must be one of: %s, or one in the template directory)", name, strings.Join(HTMLThemes, ", "))
+	}
+	return css, err
+}
+
+// templateFile returns a file of the template directory, or the built-in
+// one by that name if the directory doesn't have it. name is slash
+// separated.
+func templateFile(dir, name string) (string, error) {
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+
+	data, err := fs.ReadFile(builtinTemplates(), name)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// copyTemplateAssets copies the template directory's assets folder into
+// outputDir and returns the paths of the files, relative to outputDir
+func copyTemplateAssets(dir, outputDir string) ([]string, error) {
+	root := filepath.Join(dir, htmlAssetsDir)
+	if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	var assets []string
+	err := filepath.WalkDir(root, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, src)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(outputDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+		assets = append(assets, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy template assets: %w", err)
+	}
+	return assets, nil
+}
+
+// ExportHTMLTemplates writes the built-in HTML templates and themes to dir,
+// as a starting point for a template directory. It won't overwrite files.
+func ExportHTMLTemplates(dir string) ([]string, error) {
+	var written []string
+	err := fs.WalkDir(builtinTemplates(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(builtinTemplates(), name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		written = append(written, dst)
+		return nil
+	})
+	if err != nil {
+		return written, fmt.Errorf("failed to export templates: %w", err)
+	}
+	return written, nil
+}
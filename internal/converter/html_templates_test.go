@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestThemeCSS verifies themes come from the template directory first and
+// the built-in ones otherwise
+func TestThemeCSS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, htmlThemesDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, htmlThemesDir, "dark.css"), []byte("body { background: black; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, htmlThemesDir, "solar.css"), []byte("body { background: yellow; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		dir       string
+		theme     string
+		want      string
+		wantError bool
+	}{
+		{"built-in", "", "sepia", "#f4ecd8", false},
+		{"overridden", dir, "dark", "background: black", false},
+		{"user theme", dir, "solar", "background: yellow", false},
+		{"built-in beside a directory", dir, "print", "@page", false},
+		{"unknown", "", "solar", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			css, err := themeCSS(tt.dir, tt.theme)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("themeCSS() error = %v, wantError %v", err, tt.wantError)
+			}
+			if !strings.Contains(css, tt.want) {
+				t.Errorf("themeCSS() = %q, want it to contain %q", css, tt.want)
+			}
+		})
+	}
+}
+
+// TestHTMLConverter_TemplateDir verifies templates in the template directory
+// replace the built-in ones, see the chapters and their sections, and can
+// link the copied assets
+func TestHTMLConverter_TemplateDir(t *testing.T) {
+	dir := t.TempDir()
+	titlePage := `<nav id="custom">{{range .Chapters}}<a href="#{{.ID}}">{{.Title | html}}</a>` +
+		`{{range .Sections}}<a href="#{{.ID}}">{{.Title | html}}</a>{{end}}{{end}}</nav>`
+	if err := os.WriteFile(filepath.Join(dir, "title-page.html"), []byte(titlePage), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, htmlAssetsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, htmlAssetsDir, "custom.css"), []byte(".custom { color: red; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+		{
+			Title:   "Chapter 1",
+			Content: `<div class="content"><h1>Chapter 1</h1><h2 id="scope">Scope &amp; risk</h2></div>`,
+			URL:     "https://basecamp.com/shapeup/1.1",
+			Number:  1,
+		},
+	}
+
+	outputDir := t.TempDir()
+	conv := NewHTMLConverter(outputDir)
+	conv.TemplateDir = dir
+	conv.Log = io.Discard
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	for _, want := range []string{
+		`<nav id="custom"><a href="#toc">Table of Contents</a><a href="#1.1">Chapter 1</a><a href="#scope">Scope &amp; risk</a></nav>`,
+		`<link rel="stylesheet" href="assets/custom.css"/>`,
+		`<article id="1.1">`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Convert() output missing %s:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "landing-title") {
+		t.Error("Convert() used the built-in title page")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(outputDir, htmlAssetsDir, "custom.css")); string(data) != ".custom { color: red; }" {
+		t.Errorf("copied asset = %q, %v", data, err)
+	}
+}
+
+// TestHTMLConverter_Theme verifies a theme's CSS is added to the page's
+// stylesheet and an unknown theme is an error
+func TestHTMLConverter_Theme(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+	}
+
+	outputDir := t.TempDir()
+	conv := NewHTMLConverter(outputDir)
+	conv.Theme = "dark"
+	conv.Log = io.Discard
+	if err := conv.Convert(chapters, "h1 { margin: 0; }"); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(outputDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if !strings.Contains(string(content), "body { background: #1e1e1e; color: #dddddd; }") {
+		t.Errorf("Convert() output missing the dark theme:\n%s", content)
+	}
+
+	conv.Theme = "neon"
+	if err := conv.Convert(chapters, ""); err == nil {
+		t.Error("Convert() should fail for an unknown theme")
+	}
+}
+
+// TestExportHTMLTemplates verifies the exported templates parse as a
+// template directory and existing files aren't overwritten
+func TestExportHTMLTemplates(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "templates")
+	written, err := ExportHTMLTemplates(dir)
+	if err != nil {
+		t.Fatalf("ExportHTMLTemplates() error = %v", err)
+	}
+
+	want := []string{htmlMainTemplate, htmlPrintStyleSheet, "search.html", "title-page.html"}
+	for _, theme := range HTMLThemes {
+		want = append(want, filepath.Join(htmlThemesDir, theme+".css"))
+	}
+	if len(written) != len(want) {
+		t.Errorf("ExportHTMLTemplates() wrote %v, want %d files", written, len(want))
+	}
+	for _, name := range want {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("missing exported file %s: %v", name, err)
+		}
+	}
+
+	if _, err := parseHTMLTemplates(dir); err != nil {
+		t.Errorf("exported templates don't parse: %v", err)
+	}
+	if _, err := ExportHTMLTemplates(dir); err == nil {
+		t.Error("ExportHTMLTemplates() should not overwrite existing files")
+	}
+}
@@ -1,11 +1,13 @@
 package converter
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/device"
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 	"golang.org/x/net/html"
 )
@@ -29,7 +31,7 @@ func TestHTMLConverter_Convert(t *testing.T) {
 		},
 		{
 			Title:   "Chapter 1",
-			Content: "<div class='content'><h1>Test Content</h1><p>Test paragraph</p></div>",
+			Content: "<div class='content'><h1>Test Content</h1><p>Test paragraph<a href='#fn1'>1</a></p><ol class='footnotes'><li id='fn1'>Test note</li></ol></div>",
 			URL:     "https://basecamp.com/shapeup/1.1",
 			Number:  1,
 		},
@@ -58,6 +60,8 @@ func TestHTMLConverter_Convert(t *testing.T) {
 		"<style>body { color: black; }</style>",
 		"Test Content",
 		"Test paragraph",
+		`<span class="footnote-popup" role="note">Test note</span>`,
+		".footnote:hover .footnote-popup",
 	}
 
 	for _, expected := range expectedElements {
@@ -67,6 +71,174 @@ func TestHTMLConverter_Convert(t *testing.T) {
 	}
 }
 
+// TestHTMLConverter_Device verifies the device profile's CSS is applied to
+// the page's stylesheet
+func TestHTMLConverter_Device(t *testing.T) {
+	testDir := t.TempDir()
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+	}
+
+	conv := NewHTMLConverter(testDir)
+	conv.SetDevice(device.Profile{Margins: "0", CSS: "img { max-width: 100%; }"})
+	conv.PruneCSS = false
+	css := "@font-face { font-family: Book; src: url(book.woff2); }\nbody { color: black; }"
+	if err := conv.Convert(chapters, css); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	want := "<style>body { color: black; }\nbody { margin: 0; }\nimg { max-width: 100%; }\n</style>"
+	if !strings.Contains(string(content), want) {
+		t.Errorf("Convert() output missing %q:\n%s", want, content)
+	}
+}
+
+// TestHTMLConverter_Images verifies downloaded images are written to the
+// images folder, or inlined with EmbedImages
+func TestHTMLConverter_Images(t *testing.T) {
+	asset := downloader.NewAsset([]byte("png-data"), "image/png")
+
+	tests := []struct {
+		name        string
+		embedImages bool
+		wantSrc     string
+		wantFile    bool
+	}{
+		{"images folder", false, `src="images/` + asset.Name + `"`, true},
+		{"embedded", true, `src="data:image/png;base64,`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDir := t.TempDir()
+			chapters := []downloader.Chapter{
+				{
+					Title:   "Table of Contents",
+					Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+					URL:     "https://basecamp.com/shapeup/toc",
+				},
+				{
+					Title:   "Chapter 1",
+					Content: `<div class="content"><p><img src="` + asset.URL() + `" alt="Sketch"></p></div>`,
+					URL:     "https://basecamp.com/shapeup/1.1",
+					Number:  1,
+					Assets:  map[string]downloader.Asset{asset.URL(): asset},
+				},
+			}
+
+			conv := NewHTMLConverter(testDir)
+			conv.EmbedImages = tt.embedImages
+			if err := conv.Convert(chapters, ""); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+			if err != nil {
+				t.Fatalf("Failed to read output file: %v", err)
+			}
+			if !strings.Contains(string(content), tt.wantSrc) {
+				t.Errorf("Convert() output missing %s", tt.wantSrc)
+			}
+
+			data, err := os.ReadFile(filepath.Join(testDir, bookImagesDir, asset.Name))
+			if tt.wantFile && string(data) != "png-data" {
+				t.Errorf("image file = %q, %v, want the asset", data, err)
+			}
+			if !tt.wantFile && err == nil {
+				t.Error("Convert() wrote an image file for an embedded image")
+			}
+		})
+	}
+}
+
+// TestHTMLConverter_Fonts verifies the stylesheet's fonts are inlined in
+// the page, whether or not images are
+func TestHTMLConverter_Fonts(t *testing.T) {
+	font := downloader.NewAsset([]byte("wOF2 font"), "font/woff2")
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+			Assets:  map[string]downloader.Asset{font.URL(): font},
+		},
+	}
+
+	testDir := t.TempDir()
+	conv := NewHTMLConverter(testDir)
+	if err := conv.Convert(chapters, `@font-face { font-family: Book; src: url("`+font.URL()+`") format("woff2"); } body { font-family: Book; }`); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	want := `src: url("data:font/woff2;base64,d09GMiBmb250") format("woff2")`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("Convert() output missing %s:\n%s", want, content)
+	}
+}
+
+// TestHTMLConverter_PruneCSS verifies rules that match nothing on the page
+// are dropped and reported, unless PruneCSS is off
+func TestHTMLConverter_PruneCSS(t *testing.T) {
+	chapters := []downloader.Chapter{
+		{
+			Title:   "Table of Contents",
+			Content: `<div class="content"><div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div></div>`,
+			URL:     "https://basecamp.com/shapeup/toc",
+		},
+	}
+	css := ".toc a { color: red; }\n.sidebar { float: left; }"
+
+	tests := []struct {
+		name       string
+		prune      bool
+		wantUnused bool
+		wantReport string
+	}{
+		{"pruned", true, false, "Pruned unused CSS: 48 B -> 22 B (55% smaller)\n"},
+		{"not pruned", false, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDir := t.TempDir()
+			var log bytes.Buffer
+			conv := NewHTMLConverter(testDir)
+			conv.PruneCSS = tt.prune
+			conv.Log = &log
+			if err := conv.Convert(chapters, css); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(testDir, "index.html"))
+			if err != nil {
+				t.Fatalf("Failed to read output file: %v", err)
+			}
+			if !strings.Contains(string(content), ".toc a { color: red; }") {
+				t.Errorf("Convert() dropped a used rule:\n%s", content)
+			}
+			if got := strings.Contains(string(content), ".sidebar"); got != tt.wantUnused {
+				t.Errorf("output has unused rule = %v, want %v", got, tt.wantUnused)
+			}
+			if log.String() != tt.wantReport {
+				t.Errorf("report = %q, want %q", log.String(), tt.wantReport)
+			}
+		})
+	}
+}
+
 // TestHTMLConverter_OrganizeParts tests the chapter organization logic
 func TestHTMLConverter_OrganizeParts(t *testing.T) {
 	conv := NewHTMLConverter("test")
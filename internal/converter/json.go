@@ -0,0 +1,393 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// jsonSchemaVersion is bumped whenever the structure of the JSON export
+// changes in a way that downstream tools need to know about. The schema
+// itself lives in schema/book.v1.schema.json.
+const (
+	jsonSchemaVersion = 1
+	jsonSchemaURL     = "https://raw.githubusercontent.com/benjaminkitt/shape-up-downloader/main/internal/converter/schema/book.v1.schema.json"
+)
+
+type jsonBook struct {
+	Schema        string       `json:"$schema"`
+	SchemaVersion int          `json:"schemaVersion"`
+	Metadata      jsonMetadata `json:"metadata"`
+	Parts         []jsonPart   `json:"parts"`
+	Images        []*jsonImage `json:"images"`
+}
+
+type jsonMetadata struct {
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle"`
+	Authors     []string `json:"authors"`
+	Language    string   `json:"language"`
+	Source      string   `json:"source"`
+	Description string   `json:"description,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	ISBN        string   `json:"isbn,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Copyright   string   `json:"copyright,omitempty"`
+	Cover       string   `json:"cover,omitempty"`
+	Modified    string   `json:"modified,omitempty"`
+}
+
+type jsonPart struct {
+	Title    string        `json:"title"`
+	Chapters []jsonChapter `json:"chapters"`
+}
+
+type jsonChapter struct {
+	Number   int            `json:"number"`
+	ID       string         `json:"id"`
+	URL      string         `json:"url"`
+	Title    string         `json:"title"`
+	Blocks   []jsonBlock    `json:"blocks"`
+	Sections []*jsonSection `json:"sections"`
+}
+
+// jsonSection is a heading and everything up to the next heading of the
+// same or a higher level
+type jsonSection struct {
+	ID       string         `json:"id"`
+	Title    string         `json:"title"`
+	Level    int            `json:"level"`
+	Blocks   []jsonBlock    `json:"blocks"`
+	Sections []*jsonSection `json:"sections"`
+}
+
+type jsonBlock struct {
+	Type   string         `json:"type"`
+	Text   string         `json:"text"`
+	HTML   string         `json:"html"`
+	Images []jsonImageRef `json:"images,omitempty"`
+	Links  []jsonLink     `json:"links,omitempty"`
+}
+
+type jsonImage struct {
+	Hash      string `json:"hash"`
+	MediaType string `json:"mediaType"`
+	Size      int    `json:"size"`
+}
+
+// jsonImageRef points at an image of the book by hash, or at a linked
+// image the book doesn't carry by URL
+type jsonImageRef struct {
+	Hash string `json:"hash,omitempty"`
+	URL  string `json:"url,omitempty"`
+	Alt  string `json:"alt"`
+}
+
+type jsonLink struct {
+	Text     string          `json:"text"`
+	Href     string          `json:"href"`
+	External bool            `json:"external"`
+	Target   *jsonLinkTarget `json:"target,omitempty"`
+}
+
+// jsonLinkTarget is the resolved destination of a link into the book
+type jsonLinkTarget struct {
+	Chapter   int    `json:"chapter"`
+	ChapterID string `json:"chapterId"`
+	Fragment  string `json:"fragment,omitempty"`
+}
+
+type JSONConverter struct {
+	OutputPath string
+	baseConverter
+}
+
+func NewJSONConverter(outputPath string) *JSONConverter {
+	if !strings.HasSuffix(outputPath, ".json") {
+		outputPath = outputPath + ".json"
+	}
+
+	return &JSONConverter{
+		OutputPath: outputPath,
+	}
+}
+
+func (j *JSONConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	meta := j.metadata()
+	book := jsonBook{
+		Schema:        jsonSchemaURL,
+		SchemaVersion: jsonSchemaVersion,
+		Metadata: jsonMetadata{
+			Title:       meta.Title,
+			Subtitle:    meta.Subtitle,
+			Authors:     []string{meta.Author},
+			Language:    meta.Language,
+			Source:      meta.URL,
+			Description: meta.Description,
+			Publisher:   meta.Publisher,
+			ISBN:        meta.ISBN,
+			Date:        meta.Date,
+			Copyright:   meta.Copyright,
+			Cover:       meta.Cover,
+		},
+		Parts:  []jsonPart{},
+		Images: []*jsonImage{},
+	}
+	if !meta.Modified.IsZero() {
+		book.Metadata.Modified = meta.Modified.UTC().Format(time.RFC3339)
+	}
+
+	images := newJSONImageIndex()
+
+	for _, part := range j.organizeParts(chapters) {
+		jp := jsonPart{
+			Title:    part.Title,
+			Chapters: []jsonChapter{},
+		}
+		for _, chapter := range part.Chapters {
+			jc, err := j.chapter(chapter, chapters, images)
+			if err != nil {
+				return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+			}
+			jp.Chapters = append(jp.Chapters, jc)
+		}
+		book.Parts = append(book.Parts, jp)
+	}
+	book.Images = append(book.Images, images.list...)
+
+	data, err := json.MarshalIndent(book, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode book: %w", err)
+	}
+
+	if err := os.WriteFile(j.OutputPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// chapter builds the section tree of a single chapter
+func (j *JSONConverter) chapter(chapter downloader.Chapter, chapters []downloader.Chapter, images *jsonImageIndex) (jsonChapter, error) {
+	processedContent, err := j.processChapterContent(chapter.Content)
+	if err != nil {
+		return jsonChapter{}, err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return jsonChapter{}, err
+	}
+
+	root := &jsonSection{Blocks: []jsonBlock{}, Sections: []*jsonSection{}}
+	stack := []*jsonSection{root}
+
+	for _, n := range blockNodes(body) {
+		if level := headingLevel(n); level > 0 {
+			if level == 1 {
+				// The chapter title is already part of the chapter record
+				continue
+			}
+
+			section := &jsonSection{
+				ID:       getAttr(n, "id"),
+				Title:    normalizeSpace(extractText(n)),
+				Level:    level,
+				Blocks:   []jsonBlock{},
+				Sections: []*jsonSection{},
+			}
+			for len(stack) > 1 && stack[len(stack)-1].Level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			parent := stack[len(stack)-1]
+			parent.Sections = append(parent.Sections, section)
+			stack = append(stack, section)
+			continue
+		}
+
+		block, err := j.block(n, chapter, chapters, images)
+		if err != nil {
+			return jsonChapter{}, err
+		}
+		if block.Text == "" && len(block.Images) == 0 {
+			continue
+		}
+		current := stack[len(stack)-1]
+		current.Blocks = append(current.Blocks, block)
+	}
+
+	return jsonChapter{
+		Number:   chapter.Number,
+		ID:       chapterID(chapter),
+		URL:      chapter.URL,
+		Title:    chapter.Title,
+		Blocks:   root.Blocks,
+		Sections: root.Sections,
+	}, nil
+}
+
+func (j *JSONConverter) block(n *html.Node, chapter downloader.Chapter, chapters []downloader.Chapter, images *jsonImageIndex) (jsonBlock, error) {
+	block := jsonBlock{
+		Type: blockType(n),
+		Text: normalizeSpace(extractText(n)),
+	}
+
+	for _, img := range findAllNodes(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	}) {
+		src := getAttr(img, "src")
+		if src == "" {
+			continue
+		}
+		if !isBookImage(src) {
+			block.Images = append(block.Images, jsonImageRef{URL: src, Alt: getAttr(img, "alt")})
+			continue
+		}
+
+		data, mediaType, err := bookImages(chapter.Assets).data(src)
+		if err != nil {
+			return jsonBlock{}, err
+		}
+		hash := images.add(data, mediaType)
+		block.Images = append(block.Images, jsonImageRef{Hash: hash, Alt: getAttr(img, "alt")})
+		setAttr(img, "src", hash)
+	}
+
+	for _, a := range findAllNodes(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "a" && getAttr(n, "href") != ""
+	}) {
+		href := getAttr(a, "href")
+		target, fragment, external := resolveLink(href, chapter, chapters)
+		if target == nil && !external {
+			// The chapter title's link back to the contents
+			unwrapNode(a)
+			continue
+		}
+
+		link := jsonLink{
+			Text:     normalizeSpace(extractText(a)),
+			Href:     href,
+			External: external,
+		}
+		if target != nil {
+			link.Target = &jsonLinkTarget{Chapter: target.Number, ChapterID: chapterID(*target), Fragment: fragment}
+			setAttr(a, "href", link.Target.href())
+		}
+		block.Links = append(block.Links, link)
+	}
+
+	sanitizeNode(n)
+
+	var buf strings.Builder
+	if err := html.Render(&buf, n); err != nil {
+		return jsonBlock{}, fmt.Errorf("failed to render block: %w", err)
+	}
+	block.HTML = buf.String()
+
+	return block, nil
+}
+
+// jsonImageIndex records each distinct image once, in order of first use
+type jsonImageIndex struct {
+	byHash map[string]*jsonImage
+	list   []*jsonImage
+}
+
+func newJSONImageIndex() *jsonImageIndex {
+	return &jsonImageIndex{byHash: make(map[string]*jsonImage)}
+}
+
+// add records an image and returns its content hash
+func (x *jsonImageIndex) add(data []byte, mediaType string) string {
+	sum := sha256.Sum256(data)
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+	if _, ok := x.byHash[hash]; !ok {
+		x.byHash[hash] = &jsonImage{Hash: hash, MediaType: mediaType, Size: len(data)}
+		x.list = append(x.list, x.byHash[hash])
+	}
+	return hash
+}
+
+// href returns the link target as the block HTML writes it: the chapter
+// id, followed by the fragment if there is one
+func (t *jsonLinkTarget) href() string {
+	if t.Fragment == "" {
+		return t.ChapterID
+	}
+	return t.ChapterID + "#" + t.Fragment
+}
+
+func blockType(n *html.Node) string {
+	if n.Type != html.ElementNode {
+		return "paragraph"
+	}
+
+	switch n.Data {
+	case "p":
+		return "paragraph"
+	case "ul", "ol":
+		return "list"
+	case "blockquote":
+		return "blockquote"
+	case "figure", "img":
+		return "figure"
+	case "pre":
+		return "code"
+	case "table":
+		return "table"
+	case "hr":
+		return "rule"
+	}
+	return "other"
+}
+
+// sanitizedAttrs lists the attributes kept on each element by sanitizeNode
+var sanitizedAttrs = map[string][]string{
+	"a":   {"href", "title"},
+	"img": {"src", "alt", "title"},
+	"ol":  {"start"},
+}
+
+// sanitizeNode strips scripts, styling and presentation attributes, leaving
+// markup that downstream tools can render safely
+func sanitizeNode(n *html.Node) {
+	for _, el := range findAllNodes(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode &&
+			(n.Data == "script" || n.Data == "style" || n.Data == "template" || n.Data == "noscript")
+	}) {
+		el.Parent.RemoveChild(el)
+	}
+
+	for _, el := range findAllNodes(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode
+	}) {
+		var attrs []html.Attribute
+		for _, attr := range el.Attr {
+			if attr.Key == "href" && strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "javascript:") {
+				continue
+			}
+			if attr.Key == "id" {
+				attrs = append(attrs, attr)
+				continue
+			}
+			for _, allowed := range sanitizedAttrs[el.Data] {
+				if attr.Key == allowed {
+					attrs = append(attrs, attr)
+					break
+				}
+			}
+		}
+		el.Attr = attrs
+	}
+}
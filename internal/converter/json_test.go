@@ -0,0 +1,150 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestJSONConverter_Convert verifies the exported book model: sections are
+// nested by heading level, images are hashed and book links are resolved
+func TestJSONConverter_Convert(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book")
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png-data"))
+
+	chapters := []downloader.Chapter{
+		{
+			Title: "Chapter 1",
+			Content: `<div class="content">
+                        <h1>Chapter 1</h1>
+                        <p>Intro</p>
+                        <h2 id="a">Section A</h2>
+                        <p>See <a href="/shapeup/1.2#b">B</a> and <a href="https://example.com">elsewhere</a>.</p>
+                        <h3 id="a1">Section A.1</h3>
+                        <figure><img src="` + image + `" alt="Sketch" class="big"></figure>
+                        <h2 id="c">Section C</h2>
+                        <p><img src="` + image + `" alt="Again"></p>
+                        <p><img src="https://example.com/chart.png" alt="Chart"> and <a href="#a">back up</a></p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+		{
+			Title:   "Chapter 2",
+			Content: "<div class='content'><h2 id='b'>Section B</h2><p>Text</p></div>",
+			URL:     "https://basecamp.com/shapeup/1.2",
+			Number:  2,
+		},
+	}
+
+	conv := NewJSONConverter(testFile)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testFile + ".json")
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	var book jsonBook
+	if err := json.Unmarshal(data, &book); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+
+	if book.SchemaVersion != jsonSchemaVersion {
+		t.Errorf("schemaVersion = %d, want %d", book.SchemaVersion, jsonSchemaVersion)
+	}
+	if len(book.Parts) != 1 || len(book.Parts[0].Chapters) != 2 {
+		t.Fatalf("unexpected part structure: %+v", book.Parts)
+	}
+
+	ch := book.Parts[0].Chapters[0]
+	if ch.ID != "1.1" || len(ch.Blocks) != 1 || ch.Blocks[0].Text != "Intro" {
+		t.Errorf("unexpected chapter preamble: %+v", ch)
+	}
+	if len(ch.Sections) != 2 {
+		t.Fatalf("got %d top-level sections, want 2", len(ch.Sections))
+	}
+
+	a := ch.Sections[0]
+	if a.ID != "a" || len(a.Sections) != 1 || a.Sections[0].ID != "a1" {
+		t.Errorf("section A not nested correctly: %+v", a)
+	}
+
+	links := a.Blocks[0].Links
+	if len(links) != 2 {
+		t.Fatalf("got %d links, want 2", len(links))
+	}
+	if links[0].Target == nil || links[0].Target.Chapter != 2 || links[0].Target.Fragment != "b" {
+		t.Errorf("internal link not resolved: %+v", links[0])
+	}
+	if !links[1].External || links[1].Target != nil {
+		t.Errorf("external link misclassified: %+v", links[1])
+	}
+
+	// The same image used twice is listed once
+	if len(book.Images) != 1 || book.Images[0].MediaType != "image/png" {
+		t.Errorf("unexpected image list: %+v", book.Images)
+	}
+
+	if !strings.Contains(a.Blocks[0].HTML, `href="1.2#b"`) {
+		t.Errorf("book link not qualified with its chapter: %s", a.Blocks[0].HTML)
+	}
+
+	figure := a.Sections[0].Blocks[0]
+	if figure.Type != "figure" || figure.Images[0].Hash != book.Images[0].Hash {
+		t.Errorf("figure block does not reference image hash: %+v", figure)
+	}
+	if strings.Contains(figure.HTML, "class=") || strings.Contains(figure.HTML, "data:") {
+		t.Errorf("figure HTML not sanitized: %s", figure.HTML)
+	}
+
+	// Linked images are referenced by URL, and links within the chapter
+	// are qualified too
+	linked := ch.Sections[1].Blocks[1]
+	if ref := linked.Images[0]; ref.URL != "https://example.com/chart.png" || ref.Hash != "" {
+		t.Errorf("linked image reference = %+v", ref)
+	}
+	if target := linked.Links[0].Target; target == nil || target.ChapterID != "1.1" || target.Fragment != "a" {
+		t.Errorf("same-chapter link not resolved: %+v", linked.Links[0])
+	}
+	if !strings.Contains(linked.HTML, `href="1.1#a"`) || !strings.Contains(linked.HTML, `src="https://example.com/chart.png"`) {
+		t.Errorf("unexpected linked image block HTML: %s", linked.HTML)
+	}
+}
+
+// TestJSONSchema verifies the published schema is valid JSON and requires
+// the fields the converter writes
+func TestJSONSchema(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("schema", "book.v1.schema.json"))
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+
+	var schema struct {
+		ID       string   `json:"$id"`
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	if schema.ID != jsonSchemaURL {
+		t.Errorf("schema $id = %s, want %s", schema.ID, jsonSchemaURL)
+	}
+
+	encoded, _ := json.Marshal(jsonBook{})
+	var fields map[string]interface{}
+	_ = json.Unmarshal(encoded, &fields)
+	for _, required := range schema.Required {
+		if _, ok := fields[required]; !ok {
+			t.Errorf("schema requires %s, which the converter does not write", required)
+		}
+	}
+}
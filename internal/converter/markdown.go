@@ -0,0 +1,225 @@
+package converter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+var repeatedSpaces = regexp.MustCompile(` {2,}`)
+
+// markdownEscaper escapes characters that would otherwise start Markdown
+// formatting inside running text
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+	"<", `\<`,
+)
+
+// markdownByline returns the book's subtitle and author as a line of
+// Markdown for index pages
+func markdownByline(meta downloader.Metadata) string {
+	byline := "by " + markdownEscaper.Replace(meta.Author)
+	if meta.Subtitle != "" {
+		byline = "*" + markdownEscaper.Replace(meta.Subtitle) + "* " + byline
+	}
+	return byline + "\n"
+}
+
+// markdownRenderer renders chapter HTML as CommonMark. Links and images are
+// delegated to the output format, since each resolves them differently.
+type markdownRenderer struct {
+	// link returns the Markdown for a link with the given href and
+	// already-rendered text
+	link func(href, text string) string
+
+	// image returns the Markdown for an <img> element
+	image func(n *html.Node) (string, error)
+
+	// headingIDs appends {#id} attributes to headings that have an id, so
+	// fragment links keep working in renderers that support them
+	headingIDs bool
+
+	// err holds the first error returned by image
+	err error
+}
+
+// blocks renders the children of n as Markdown blocks
+func (m *markdownRenderer) blocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		if text := tidyInline(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (isBlockElement(c.Data) || c.Data == "img") {
+			flush()
+			if block := m.block(c); block != "" {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		inline.WriteString(m.inline(c))
+	}
+	flush()
+
+	return blocks
+}
+
+// block renders a single block-level node. Text nodes are treated as
+// paragraphs.
+func (m *markdownRenderer) block(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return tidyInline(m.inline(n))
+	}
+
+	switch n.Data {
+	case "p":
+		return tidyInline(m.inlineChildren(n))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		heading := strings.Repeat("#", headingLevel(n)) + " " + tidyInline(m.inlineChildren(n))
+		if id := getAttr(n, "id"); m.headingIDs && id != "" {
+			heading += " {#" + id + "}"
+		}
+		return heading
+	case "ul", "ol":
+		return m.list(n)
+	case "blockquote":
+		return prefixLines(strings.Join(m.blocks(n), "\n\n"), "> ")
+	case "pre":
+		return "```\n" + strings.TrimRight(extractText(n), "\n") + "\n```"
+	case "hr":
+		return "---"
+	case "img":
+		return m.renderImage(n)
+	case "figcaption":
+		if text := tidyInline(m.inlineChildren(n)); text != "" {
+			return emphasize("*", text)
+		}
+		return ""
+	default:
+		return strings.Join(m.blocks(n), "\n\n")
+	}
+}
+
+func (m *markdownRenderer) list(n *html.Node) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		content := strings.Join(m.blocks(c), "\n\n")
+		lines := strings.Split(content, "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = strings.Repeat(" ", len(marker)) + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+func (m *markdownRenderer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(m.inline(c))
+	}
+	return text.String()
+}
+
+func (m *markdownRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "em", "i":
+		return emphasize("*", m.inlineChildren(n))
+	case "strong", "b":
+		return emphasize("**", m.inlineChildren(n))
+	case "code":
+		return "`" + extractText(n) + "`"
+	case "br":
+		return "\\\n"
+	case "img":
+		return m.renderImage(n)
+	case "a":
+		text := m.inlineChildren(n)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return text
+		}
+		lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+		trail := text[len(strings.TrimRight(text, " ")):]
+		return lead + m.link(getAttr(n, "href"), trimmed) + trail
+	case "script", "style", "template":
+		return ""
+	default:
+		return m.inlineChildren(n)
+	}
+}
+
+func (m *markdownRenderer) renderImage(n *html.Node) string {
+	result, err := m.image(n)
+	if err != nil && m.err == nil {
+		m.err = err
+	}
+	return result
+}
+
+// tidyInline collapses the repeated spaces left where inline elements meet
+// and trims the result
+func tidyInline(s string) string {
+	return strings.TrimSpace(repeatedSpaces.ReplaceAllString(s, " "))
+}
+
+// emphasize wraps text in an emphasis marker, moving surrounding spaces
+// outside the markers so the emphasis is recognized
+func emphasize(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	trail := text[len(strings.TrimRight(text, " ")):]
+	return lead + marker + trimmed + marker + trail
+}
+
+func prefixLines(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// TestMarkdownRenderer_Blocks verifies the mapping of HTML elements to
+// Markdown
+func TestMarkdownRenderer_Blocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "heading",
+			input: "<h2>Set <em>boundaries</em></h2>",
+			want:  "## Set *boundaries*",
+		},
+		{
+			name:  "paragraph with emphasis",
+			input: "<p>Fixed time,<strong> variable scope </strong>now</p>",
+			want:  "Fixed time, **variable scope** now",
+		},
+		{
+			name:  "escaping",
+			input: "<p>a * b [c]</p>",
+			want:  `a \* b \[c\]`,
+		},
+		{
+			name:  "ordered list",
+			input: "<ol><li>One</li><li><p>Two</p><p>More</p></li></ol>",
+			want:  "1. One\n2. Two\n\n   More",
+		},
+		{
+			name:  "blockquote",
+			input: "<blockquote><p>Quote</p><p>Again</p></blockquote>",
+			want:  "> Quote\n>\n> Again",
+		},
+		{
+			name:  "link",
+			input: `<p>See <a href="https://basecamp.com"> Basecamp </a>.</p>`,
+			want:  "See [Basecamp](https://basecamp.com) .",
+		},
+		{
+			name:  "image",
+			input: `<figure><img src="a.png" alt="Sketch"><figcaption>Caption</figcaption></figure>`,
+			want:  "![Sketch](a.png)\n\n*Caption*",
+		},
+	}
+
+	r := &markdownRenderer{
+		link: func(href, text string) string {
+			return "[" + text + "](" + href + ")"
+		},
+		image: func(n *html.Node) (string, error) {
+			return "![" + getAttr(n, "alt") + "](" + getAttr(n, "src") + ")", nil
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := parseBody(tt.input)
+			if err != nil {
+				t.Fatalf("parseBody() error = %v", err)
+			}
+
+			got := strings.Join(r.blocks(body), "\n\n")
+			if got != tt.want {
+				t.Errorf("blocks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// mhtmlBaseURL is the base for the Content-Location of every part. The
+// archive is self-contained, so the host never needs to resolve.
+const mhtmlBaseURL = "http://shape-up.local/"
+
+type MHTMLConverter struct {
+	OutputPath string
+	// PruneCSS drops the stylesheet rules that match nothing in the book
+	PruneCSS bool
+	// Log receives notes on the conversion, such as how much pruning
+	// shrank the CSS
+	Log io.Writer
+	baseConverter
+}
+
+func NewMHTMLConverter(outputPath string) *MHTMLConverter {
+	if !strings.HasSuffix(outputPath, ".mhtml") {
+		outputPath += ".mhtml"
+	}
+	return &MHTMLConverter{
+		OutputPath: outputPath,
+		PruneCSS:   true,
+		Log:        os.Stdout,
+	}
+}
+
+// mhtmlResource is a part of the archive other than the root document
+type mhtmlResource struct {
+	Location  string
+	MediaType string
+	Data      []byte
+}
+
+func (m *MHTMLConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	if dir := filepath.Dir(m.OutputPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	// Render the same page as the HTML output, but link the stylesheet so
+	// it can be stored as its own part
+	htmlConv := &HTMLConverter{baseConverter: m.baseConverter}
+	page, err := htmlConv.renderBook(chapters, HTMLTemplateData{StylesheetHref: mhtmlBaseURL + "shape-up.css"}, nil)
+	if err != nil {
+		return err
+	}
+
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return fmt.Errorf("failed to parse rendered book: %w", err)
+	}
+
+	// Pruning comes first, so only the fonts and images the remaining
+	// rules use are stored
+	css = m.device().StyleSheet(css)
+	if m.PruneCSS {
+		var pruning cssPruning
+		css = pruning.prune(css, doc)
+		pruning.pruneStyleElements(doc)
+		pruning.report(m.Log)
+	}
+
+	// The stylesheet's fonts and images are stored as parts like the page's
+	images := newBookImages(chapters)
+	resources := &mhtmlResources{seen: make(map[string]bool)}
+	css, err = images.rewriteCSS(css, func(src string) (string, error) {
+		return resources.add(images, src)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store stylesheet assets: %w", err)
+	}
+
+	if err := m.extractImages(doc, images, resources); err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	if err := html.Render(&buf, doc); err != nil {
+		return fmt.Errorf("failed to render book: %w", err)
+	}
+
+	parts := append([]mhtmlResource{{
+		Location:  mhtmlBaseURL + "shape-up.css",
+		MediaType: "text/css",
+		Data:      []byte(css),
+	}}, resources.list...)
+
+	file, err := os.Create(m.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+
+	if err := m.writeArchive(file, buf.String(), parts); err != nil {
+		return fmt.Errorf("failed to write MHTML archive: %w", err)
+	}
+	return file.Close()
+}
+
+// extractImages replaces the book's images with Content-Location references
+// to resources
+func (m *MHTMLConverter) extractImages(doc *html.Node, images bookImages, resources *mhtmlResources) error {
+	for _, img := range findAllNodes(doc, isBookImageNode) {
+		location, err := resources.add(images, getAttr(img, "src"))
+		if err != nil {
+			return fmt.Errorf("failed to extract image: %w", err)
+		}
+		setAttr(img, "src", location)
+	}
+	return nil
+}
+
+// mhtmlResources collects the archive's parts, keeping each distinct file
+// once
+type mhtmlResources struct {
+	list []mhtmlResource
+	seen map[string]bool
+}
+
+// add stores a book image or font and returns its Content-Location
+func (r *mhtmlResources) add(images bookImages, src string) (string, error) {
+	data, mediaType, err := images.data(src)
+	if err != nil {
+		return "", err
+	}
+
+	dir := "images/"
+	if strings.HasPrefix(mediaType, "font/") {
+		dir = "fonts/"
+	}
+	location := mhtmlBaseURL + dir + downloader.NewAsset(data, mediaType).Name
+	if !r.seen[location] {
+		r.seen[location] = true
+		r.list = append(r.list, mhtmlResource{
+			Location:  location,
+			MediaType: mediaType,
+			Data:      data,
+		})
+	}
+	return location, nil
+}
+
+// writeArchive writes a multipart/related message (RFC 2557) with the page
+// as its root part
+func (m *MHTMLConverter) writeArchive(w io.Writer, page string, resources []mhtmlResource) error {
+	mw := multipart.NewWriter(w)
+
+	headers := [][2]string{
+		{"From", "<Saved by shape-up-downloader>"},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.metadata().Title)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/related", map[string]string{
+			"type":     "text/html",
+			"boundary": mw.Boundary(),
+		})},
+	}
+	for _, header := range headers {
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", header[0], header[1]); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	if err := writeQuotedPrintablePart(mw, "text/html", mhtmlBaseURL+"index.html", page); err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		if strings.HasPrefix(resource.MediaType, "text/") {
+			if err := writeQuotedPrintablePart(mw, resource.MediaType, resource.Location, string(resource.Data)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeBase64Part(mw, resource.MediaType, resource.Location, resource.Data); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func writeQuotedPrintablePart(mw *multipart.Writer, mediaType, location, content string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mediaType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+		"Content-Location":          {location},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := io.WriteString(qp, content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64Part(mw *multipart.Writer, mediaType, location string, data []byte) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mediaType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Location":          {location},
+	})
+	if err != nil {
+		return err
+	}
+
+	// MIME limits encoded lines to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	_, err = part.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestMHTMLConverter_Convert verifies the archive is a multipart/related
+// message with the page, stylesheet and each distinct image as its own part,
+// and the stylesheet's unused rules are pruned
+func TestMHTMLConverter_Convert(t *testing.T) {
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png-data"))
+	chapters := []downloader.Chapter{
+		{
+			Title: "Table of Contents",
+			Content: `<div class="content">
+                        <div class="toc"><a href="/shapeup/1.1">Chapter 1</a></div>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/toc",
+			Number: 0,
+		},
+		{
+			Title: "Chapter 1",
+			Content: `<div class="content"><h1>Test Content</h1>
+                        <p><img src="` + image + `" alt="One"></p>
+                        <p><img src="` + image + `" alt="Two"></p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "book")
+	conv := NewMHTMLConverter(outputPath)
+	conv.Log = io.Discard
+	if err := conv.Convert(chapters, "body { color: black; }\n.sidebar { float: left; }"); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	file, err := os.Open(outputPath + ".mhtml")
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer file.Close()
+
+	msg, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" || params["type"] != "text/html" {
+		t.Fatalf("unexpected Content-Type %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+
+	parts := make(map[string]string)
+	var locations []string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Failed to read part body: %v", err)
+		}
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			data, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(data), "\r\n", ""))
+			if err != nil {
+				t.Fatalf("Failed to decode part: %v", err)
+			}
+		}
+
+		location := part.Header.Get("Content-Location")
+		locations = append(locations, location)
+		parts[location] = string(data)
+	}
+
+	if len(locations) != 3 || locations[0] != mhtmlBaseURL+"index.html" {
+		t.Fatalf("expected page, stylesheet and one image, got %v", locations)
+	}
+
+	page := parts[mhtmlBaseURL+"index.html"]
+	if strings.Contains(page, "data:image") {
+		t.Error("page still contains embedded images")
+	}
+	if !strings.Contains(page, `<link rel="stylesheet" href="`+mhtmlBaseURL+`shape-up.css"/>`) {
+		t.Errorf("page does not link the stylesheet part:\n%s", page)
+	}
+
+	if got := parts[mhtmlBaseURL+"shape-up.css"]; got != "body { color: black; }" {
+		t.Errorf("stylesheet part = %q", got)
+	}
+
+	imageLocation := locations[2]
+	if !strings.HasPrefix(imageLocation, mhtmlBaseURL+"images/") || !strings.HasSuffix(imageLocation, ".png") {
+		t.Errorf("unexpected image location %q", imageLocation)
+	}
+	if parts[imageLocation] != "png-data" {
+		t.Errorf("image part = %q", parts[imageLocation])
+	}
+	if strings.Count(page, `src="`+imageLocation+`"`) != 2 {
+		t.Errorf("both images should reference %s:\n%s", imageLocation, page)
+	}
+}
+
+// TestMHTMLResources verifies fonts and images are stored once each, under
+// their own folders
+func TestMHTMLResources(t *testing.T) {
+	font := downloader.NewAsset([]byte("wOF2 font"), "font/woff2")
+	image := downloader.NewAsset([]byte("png-data"), "image/png")
+	images := bookImages{font.URL(): font, image.URL(): image}
+	resources := &mhtmlResources{seen: make(map[string]bool)}
+
+	for _, tt := range []struct{ src, want string }{
+		{font.URL(), mhtmlBaseURL + "fonts/" + font.Name},
+		{image.URL(), mhtmlBaseURL + "images/" + image.Name},
+		{font.URL(), mhtmlBaseURL + "fonts/" + font.Name},
+	} {
+		location, err := resources.add(images, tt.src)
+		if err != nil {
+			t.Fatalf("add(%s) error = %v", tt.src, err)
+		}
+		if location != tt.want {
+			t.Errorf("add(%s) = %s, want %s", tt.src, location, tt.want)
+		}
+	}
+	if len(resources.list) != 2 {
+		t.Errorf("stored %d resources, want 2", len(resources.list))
+	}
+}
@@ -0,0 +1,292 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+const (
+	obsidianAttachmentsDir = "attachments"
+	obsidianMOCName        = "Shape Up"
+)
+
+// obsidianInvalidNameChars matches characters Obsidian doesn't allow in note
+// names or that break wikilinks
+var obsidianInvalidNameChars = regexp.MustCompile(`[*"\\/<>:|?#^\[\]]`)
+
+type ObsidianConverter struct {
+	OutputDir     string
+	SplitSections bool
+	baseConverter
+}
+
+func NewObsidianConverter(outputDir string, splitSections bool) *ObsidianConverter {
+	return &ObsidianConverter{
+		OutputDir:     outputDir,
+		SplitSections: splitSections,
+	}
+}
+
+// obsidianNote is a chapter, or with SplitSections a top-level section of a
+// chapter, written as a single note
+type obsidianNote struct {
+	Name     string
+	Title    string
+	Chapter  downloader.Chapter
+	Part     string
+	Nodes    []*html.Node
+	Sections []*obsidianNote
+}
+
+// obsidianTarget is where a fragment id ended up in the vault
+type obsidianTarget struct {
+	Note    string
+	Heading string
+}
+
+func (o *ObsidianConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	if err := os.MkdirAll(o.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Lay out every note before rendering, so cross-references can be
+	// resolved to notes and headings anywhere in the vault
+	parts := o.organizeParts(chapters)
+	var notes []*obsidianNote
+	chapterNotes := make(map[string]*obsidianNote)
+	targets := make(map[string]obsidianTarget)
+	// The map of contents takes its name first, so no note overwrites it
+	names := map[string]int{obsidianMOCName: 1}
+
+	for _, part := range parts {
+		for _, chapter := range part.Chapters {
+			note, err := o.layoutChapter(chapter, part.Title, names, targets)
+			if err != nil {
+				return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+			}
+			notes = append(notes, note)
+			chapterNotes[chapterID(chapter)] = note
+		}
+	}
+
+	for _, note := range notes {
+		r := o.renderer(note, chapters, chapterNotes, targets)
+		if err := o.writeNote(note, r); err != nil {
+			return err
+		}
+		for _, section := range note.Sections {
+			if err := o.writeNote(section, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return o.writeMOC(parts, chapterNotes)
+}
+
+// layoutChapter splits a chapter into notes and records the note and
+// heading each fragment id resolves to
+func (o *ObsidianConverter) layoutChapter(chapter downloader.Chapter, part string, names map[string]int, targets map[string]obsidianTarget) (*obsidianNote, error) {
+	processedContent, err := o.processChapterContent(chapter.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &obsidianNote{
+		Name:    uniqueNoteName(chapter.Title, names),
+		Title:   chapter.Title,
+		Chapter: chapter,
+		Part:    part,
+	}
+	current := note
+
+	for _, n := range blockNodes(body) {
+		level := headingLevel(n)
+		if level == 2 && o.SplitSections {
+			heading := normalizeSpace(extractText(n))
+			current = &obsidianNote{
+				Name:    uniqueNoteName(chapter.Title+" - "+heading, names),
+				Title:   heading,
+				Chapter: chapter,
+				Part:    part,
+			}
+			note.Sections = append(note.Sections, current)
+		}
+		current.Nodes = append(current.Nodes, n)
+
+		if level > 0 {
+			if id := getAttr(n, "id"); id != "" {
+				targets[chapterID(chapter)+"#"+id] = obsidianTarget{
+					Note:    current.Name,
+					Heading: wikilinkText(normalizeSpace(extractText(n))),
+				}
+			}
+		}
+	}
+
+	return note, nil
+}
+
+// renderer returns a Markdown renderer that turns book links into wikilinks
+// and stores images in the attachments folder
+func (o *ObsidianConverter) renderer(note *obsidianNote, chapters []downloader.Chapter, chapterNotes map[string]*obsidianNote, targets map[string]obsidianTarget) *markdownRenderer {
+	return &markdownRenderer{
+		link: func(href, text string) string {
+			chapter, fragment, external := resolveLink(href, note.Chapter, chapters)
+			switch {
+			case external:
+				return "[" + text + "](" + href + ")"
+			case chapter == nil:
+				return text
+			}
+
+			id := chapterID(*chapter)
+			if target, ok := targets[id+"#"+fragment]; ok && fragment != "" {
+				return wikilink(target.Note, target.Heading, text)
+			}
+			return wikilink(chapterNotes[id].Name, "", text)
+		},
+		image: func(n *html.Node) (string, error) {
+			target, err := bookImages(note.Chapter.Assets).place(filepath.Join(o.OutputDir, obsidianAttachmentsDir), obsidianAttachmentsDir+"/", getAttr(n, "src"))
+			if err != nil {
+				return "", err
+			}
+			return "![" + markdownEscaper.Replace(getAttr(n, "alt")) + "](" + target + ")", nil
+		},
+	}
+}
+
+func (o *ObsidianConverter) writeNote(note *obsidianNote, r *markdownRenderer) error {
+	var out strings.Builder
+	out.WriteString(frontMatter(note))
+
+	var blocks []string
+	for _, n := range note.Nodes {
+		if block := r.block(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+
+	// Link section notes from their chapter in reading order
+	if len(note.Sections) > 0 {
+		var links []string
+		for _, section := range note.Sections {
+			links = append(links, "- "+wikilink(section.Name, "", ""))
+		}
+		blocks = append(blocks, "## Sections", strings.Join(links, "\n"))
+	}
+
+	if r.err != nil {
+		return fmt.Errorf("failed to save image in %s: %w", note.Name, r.err)
+	}
+
+	out.WriteString(strings.Join(blocks, "\n\n"))
+	out.WriteString("\n")
+
+	path := filepath.Join(o.OutputDir, note.Name+".md")
+	if err := os.WriteFile(path, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write note %s: %w", note.Name, err)
+	}
+	return nil
+}
+
+// writeMOC writes the map of contents note, mirroring the book's TOC
+func (o *ObsidianConverter) writeMOC(parts []Part, chapterNotes map[string]*obsidianNote) error {
+	var out strings.Builder
+	out.WriteString("---\ntags:\n  - moc\n  - shape-up\n---\n\n")
+	meta := o.metadata()
+	out.WriteString("# " + meta.Title + "\n\n")
+	out.WriteString(markdownByline(meta))
+
+	for _, part := range parts {
+		out.WriteString("\n## " + part.Title + "\n\n")
+		for _, chapter := range part.Chapters {
+			note := chapterNotes[chapterID(chapter)]
+			out.WriteString("- " + wikilink(note.Name, "", "") + "\n")
+
+			if len(note.Sections) > 0 {
+				for _, section := range note.Sections {
+					out.WriteString("    - " + wikilink(section.Name, "", section.Title) + "\n")
+				}
+				continue
+			}
+			for _, n := range note.Nodes {
+				if headingLevel(n) != 2 {
+					continue
+				}
+				heading := wikilinkText(normalizeSpace(extractText(n)))
+				out.WriteString("    - " + wikilink(note.Name, heading, heading) + "\n")
+			}
+		}
+	}
+
+	path := filepath.Join(o.OutputDir, obsidianMOCName+".md")
+	if err := os.WriteFile(path, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write map of contents: %w", err)
+	}
+	return nil
+}
+
+// frontMatter returns the YAML front matter for a note
+func frontMatter(note *obsidianNote) string {
+	var out strings.Builder
+	out.WriteString("---\n")
+	out.WriteString("title: " + strconv.Quote(note.Title) + "\n")
+	out.WriteString("chapter: " + strconv.Itoa(note.Chapter.Number) + "\n")
+	out.WriteString("part: " + strconv.Quote(note.Part) + "\n")
+	out.WriteString("source: " + strconv.Quote(note.Chapter.URL) + "\n")
+	out.WriteString("tags:\n  - shape-up\n")
+	out.WriteString("---\n\n")
+	return out.String()
+}
+
+// wikilink returns [[note#heading|alias]], leaving out the parts that are
+// empty or redundant
+func wikilink(note, heading, alias string) string {
+	target := note
+	if heading != "" {
+		target += "#" + heading
+	}
+
+	alias = wikilinkText(alias)
+	if alias == "" || alias == target || alias == note {
+		return "[[" + target + "]]"
+	}
+	return "[[" + target + "|" + alias + "]]"
+}
+
+// wikilinkText strips characters that would end a wikilink early
+func wikilinkText(s string) string {
+	s = strings.NewReplacer(`\`, "", "[", "", "]", "", "|", "-", "#", "").Replace(s)
+	return normalizeSpace(s)
+}
+
+// uniqueNoteName turns a title into a valid note name, numbering repeats
+func uniqueNoteName(title string, names map[string]int) string {
+	name := normalizeSpace(obsidianInvalidNameChars.ReplaceAllString(title, " "))
+	if name == "" {
+		name = "Untitled"
+	}
+
+	names[name]++
+	if names[name] > 1 {
+		name = fmt.Sprintf("%s %d", name, names[name])
+	}
+	return name
+}
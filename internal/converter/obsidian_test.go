@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestObsidianConverter_Convert verifies notes, front matter, wikilinks,
+// attachments and the map of contents
+func TestObsidianConverter_Convert(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "vault")
+
+	conv := NewObsidianConverter(testDir, false)
+	if err := conv.Convert(testChapters(), ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	first := readFile(t, filepath.Join(testDir, "Principles of Shaping.md"))
+	expected := []string{
+		"---\ntitle: \"Principles of Shaping\"\nchapter: 1\npart: \"Contents\"\nsource: \"https://basecamp.com/shapeup/1.1\"",
+		"[[Set Boundaries#Fixed time, variable scope|fixed time]]",
+		"](attachments/",
+	}
+	for _, want := range expected {
+		if !strings.Contains(first, want) {
+			t.Errorf("chapter note missing %q:\n%s", want, first)
+		}
+	}
+
+	second := readFile(t, filepath.Join(testDir, "Set Boundaries.md"))
+	if !strings.Contains(second, "[[Principles of Shaping|the principles]]") {
+		t.Errorf("chapter link not converted to wikilink:\n%s", second)
+	}
+
+	attachments, err := os.ReadDir(filepath.Join(testDir, obsidianAttachmentsDir))
+	if err != nil || len(attachments) != 1 {
+		t.Errorf("expected one attachment, got %v (%v)", attachments, err)
+	}
+
+	moc := readFile(t, filepath.Join(testDir, "Shape Up.md"))
+	expected = []string{
+		"## Contents",
+		"- [[Principles of Shaping]]",
+		"    - [[Set Boundaries#Setting the appetite|Setting the appetite]]",
+	}
+	for _, want := range expected {
+		if !strings.Contains(moc, want) {
+			t.Errorf("map of contents missing %q:\n%s", want, moc)
+		}
+	}
+}
+
+// TestObsidianConverter_SplitSections verifies section notes and links
+// into them
+func TestObsidianConverter_SplitSections(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "vault")
+
+	conv := NewObsidianConverter(testDir, true)
+	if err := conv.Convert(testChapters(), ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	section := readFile(t, filepath.Join(testDir, "Set Boundaries - Setting the appetite.md"))
+	if !strings.Contains(section, "Small batch or big batch.") {
+		t.Errorf("section note missing its content:\n%s", section)
+	}
+
+	chapter := readFile(t, filepath.Join(testDir, "Set Boundaries.md"))
+	if !strings.Contains(chapter, "- [[Set Boundaries - Setting the appetite]]") {
+		t.Errorf("chapter note does not link its sections:\n%s", chapter)
+	}
+
+	first := readFile(t, filepath.Join(testDir, "Principles of Shaping.md"))
+	if !strings.Contains(first, "[[Set Boundaries - Fixed time, variable scope#Fixed time, variable scope|fixed time]]") {
+		t.Errorf("cross-reference does not point at section note:\n%s", first)
+	}
+}
+
+// TestObsidianConverter_MOCName verifies a chapter named like the map of
+// contents gets a note of its own
+func TestObsidianConverter_MOCName(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "vault")
+	chapters := []downloader.Chapter{{
+		Title:   obsidianMOCName,
+		Content: `<div class="content"><p>Foreword</p></div>`,
+		URL:     "https://basecamp.com/shapeup/0.1",
+		Number:  1,
+	}}
+
+	conv := NewObsidianConverter(testDir, false)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	note := readFile(t, filepath.Join(testDir, obsidianMOCName+" 2.md"))
+	if !strings.Contains(note, "Foreword") {
+		t.Errorf("chapter note missing its content:\n%s", note)
+	}
+	moc := readFile(t, filepath.Join(testDir, obsidianMOCName+".md"))
+	if !strings.Contains(moc, "- [["+obsidianMOCName+" 2]]") {
+		t.Errorf("map of contents does not link the chapter:\n%s", moc)
+	}
+}
+
+// TestUniqueNoteName verifies invalid characters are removed and repeated
+// names are numbered
+func TestUniqueNoteName(t *testing.T) {
+	names := make(map[string]int)
+
+	if got := uniqueNoteName("Q&A: What about bugs?", names); got != "Q&A What about bugs" {
+		t.Errorf("uniqueNoteName() = %q", got)
+	}
+	if got := uniqueNoteName("Q&A: What about bugs?", names); got != "Q&A What about bugs 2" {
+		t.Errorf("uniqueNoteName() repeat = %q", got)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,364 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+const orgMasterFile = "shape-up.org"
+
+// orgLinkEscaper keeps link descriptions from closing the link early
+var orgLinkEscaper = strings.NewReplacer("[", "(", "]", ")")
+
+type OrgConverter struct {
+	OutputDir string
+	baseConverter
+}
+
+func NewOrgConverter(outputDir string) *OrgConverter {
+	return &OrgConverter{
+		OutputDir: outputDir,
+	}
+}
+
+func (o *OrgConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	if err := os.MkdirAll(filepath.Join(o.OutputDir, bookChaptersDir), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, chapter := range chapters {
+		if err := o.writeChapter(chapter, chapters); err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+	}
+
+	return o.writeMaster(o.organizeParts(chapters))
+}
+
+func (o *OrgConverter) writeChapter(chapter downloader.Chapter, chapters []downloader.Chapter) error {
+	processedContent, err := o.processChapterContent(chapter.Content)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return err
+	}
+
+	r := &orgRenderer{
+		chapter:  chapter,
+		chapters: chapters,
+		images:   bookImages(chapter.Assets),
+		imageDir: filepath.Join(o.OutputDir, bookImagesDir),
+	}
+
+	// Chapter files start at level 1 so they read well on their own; the
+	// master document includes them one level down
+	blocks := []string{orgHeading(1, chapter.Title, anchorID(chapter, ""))}
+	for _, n := range blockNodes(body) {
+		// The chapter heading is written from the chapter title
+		if headingLevel(n) == 1 {
+			continue
+		}
+		if block := r.block(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to save image: %w", r.err)
+	}
+
+	path := filepath.Join(o.OutputDir, bookChaptersDir, chapterFileName(chapter, ".org"))
+	if err := os.WriteFile(path, []byte(strings.Join(blocks, "\n\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write chapter: %w", err)
+	}
+	return nil
+}
+
+// writeMaster writes the book document, which includes the chapters in TOC
+// order under a top-level heading for each part
+func (o *OrgConverter) writeMaster(parts []Part) error {
+	meta := o.metadata()
+
+	var out strings.Builder
+	out.WriteString("#+TITLE: " + meta.Title + "\n")
+	if meta.Subtitle != "" {
+		out.WriteString("#+SUBTITLE: " + meta.Subtitle + "\n")
+	}
+	out.WriteString("#+AUTHOR: " + meta.Author + "\n")
+	if meta.Date != "" {
+		out.WriteString("#+DATE: " + meta.Date + "\n")
+	}
+	out.WriteString("#+LANGUAGE: " + meta.Language + "\n")
+	out.WriteString("#+OPTIONS: toc:2 num:nil\n")
+
+	for _, part := range parts {
+		out.WriteString("\n* " + part.Title + "\n")
+		for _, chapter := range part.Chapters {
+			out.WriteString("#+INCLUDE: " + strconv.Quote(bookChaptersDir+"/"+chapterFileName(chapter, ".org")) + " :minlevel 2\n")
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(o.OutputDir, orgMasterFile), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write master document: %w", err)
+	}
+	return nil
+}
+
+// orgHeading returns a heading with a CUSTOM_ID property, which is what
+// [[#id]] links resolve against
+func orgHeading(level int, title, id string) string {
+	heading := strings.Repeat("*", level) + " " + title
+	if id != "" {
+		heading += "\n:PROPERTIES:\n:CUSTOM_ID: " + id + "\n:END:"
+	}
+	return heading
+}
+
+// orgRenderer renders a chapter's HTML as Org
+type orgRenderer struct {
+	chapter  downloader.Chapter
+	chapters []downloader.Chapter
+	images   bookImages
+	imageDir string
+
+	// err holds the first error from saving an image
+	err error
+}
+
+// blocks renders the children of n as Org blocks
+func (r *orgRenderer) blocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		if text := orgParagraph(inline.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (isBlockElement(c.Data) || c.Data == "img") {
+			flush()
+			if block := r.block(c); block != "" {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+		inline.WriteString(r.inline(c))
+	}
+	flush()
+
+	return blocks
+}
+
+// block renders a single block-level node. Text nodes are treated as
+// paragraphs.
+func (r *orgRenderer) block(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return orgParagraph(r.inline(n))
+	}
+
+	switch n.Data {
+	case "p":
+		return orgParagraph(r.inlineChildren(n))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		id := getAttr(n, "id")
+		if id != "" {
+			id = anchorID(r.chapter, id)
+		}
+		return orgHeading(headingLevel(n), tidyInline(r.inlineChildren(n)), id)
+	case "ul", "ol":
+		return r.list(n)
+	case "blockquote":
+		return "#+BEGIN_QUOTE\n" + strings.Join(r.blocks(n), "\n\n") + "\n#+END_QUOTE"
+	case "pre":
+		return "#+BEGIN_EXAMPLE\n" + orgEscapeBlock(strings.TrimRight(extractText(n), "\n")) + "\n#+END_EXAMPLE"
+	case "hr":
+		return "-----"
+	case "img":
+		return r.imageBlock(n, "")
+	case "figure":
+		return r.figure(n)
+	default:
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+}
+
+// figure renders an image with its caption as a #+CAPTION keyword
+func (r *orgRenderer) figure(n *html.Node) string {
+	img := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "img"
+	})
+	if img == nil {
+		return strings.Join(r.blocks(n), "\n\n")
+	}
+
+	var caption string
+	if figcaption := findNode(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "figcaption"
+	}); figcaption != nil {
+		caption = tidyInline(r.inlineChildren(figcaption))
+	}
+	return r.imageBlock(img, caption)
+}
+
+func (r *orgRenderer) list(n *html.Node) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		// Item content is indented to line up with the text after the
+		// marker
+		content := strings.Join(r.blocks(c), "\n\n")
+		lines := strings.Split(content, "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = strings.Repeat(" ", len(marker)) + lines[i]
+			}
+		}
+		items = append(items, marker+strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(items, "\n")
+}
+
+func (r *orgRenderer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(r.inline(c))
+	}
+	return text.String()
+}
+
+func (r *orgRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return collapseSpace(n.Data)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "em", "i":
+		return emphasize("/", r.inlineChildren(n))
+	case "strong", "b":
+		return emphasize("*", r.inlineChildren(n))
+	case "code":
+		return "~" + extractText(n) + "~"
+	case "br":
+		return "\\\\\n"
+	case "img":
+		return r.image(n)
+	case "a":
+		text := r.inlineChildren(n)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return text
+		}
+		lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+		trail := text[len(strings.TrimRight(text, " ")):]
+		return lead + r.link(getAttr(n, "href"), trimmed) + trail
+	case "script", "style", "template":
+		return ""
+	default:
+		return r.inlineChildren(n)
+	}
+}
+
+// link returns a [[#id][text]] link for targets within the book and a
+// regular link for everything else
+func (r *orgRenderer) link(href, text string) string {
+	text = orgLinkEscaper.Replace(text)
+
+	chapter, fragment, external := resolveLink(href, r.chapter, r.chapters)
+	switch {
+	case chapter != nil:
+		return "[[#" + anchorID(*chapter, fragment) + "][" + text + "]]"
+	case !external:
+		return text
+	}
+
+	href = strings.NewReplacer("[", "%5B", "]", "%5D").Replace(href)
+	return "[[" + href + "][" + text + "]]"
+}
+
+// image saves an embedded image and returns a file link to it, relative to
+// the chapter file, or a link to a linked image's URL. Org rewrites relative links when the chapter is
+// included from the master document.
+func (r *orgRenderer) image(n *html.Node) string {
+	target, err := r.images.place(r.imageDir, "file:../"+bookImagesDir+"/", getAttr(n, "src"))
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return ""
+	}
+	return "[[" + target + "]]"
+}
+
+// imageBlock returns a standalone image with its caption and alt text
+func (r *orgRenderer) imageBlock(n *html.Node, caption string) string {
+	link := r.image(n)
+	if link == "" {
+		return ""
+	}
+
+	var out strings.Builder
+	if caption != "" {
+		out.WriteString("#+CAPTION: " + caption + "\n")
+	}
+	if alt := normalizeSpace(getAttr(n, "alt")); alt != "" {
+		out.WriteString("#+ATTR_HTML: :alt " + alt + "\n")
+	}
+	out.WriteString(link)
+	return out.String()
+}
+
+// orgParagraph tidies a paragraph and keeps its first line from being read
+// as a heading or keyword
+func orgParagraph(text string) string {
+	text = tidyInline(text)
+	if strings.HasPrefix(text, "*") || strings.HasPrefix(text, "#") {
+		text = "\u200b" + text
+	}
+	return text
+}
+
+// orgEscapeBlock escapes lines inside a block that Org would otherwise read
+// as headings or keywords
+func orgEscapeBlock(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "*") || strings.HasPrefix(line, "#+") {
+			lines[i] = "," + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
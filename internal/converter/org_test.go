@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOrgConverter_Convert verifies chapter files, CUSTOM_ID links and the
+// master document
+func TestOrgConverter_Convert(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "book")
+
+	conv := NewOrgConverter(testDir)
+	if err := conv.Convert(testChapters(), ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	files := map[string][]string{
+		"shape-up.org": {
+			"#+TITLE: Shape Up",
+			"* Contents\n" +
+				`#+INCLUDE: "chapters/01-principles-of-shaping.org" :minlevel 2` + "\n" +
+				`#+INCLUDE: "chapters/02-set-boundaries.org" :minlevel 2`,
+		},
+		"chapters/01-principles-of-shaping.org": {
+			"* Principles of Shaping\n:PROPERTIES:\n:CUSTOM_ID: ch_1.1\n:END:",
+			"[[#ch_1.2_fixed-time][fixed time]] and /appetite/.",
+			"- Rough\n- Solved\n\n  1. Bounded",
+			"#+BEGIN_QUOTE\nQuote\n#+END_QUOTE",
+			"#+CAPTION: A sketch\n#+ATTR_HTML: :alt Sketch\n[[file:../images/",
+			"[[https://basecamp.com][Basecamp]]",
+		},
+		"chapters/02-set-boundaries.org": {
+			"** Fixed time, variable scope\n:PROPERTIES:\n:CUSTOM_ID: ch_1.2_fixed-time\n:END:",
+			"[[#ch_1.2_fixed-time][the top]]",
+		},
+	}
+
+	for file, expected := range files {
+		content := readFile(t, filepath.Join(testDir, filepath.FromSlash(file)))
+		for _, want := range expected {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s missing %q:\n%s", file, want, content)
+			}
+		}
+	}
+
+	images, err := os.ReadDir(filepath.Join(testDir, bookImagesDir))
+	if err != nil || len(images) != 1 {
+		t.Errorf("expected one image, got %v (%v)", images, err)
+	}
+}
+
+// TestOrgParagraph verifies paragraphs can't turn into headings or keywords
+func TestOrgParagraph(t *testing.T) {
+	tests := map[string]string{
+		"  plain  text ":  "plain text",
+		"* not a heading": "\u200b* not a heading",
+		"#+TITLE: no":     "\u200b#+TITLE: no",
+	}
+
+	for input, want := range tests {
+		if got := orgParagraph(input); got != want {
+			t.Errorf("orgParagraph(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// pandocAPIVersion is the pandoc-types version the generated AST targets
+var pandocAPIVersion = []int{1, 23, 1}
+
+// pandocDocument is the top level of Pandoc's JSON AST
+type pandocDocument struct {
+	APIVersion []int                 `json:"pandoc-api-version"`
+	Meta       map[string]pandocNode `json:"meta"`
+	Blocks     []pandocNode          `json:"blocks"`
+}
+
+// pandocNode is a tagged AST element, e.g. {"t":"Str","c":"Shape"}
+type pandocNode struct {
+	T string      `json:"t"`
+	C interface{} `json:"c,omitempty"`
+}
+
+type PandocConverter struct {
+	OutputPath string
+	baseConverter
+}
+
+func NewPandocConverter(outputPath string) *PandocConverter {
+	if !strings.HasSuffix(outputPath, ".json") {
+		outputPath = outputPath + ".json"
+	}
+
+	return &PandocConverter{
+		OutputPath: outputPath,
+	}
+}
+
+func (p *PandocConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	meta := p.metadata()
+	doc := pandocDocument{
+		APIVersion: pandocAPIVersion,
+		Meta: map[string]pandocNode{
+			"title":  metaInlines(meta.Title),
+			"author": {T: "MetaList", C: []pandocNode{metaInlines(meta.Author)}},
+			"lang":   {T: "MetaString", C: meta.Language},
+		},
+		Blocks: []pandocNode{},
+	}
+	optional := map[string]string{
+		"subtitle":    meta.Subtitle,
+		"description": meta.Description,
+		"publisher":   meta.Publisher,
+		"date":        meta.Date,
+		"rights":      meta.Copyright,
+		"isbn":        meta.ISBN,
+	}
+	for key, value := range optional {
+		if value != "" {
+			doc.Meta[key] = metaInlines(value)
+		}
+	}
+
+	for _, chapter := range chapters {
+		processedContent, err := p.processChapterContent(chapter.Content)
+		if err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+
+		body, err := parseBody(processedContent)
+		if err != nil {
+			return fmt.Errorf("failed to parse chapter %s: %w", chapter.Title, err)
+		}
+		p.processAnchorLinks(body)
+		// The AST carries its images with it
+		if err := bookImages(chapter.Assets).embed(body); err != nil {
+			return fmt.Errorf("failed to embed images in chapter %s: %w", chapter.Title, err)
+		}
+
+		// Wrap each chapter in a Div so internal links can target it
+		doc.Blocks = append(doc.Blocks, pandocNode{
+			T: "Div",
+			C: []interface{}{
+				pandocAttr(chapterID(chapter), "chapter"),
+				p.blocks(body),
+			},
+		})
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode pandoc AST: %w", err)
+	}
+
+	if err := os.WriteFile(p.OutputPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// blocks converts the children of n into Pandoc block elements. Runs of
+// inline content between block elements are gathered into paragraphs.
+func (p *PandocConverter) blocks(n *html.Node) []pandocNode {
+	return p.blocksWith(n, "Para")
+}
+
+func (p *PandocConverter) blocksWith(n *html.Node, inlineBlock string) []pandocNode {
+	result := []pandocNode{}
+	var pending []pandocNode
+
+	flush := func() {
+		if inlines := trimInlines(pending); len(inlines) > 0 {
+			result = append(result, pandocNode{T: inlineBlock, C: inlines})
+		}
+		pending = nil
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlockElement(c.Data) {
+			flush()
+			result = append(result, p.block(c)...)
+			continue
+		}
+		pending = append(pending, p.inlines(c)...)
+	}
+	flush()
+
+	return result
+}
+
+// block converts a single block-level element
+func (p *PandocConverter) block(n *html.Node) []pandocNode {
+	switch n.Data {
+	case "p":
+		if inlines := trimInlines(p.inlineChildren(n)); len(inlines) > 0 {
+			return []pandocNode{{T: "Para", C: inlines}}
+		}
+		return nil
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		return []pandocNode{{
+			T: "Header",
+			C: []interface{}{level, pandocAttr(getAttr(n, "id")), trimInlines(p.inlineChildren(n))},
+		}}
+	case "ul":
+		return []pandocNode{{T: "BulletList", C: p.listItems(n)}}
+	case "ol":
+		start := 1
+		if s, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+			start = s
+		}
+		listAttrs := []interface{}{start, pandocNode{T: "Decimal"}, pandocNode{T: "Period"}}
+		return []pandocNode{{T: "OrderedList", C: []interface{}{listAttrs, p.listItems(n)}}}
+	case "blockquote":
+		return []pandocNode{{T: "BlockQuote", C: p.blocks(n)}}
+	case "pre":
+		return []pandocNode{{T: "CodeBlock", C: []interface{}{pandocAttr(""), extractText(n)}}}
+	case "hr":
+		return []pandocNode{{T: "HorizontalRule"}}
+	case "figcaption":
+		if inlines := trimInlines(p.inlineChildren(n)); len(inlines) > 0 {
+			return []pandocNode{{T: "Para", C: []pandocNode{{T: "Emph", C: inlines}}}}
+		}
+		return nil
+	default:
+		// Structural containers (div, section, figure, ...) are flattened,
+		// keeping their ids reachable as empty Divs where present
+		blocks := p.blocks(n)
+		if id := getAttr(n, "id"); id != "" {
+			return []pandocNode{{T: "Div", C: []interface{}{pandocAttr(id), blocks}}}
+		}
+		return blocks
+	}
+}
+
+func (p *PandocConverter) listItems(n *html.Node) [][]pandocNode {
+	items := [][]pandocNode{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "li" {
+			items = append(items, p.blocksWith(c, "Plain"))
+		}
+	}
+	return items
+}
+
+func (p *PandocConverter) inlineChildren(n *html.Node) []pandocNode {
+	result := []pandocNode{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		result = append(result, p.inlines(c)...)
+	}
+	return result
+}
+
+// inlines converts a node into Pandoc inline elements
+func (p *PandocConverter) inlines(n *html.Node) []pandocNode {
+	switch n.Type {
+	case html.TextNode:
+		return textInlines(n.Data)
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	switch n.Data {
+	case "em", "i":
+		return []pandocNode{{T: "Emph", C: p.inlineChildren(n)}}
+	case "strong", "b":
+		return []pandocNode{{T: "Strong", C: p.inlineChildren(n)}}
+	case "sup":
+		return []pandocNode{{T: "Superscript", C: p.inlineChildren(n)}}
+	case "sub":
+		return []pandocNode{{T: "Subscript", C: p.inlineChildren(n)}}
+	case "code":
+		return []pandocNode{{T: "Code", C: []interface{}{pandocAttr(""), extractText(n)}}}
+	case "br":
+		return []pandocNode{{T: "LineBreak"}}
+	case "a":
+		href := getAttr(n, "href")
+		// The chapter title links back to the web TOC, which has no
+		// counterpart in the AST
+		if href == "" || href == "#toc" {
+			return p.inlineChildren(n)
+		}
+		return []pandocNode{{
+			T: "Link",
+			C: []interface{}{pandocAttr(""), p.inlineChildren(n), []string{href, getAttr(n, "title")}},
+		}}
+	case "img":
+		return []pandocNode{{
+			T: "Image",
+			C: []interface{}{pandocAttr(""), textInlines(getAttr(n, "alt")), []string{getAttr(n, "src"), getAttr(n, "title")}},
+		}}
+	case "script", "style", "template":
+		return nil
+	default:
+		return p.inlineChildren(n)
+	}
+}
+
+// textInlines splits text into Str and Space elements
+func textInlines(text string) []pandocNode {
+	result := []pandocNode{}
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		if text != "" {
+			result = append(result, pandocNode{T: "Space"})
+		}
+		return result
+	}
+
+	if strings.TrimLeft(text, " \t\r\n") != text {
+		result = append(result, pandocNode{T: "Space"})
+	}
+	for i, word := range words {
+		if i > 0 {
+			result = append(result, pandocNode{T: "Space"})
+		}
+		result = append(result, pandocNode{T: "Str", C: word})
+	}
+	if strings.TrimRight(text, " \t\r\n") != text {
+		result = append(result, pandocNode{T: "Space"})
+	}
+
+	return result
+}
+
+// trimInlines collapses repeated spaces and drops leading and trailing ones
+func trimInlines(inlines []pandocNode) []pandocNode {
+	result := []pandocNode{}
+	for _, inline := range inlines {
+		last := ""
+		if len(result) > 0 {
+			last = result[len(result)-1].T
+		}
+
+		switch inline.T {
+		case "Space":
+			if last == "" || last == "Space" || last == "LineBreak" {
+				continue
+			}
+		case "LineBreak":
+			if last == "" {
+				continue
+			}
+			if last == "Space" {
+				result = result[:len(result)-1]
+			}
+		}
+		result = append(result, inline)
+	}
+
+	for len(result) > 0 {
+		last := result[len(result)-1].T
+		if last != "Space" && last != "LineBreak" {
+			break
+		}
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+func pandocAttr(id string, classes ...string) []interface{} {
+	if classes == nil {
+		classes = []string{}
+	}
+	return []interface{}{id, classes, [][]string{}}
+}
+
+func metaInlines(text string) pandocNode {
+	return pandocNode{T: "MetaInlines", C: textInlines(text)}
+}
+
+// isBlockElement reports whether an element starts a new Pandoc block
+func isBlockElement(tag string) bool {
+	switch tag {
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "blockquote",
+		"pre", "hr", "div", "section", "article", "figure", "figcaption",
+		"header", "main", "aside", "table":
+		return true
+	}
+	return false
+}
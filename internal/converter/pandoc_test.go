@@ -0,0 +1,139 @@
+package converter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// TestPandocConverter_Convert verifies the generated document carries the
+// API version, book metadata and one Div per chapter
+func TestPandocConverter_Convert(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book")
+
+	chapters := []downloader.Chapter{
+		{
+			Title: "Chapter 1",
+			Content: `<h1 class="intro__title"><a href="/shapeup">Chapter 1</a></h1>
+                      <div class="content">
+                        <p>See <a href="/shapeup/1.2#risks">the risks</a>.</p>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+		{
+			Title:   "Chapter 2",
+			Content: "<div class='content'><h2 id='risks'>Risks</h2></div>",
+			URL:     "https://basecamp.com/shapeup/1.2",
+			Number:  2,
+		},
+	}
+
+	conv := NewPandocConverter(testFile)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testFile + ".json")
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	var doc pandocDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+
+	if len(doc.APIVersion) == 0 || doc.APIVersion[0] != 1 {
+		t.Errorf("unexpected pandoc-api-version: %v", doc.APIVersion)
+	}
+	if _, ok := doc.Meta["title"]; !ok {
+		t.Error("Meta block missing title")
+	}
+	if len(doc.Blocks) != 2 {
+		t.Fatalf("got %d top-level blocks, want one Div per chapter", len(doc.Blocks))
+	}
+
+	output := string(data)
+	expected := []string{
+		`{"t":"Div","c":[["1.1",["chapter"],[]]`,
+		`{"t":"Header","c":[2,["risks",[],[]]`,
+		`["#risks",""]`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("Convert() output missing %s", want)
+		}
+	}
+}
+
+// TestPandocConverter_Blocks verifies the mapping of HTML elements to
+// Pandoc block types
+func TestPandocConverter_Blocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "paragraph",
+			input: "<p>Fixed time, <em>variable</em> scope</p>",
+			want:  `[{"t":"Para","c":[{"t":"Str","c":"Fixed"},{"t":"Space"},{"t":"Str","c":"time,"},{"t":"Space"},{"t":"Emph","c":[{"t":"Str","c":"variable"}]},{"t":"Space"},{"t":"Str","c":"scope"}]}]`,
+		},
+		{
+			name:  "bullet list",
+			input: "<ul><li>One</li><li>Two</li></ul>",
+			want:  `[{"t":"BulletList","c":[[{"t":"Plain","c":[{"t":"Str","c":"One"}]}],[{"t":"Plain","c":[{"t":"Str","c":"Two"}]}]]}]`,
+		},
+		{
+			name:  "blockquote",
+			input: "<blockquote><p>Quote</p></blockquote>",
+			want:  `[{"t":"BlockQuote","c":[{"t":"Para","c":[{"t":"Str","c":"Quote"}]}]}]`,
+		},
+		{
+			name:  "image",
+			input: `<figure><img src="a.png" alt="A sketch"></figure>`,
+			want:  `[{"t":"Para","c":[{"t":"Image","c":[["",[],[]],[{"t":"Str","c":"A"},{"t":"Space"},{"t":"Str","c":"sketch"}],["a.png",""]]}]}]`,
+		},
+	}
+
+	conv := NewPandocConverter("test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := parseBody(tt.input)
+			if err != nil {
+				t.Fatalf("parseBody() error = %v", err)
+			}
+
+			got, err := json.Marshal(conv.blocks(body))
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("blocks() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTrimInlines verifies whitespace normalization of inline runs
+func TestTrimInlines(t *testing.T) {
+	doc, _ := html.Parse(strings.NewReader("<p>  a  <b> b </b>  </p>"))
+	p := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "p"
+	})
+
+	conv := NewPandocConverter("test")
+	inlines := trimInlines(conv.inlineChildren(p))
+
+	got, _ := json.Marshal(inlines)
+	want := `[{"t":"Str","c":"a"},{"t":"Space"},{"t":"Strong","c":[{"t":"Space"},{"t":"Str","c":"b"},{"t":"Space"}]}]`
+	if string(got) != want {
+		t.Errorf("trimInlines() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+// Service worker for the offline book. The converter puts VERSION and FILES
+// above: FILES is the asset manifest, mapping each file's path to a hash of
+// its content, and VERSION is a hash of the whole manifest, so any change
+// to the book installs a new worker.
+const SCOPE = self.registration.scope;
+const CACHE_PREFIX = "shape-up:" + SCOPE + ":";
+const CACHE = CACHE_PREFIX + VERSION;
+
+// revisioned returns the cache key of a file's current content
+function revisioned(path) {
+  return new URL(path + "?rev=" + FILES[path], SCOPE).href;
+}
+
+self.addEventListener("install", (event) => {
+  event.waitUntil((async () => {
+    const cache = await caches.open(CACHE);
+    await Promise.all(Object.keys(FILES).map(async (path) => {
+      const key = revisioned(path);
+      // Files that didn't change are copied from the previous version
+      let response = await caches.match(key);
+      if (!response) {
+        response = await fetch(new URL(path, SCOPE), { cache: "reload" });
+        if (!response.ok) {
+          throw new Error("failed to fetch " + path + ": " + response.status);
+        }
+      }
+      await cache.put(key, response);
+    }));
+    await self.skipWaiting();
+  })());
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil((async () => {
+    for (const name of await caches.keys()) {
+      if (name.startsWith(CACHE_PREFIX) && name !== CACHE) {
+        await caches.delete(name);
+      }
+    }
+    await self.clients.claim();
+  })());
+});
+
+self.addEventListener("fetch", (event) => {
+  if (event.request.method !== "GET") {
+    return;
+  }
+  const url = new URL(event.request.url);
+  const scope = new URL(SCOPE);
+  if (url.origin !== scope.origin || !url.pathname.startsWith(scope.pathname)) {
+    return;
+  }
+  const path = decodeURIComponent(url.pathname.slice(scope.pathname.length)) || "index.html";
+  if (!(path in FILES)) {
+    return;
+  }
+  event.respondWith((async () => {
+    const cache = await caches.open(CACHE);
+    return (await cache.match(revisioned(path))) || fetch(event.request);
+  })());
+});
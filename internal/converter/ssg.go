@@ -0,0 +1,315 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// Supported static site generators
+const (
+	SSGHugo   = "hugo"
+	SSGJekyll = "jekyll"
+	SSGMkDocs = "mkdocs"
+)
+
+// SSGGenerators lists the supported static site generators
+var SSGGenerators = []string{SSGHugo, SSGJekyll, SSGMkDocs}
+
+// ssgBookSection is the directory the book is written to inside the
+// generator's content tree
+const ssgBookSection = "shape-up"
+
+// yamlPlain matches strings that can be written as plain YAML scalars
+var yamlPlain = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ,.()'-]*$`)
+
+type SSGConverter struct {
+	OutputDir string
+	Generator string
+	baseConverter
+}
+
+func NewSSGConverter(outputDir string, generator string) *SSGConverter {
+	return &SSGConverter{
+		OutputDir: outputDir,
+		Generator: strings.ToLower(generator),
+	}
+}
+
+// ssgPage is a chapter's place in the content tree
+type ssgPage struct {
+	Chapter downloader.Chapter
+	Part    string
+	// Dir is the page bundle directory relative to the content root,
+	// e.g. "shape-up/part-1-shaping/principles-of-shaping"
+	Dir    string
+	Weight int
+}
+
+func (s *SSGConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	contentRoot, err := s.contentRoot()
+	if err != nil {
+		return err
+	}
+
+	parts := s.organizeParts(chapters)
+	pages := make(map[string]ssgPage)
+	slugs := make(map[string]int)
+
+	for i, part := range parts {
+		partDir := path.Join(ssgBookSection, uniqueSlug(part.Title, slugs))
+		// MkDocs groups pages through the nav instead of index pages
+		if s.Generator != SSGMkDocs {
+			if err := s.writePartIndex(contentRoot, partDir, part.Title, i+1); err != nil {
+				return err
+			}
+		}
+		for _, chapter := range part.Chapters {
+			pages[chapterID(chapter)] = ssgPage{
+				Chapter: chapter,
+				Part:    part.Title,
+				Dir:     path.Join(partDir, uniqueSlug(chapter.Title, slugs)),
+				Weight:  chapter.Number,
+			}
+		}
+	}
+
+	if err := s.writeBookIndex(contentRoot, parts, pages); err != nil {
+		return err
+	}
+
+	for _, chapter := range chapters {
+		if err := s.writePage(contentRoot, pages[chapterID(chapter)], chapters, pages); err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+	}
+
+	if s.Generator == SSGMkDocs {
+		return s.writeMkDocsConfig(parts, pages)
+	}
+	return nil
+}
+
+// contentRoot returns the directory the generator reads content from
+func (s *SSGConverter) contentRoot() (string, error) {
+	switch s.Generator {
+	case SSGHugo:
+		return filepath.Join(s.OutputDir, "content"), nil
+	case SSGJekyll:
+		return s.OutputDir, nil
+	case SSGMkDocs:
+		return filepath.Join(s.OutputDir, "docs"), nil
+	}
+	return "", fmt.Errorf("unsupported static site generator: %s", s.Generator)
+}
+
+// pageFile returns the file name for a page in its bundle directory. Hugo
+// branch bundles (pages with children) use _index.md.
+func (s *SSGConverter) pageFile(branch bool) string {
+	if branch && s.Generator == SSGHugo {
+		return "_index.md"
+	}
+	return "index.md"
+}
+
+func (s *SSGConverter) writeBookIndex(contentRoot string, parts []Part, pages map[string]ssgPage) error {
+	meta := s.metadata()
+
+	var body strings.Builder
+	body.WriteString(markdownByline(meta))
+	for _, part := range parts {
+		body.WriteString("\n## " + part.Title + "\n\n")
+		for _, chapter := range part.Chapters {
+			page := pages[chapterID(chapter)]
+			body.WriteString("- [" + markdownEscaper.Replace(chapter.Title) + "](" + s.pageLink(ssgBookSection, page, "") + ")\n")
+		}
+	}
+
+	fields := [][2]string{{"title", strconv.Quote(meta.Title)}}
+	switch s.Generator {
+	case SSGHugo:
+		fields = append(fields, [2]string{"weight", "1"})
+	case SSGJekyll:
+		fields = append(fields, [2]string{"nav_order", "1"}, [2]string{"has_children", "true"})
+	}
+
+	return s.writeMarkdown(contentRoot, ssgBookSection, s.pageFile(true), fields, body.String())
+}
+
+func (s *SSGConverter) writePartIndex(contentRoot, dir, title string, weight int) error {
+	fields := [][2]string{{"title", strconv.Quote(title)}}
+	switch s.Generator {
+	case SSGHugo:
+		fields = append(fields, [2]string{"weight", strconv.Itoa(weight)})
+	case SSGJekyll:
+		fields = append(fields,
+			[2]string{"nav_order", strconv.Itoa(weight)},
+			[2]string{"parent", strconv.Quote(s.metadata().Title)},
+			[2]string{"has_children", "true"})
+	}
+
+	return s.writeMarkdown(contentRoot, dir, s.pageFile(true), fields, "")
+}
+
+func (s *SSGConverter) writePage(contentRoot string, page ssgPage, chapters []downloader.Chapter, pages map[string]ssgPage) error {
+	processedContent, err := s.processChapterContent(page.Chapter.Content)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return err
+	}
+
+	r := &markdownRenderer{
+		headingIDs: true,
+		link: func(href, text string) string {
+			chapter, fragment, external := resolveLink(href, page.Chapter, chapters)
+			switch {
+			case external:
+				return "[" + text + "](" + href + ")"
+			case chapter == nil:
+				return text
+			case strings.HasPrefix(href, "#"):
+				// Within the page
+				return "[" + text + "](" + href + ")"
+			}
+			return "[" + text + "](" + s.pageLink(page.Dir, pages[chapterID(*chapter)], fragment) + ")"
+		},
+		image: func(n *html.Node) (string, error) {
+			// Images live next to the page that uses them
+			target, err := bookImages(page.Chapter.Assets).place(filepath.Join(contentRoot, filepath.FromSlash(page.Dir)), "", getAttr(n, "src"))
+			if err != nil {
+				return "", err
+			}
+			return "![" + markdownEscaper.Replace(getAttr(n, "alt")) + "](" + target + ")", nil
+		},
+	}
+
+	var blocks []string
+	for _, n := range blockNodes(body) {
+		// The generator renders the title from front matter
+		if headingLevel(n) == 1 {
+			continue
+		}
+		if block := r.block(n); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to save image: %w", r.err)
+	}
+
+	fields := [][2]string{{"title", strconv.Quote(page.Chapter.Title)}}
+	switch s.Generator {
+	case SSGHugo:
+		fields = append(fields, [2]string{"weight", strconv.Itoa(page.Weight)})
+	case SSGJekyll:
+		fields = append(fields,
+			[2]string{"nav_order", strconv.Itoa(page.Weight)},
+			[2]string{"parent", strconv.Quote(page.Part)},
+			[2]string{"grand_parent", strconv.Quote(s.metadata().Title)})
+	}
+	fields = append(fields, [2]string{"source", strconv.Quote(page.Chapter.URL)})
+
+	return s.writeMarkdown(contentRoot, page.Dir, s.pageFile(false), fields, strings.Join(blocks, "\n\n")+"\n")
+}
+
+// pageLink returns a link from the page bundle in fromDir to a chapter, in
+// the form the generator expects for cross-references
+func (s *SSGConverter) pageLink(fromDir string, to ssgPage, fragment string) string {
+	anchor := ""
+	if fragment != "" {
+		anchor = "#" + fragment
+	}
+
+	switch s.Generator {
+	case SSGHugo:
+		return `{{< relref "/` + to.Dir + anchor + `" >}}`
+	case SSGJekyll:
+		return "{% link " + to.Dir + "/" + s.pageFile(false) + " %}" + anchor
+	}
+
+	// MkDocs resolves relative links to Markdown files
+	rel, err := filepath.Rel(filepath.FromSlash(fromDir), filepath.FromSlash(path.Join(to.Dir, s.pageFile(false))))
+	if err != nil {
+		rel = path.Join(to.Dir, s.pageFile(false))
+	}
+	return filepath.ToSlash(rel) + anchor
+}
+
+// writeMarkdown writes a page with YAML front matter
+func (s *SSGConverter) writeMarkdown(contentRoot, dir, file string, fields [][2]string, body string) error {
+	var out strings.Builder
+	out.WriteString("---\n")
+	for _, field := range fields {
+		out.WriteString(field[0] + ": " + field[1] + "\n")
+	}
+	out.WriteString("---\n")
+	if body != "" {
+		out.WriteString("\n" + body)
+	}
+
+	fullDir := filepath.Join(contentRoot, filepath.FromSlash(dir))
+	if err := os.MkdirAll(fullDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(fullDir, file), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write page: %w", err)
+	}
+	return nil
+}
+
+// yamlString returns s as a YAML scalar, quoted only if it has to be
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// writeMkDocsConfig writes mkdocs.yml with a nav that follows the TOC
+func (s *SSGConverter) writeMkDocsConfig(parts []Part, pages map[string]ssgPage) error {
+	var out strings.Builder
+	meta := s.metadata()
+	out.WriteString("site_name: " + yamlString(meta.Title) + "\n")
+	out.WriteString("site_description: " + yamlString(meta.Description) + "\n")
+	out.WriteString("site_author: " + yamlString(meta.Author) + "\n")
+	out.WriteString("markdown_extensions:\n  - attr_list\n  - toc:\n      permalink: true\n")
+	out.WriteString("nav:\n")
+	out.WriteString("  - " + yamlString(meta.Title) + ": " + ssgBookSection + "/index.md\n")
+	for _, part := range parts {
+		out.WriteString("  - " + strconv.Quote(part.Title) + ":\n")
+		for _, chapter := range part.Chapters {
+			page := pages[chapterID(chapter)]
+			out.WriteString("      - " + strconv.Quote(chapter.Title) + ": " + page.Dir + "/index.md\n")
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(s.OutputDir, "mkdocs.yml"), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write mkdocs.yml: %w", err)
+	}
+	return nil
+}
+
+// uniqueSlug returns a URL slug for title, numbering repeats
+func uniqueSlug(title string, slugs map[string]int) string {
+	slug := slugify(title)
+	slugs[slug]++
+	if slugs[slug] > 1 {
+		slug = fmt.Sprintf("%s-%d", slug, slugs[slug])
+	}
+	return slug
+}
@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSSGConverter_Convert verifies the content tree, front matter and
+// cross-link syntax for each generator
+func TestSSGConverter_Convert(t *testing.T) {
+	tests := []struct {
+		generator string
+		files     map[string][]string
+	}{
+		{
+			generator: SSGHugo,
+			files: map[string][]string{
+				"content/shape-up/_index.md":          {`title: "Shape Up"`},
+				"content/shape-up/contents/_index.md": {`title: "Contents"`, "weight: 1"},
+				"content/shape-up/contents/principles-of-shaping/index.md": {
+					"weight: 1",
+					`[fixed time]({{< relref "/shape-up/contents/set-boundaries#fixed-time" >}})`,
+					"![Sketch](",
+				},
+				"content/shape-up/contents/set-boundaries/index.md": {"## Fixed time, variable scope {#fixed-time}", "[the top](#fixed-time)"},
+			},
+		},
+		{
+			generator: SSGJekyll,
+			files: map[string][]string{
+				"shape-up/index.md":          {"has_children: true"},
+				"shape-up/contents/index.md": {`parent: "Shape Up"`},
+				"shape-up/contents/principles-of-shaping/index.md": {
+					"nav_order: 1",
+					`parent: "Contents"`,
+					"[fixed time]({% link shape-up/contents/set-boundaries/index.md %}#fixed-time)",
+				},
+			},
+		},
+		{
+			generator: SSGMkDocs,
+			files: map[string][]string{
+				"mkdocs.yml": {
+					"site_name: Shape Up",
+					"  - attr_list",
+					`  - "Contents":`,
+					`      - "Set Boundaries": shape-up/contents/set-boundaries/index.md`,
+				},
+				"docs/shape-up/index.md": {"[Principles of Shaping](contents/principles-of-shaping/index.md)"},
+				"docs/shape-up/contents/principles-of-shaping/index.md": {
+					"[fixed time](../set-boundaries/index.md#fixed-time)",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.generator, func(t *testing.T) {
+			testDir := filepath.Join(t.TempDir(), "site")
+
+			conv := NewSSGConverter(testDir, tt.generator)
+			if err := conv.Convert(testChapters(), ""); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			for file, expected := range tt.files {
+				content := readFile(t, filepath.Join(testDir, filepath.FromSlash(file)))
+				for _, want := range expected {
+					if !strings.Contains(content, want) {
+						t.Errorf("%s missing %q:\n%s", file, want, content)
+					}
+				}
+			}
+
+			// Images are bundled with the page that uses them
+			pageDir := filepath.Join(testDir, "shape-up", "contents", "principles-of-shaping")
+			if tt.generator == SSGHugo {
+				pageDir = filepath.Join(testDir, "content", "shape-up", "contents", "principles-of-shaping")
+			} else if tt.generator == SSGMkDocs {
+				pageDir = filepath.Join(testDir, "docs", "shape-up", "contents", "principles-of-shaping")
+			}
+			matches, _ := filepath.Glob(filepath.Join(pageDir, "*.png"))
+			if len(matches) != 1 {
+				t.Errorf("expected one bundled image in %s, found %v", pageDir, matches)
+			}
+		})
+	}
+}
+
+// TestSSGConverter_UnknownGenerator verifies unsupported generators are
+// rejected
+func TestSSGConverter_UnknownGenerator(t *testing.T) {
+	conv := NewSSGConverter(t.TempDir(), "gatsby")
+	if err := conv.Convert(testChapters(), ""); err == nil {
+		t.Error("Convert() expected error for unknown generator")
+	}
+	if _, err := os.Stat(filepath.Join(conv.OutputDir, "content")); err == nil {
+		t.Error("Convert() wrote output for unknown generator")
+	}
+}
+
+// TestSlugify verifies URL slug generation
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Part 1: Shaping":        "part-1-shaping",
+		"  Q&A -- What's next? ": "q-a-what-s-next",
+		"!!!":                    "untitled",
+	}
+
+	for input, want := range tests {
+		if got := slugify(input); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"golang.org/x/net/html"
+)
+
+// DefaultTextWidth is the line width used when none is configured
+const DefaultTextWidth = 80
+
+// headingUnderlines holds the underline character for each heading level
+var headingUnderlines = map[int]string{1: "=", 2: "-", 3: "~"}
+
+type TextConverter struct {
+	OutputPath string
+	Width      int
+	baseConverter
+}
+
+func NewTextConverter(outputPath string, width int) *TextConverter {
+	if !strings.HasSuffix(outputPath, ".txt") {
+		outputPath = outputPath + ".txt"
+	}
+	if width <= 0 {
+		width = DefaultTextWidth
+	}
+
+	return &TextConverter{
+		OutputPath: outputPath,
+		Width:      width,
+	}
+}
+
+func (t *TextConverter) Convert(chapters []downloader.Chapter, css string) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters provided for conversion")
+	}
+
+	var out strings.Builder
+	out.WriteString(t.titlePage(chapters))
+
+	for _, chapter := range chapters {
+		text, err := t.renderChapter(chapter, chapters)
+		if err != nil {
+			return fmt.Errorf("failed to process chapter %s: %w", chapter.Title, err)
+		}
+		out.WriteString("\n\n")
+		out.WriteString(text)
+	}
+
+	if err := os.WriteFile(t.OutputPath, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// titlePage renders the book title and a table of contents grouped by part
+func (t *TextConverter) titlePage(chapters []downloader.Chapter) string {
+	meta := t.metadata()
+
+	var lines []string
+	lines = append(lines, underline(meta.Title, 1, t.Width)...)
+	lines = append(lines, "")
+	if meta.Subtitle != "" {
+		lines = append(lines, wrapText(meta.Subtitle, t.Width)...)
+		lines = append(lines, "")
+	}
+	lines = append(lines, "by "+meta.Author, "")
+
+	lines = append(lines, underline("Contents", 2, t.Width)...)
+	for _, part := range t.organizeParts(chapters) {
+		lines = append(lines, "", part.Title)
+		for _, chapter := range part.Chapters {
+			lines = append(lines, wrapIndented(chapter.Title, "  ", "  ", t.Width)...)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// renderChapter renders one chapter followed by its link footnotes
+func (t *TextConverter) renderChapter(chapter downloader.Chapter, chapters []downloader.Chapter) (string, error) {
+	processedContent, err := t.processChapterContent(chapter.Content)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := parseBody(processedContent)
+	if err != nil {
+		return "", err
+	}
+
+	r := &textRenderer{chapters: chapters}
+	lines := r.blocks(body, t.Width)
+
+	if len(r.links) > 0 {
+		lines = append(lines, "", "Links:")
+		for i, link := range r.links {
+			marker := fmt.Sprintf("[%d] ", i+1)
+			lines = append(lines, wrapIndented(link, marker, strings.Repeat(" ", len(marker)), t.Width)...)
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// textRenderer turns chapter HTML into wrapped lines, collecting link
+// targets as numbered footnotes
+type textRenderer struct {
+	chapters []downloader.Chapter
+	links    []string
+}
+
+// blocks renders the children of n at the given width, separating blocks
+// with blank lines
+func (r *textRenderer) blocks(n *html.Node, width int) []string {
+	var lines []string
+	var inline strings.Builder
+
+	appendBlock := func(block []string) {
+		if len(block) == 0 {
+			return
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	flush := func() {
+		appendBlock(wrapText(inline.String(), width))
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlockElement(c.Data) {
+			flush()
+			appendBlock(r.block(c, width))
+			continue
+		}
+		inline.WriteString(r.inline(c))
+	}
+	flush()
+
+	return lines
+}
+
+func (r *textRenderer) block(n *html.Node, width int) []string {
+	switch n.Data {
+	case "p", "figcaption":
+		return wrapText(r.inlineChildren(n), width)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return underline(r.inlineChildren(n), headingLevel(n), width)
+	case "ul", "ol":
+		return r.list(n, width)
+	case "blockquote":
+		return indentLines(r.blocks(n, width-4), "    ")
+	case "pre":
+		return indentLines(strings.Split(strings.TrimRight(extractText(n), "\n"), "\n"), "    ")
+	case "hr":
+		return []string{centerText("* * *", width)}
+	case "table":
+		return r.table(n, width)
+	default:
+		return r.blocks(n, width)
+	}
+}
+
+// table renders each row on its own line with its cells separated by " | ".
+// Rows too long for the width continue on indented lines.
+func (r *textRenderer) table(n *html.Node, width int) []string {
+	var lines []string
+	for _, row := range findAllNodes(n, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "tr"
+	}) {
+		var cells []string
+		for c := row.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+				cells = append(cells, normalizeSpace(r.inlineChildren(c)))
+			}
+		}
+		if len(cells) > 0 {
+			lines = append(lines, wrapIndented(strings.Join(cells, " | "), "", "    ", width)...)
+		}
+	}
+	return lines
+}
+
+func (r *textRenderer) list(n *html.Node, width int) []string {
+	var lines []string
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		marker := "* "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		padding := strings.Repeat(" ", len(marker))
+
+		item := r.blocks(c, width-len(marker))
+		for i, line := range item {
+			switch {
+			case i == 0:
+				lines = append(lines, marker+line)
+			case line == "":
+				lines = append(lines, "")
+			default:
+				lines = append(lines, padding+line)
+			}
+		}
+	}
+
+	return lines
+}
+
+func (r *textRenderer) inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(r.inline(c))
+	}
+	return text.String()
+}
+
+// inline renders inline content as a single unwrapped string. Hard line
+// breaks are kept as newlines for wrapText to honour.
+func (r *textRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return n.Data
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\n"
+	case "img":
+		if alt := normalizeSpace(getAttr(n, "alt")); alt != "" {
+			return "[" + alt + "]"
+		}
+		return "[image]"
+	case "a":
+		text := r.inlineChildren(n)
+		href := getAttr(n, "href")
+		if href == "" || strings.HasPrefix(href, "#") {
+			return text
+		}
+		return text + fmt.Sprintf("[%d]", r.addLink(href))
+	case "script", "style", "template":
+		return ""
+	default:
+		return r.inlineChildren(n)
+	}
+}
+
+// addLink records a footnote target and returns its number. Links into the
+// book are described by chapter title rather than URL.
+func (r *textRenderer) addLink(href string) int {
+	target := href
+	if chapter := findChapterByURL(href, r.chapters); chapter != nil {
+		target = "See \"" + chapter.Title + "\""
+		if _, fragment, found := strings.Cut(href, "#"); found {
+			target += " (#" + fragment + ")"
+		}
+	}
+
+	for i, link := range r.links {
+		if link == target {
+			return i + 1
+		}
+	}
+	r.links = append(r.links, target)
+	return len(r.links)
+}
+
+// wrapText wraps text to width, collapsing whitespace but keeping explicit
+// newlines as line breaks
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		lines = append(lines, wrapIndented(paragraph, "", "", width)...)
+	}
+
+	// Trim blank lines left over from leading or trailing breaks
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// wrapIndented wraps a single paragraph, prefixing the first line with
+// first and the rest with rest. Words longer than the width get a line of
+// their own.
+func wrapIndented(text, first, rest string, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	line := first + words[0]
+	for _, word := range words[1:] {
+		if utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = rest + word
+			continue
+		}
+		line += " " + word
+	}
+	return append(lines, line)
+}
+
+// underline renders a heading wrapped to width, with a rule beneath it as
+// long as its longest line
+func underline(title string, level, width int) []string {
+	char, ok := headingUnderlines[level]
+	if !ok {
+		char = "~"
+	}
+	lines := wrapText(normalizeSpace(title), width)
+	if len(lines) == 0 {
+		return nil
+	}
+	length := 0
+	for _, line := range lines {
+		length = max(length, utf8.RuneCountInString(line))
+	}
+	return append(lines, strings.Repeat(char, length))
+}
+
+func indentLines(lines []string, prefix string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		if line != "" {
+			line = prefix + line
+		}
+		result[i] = line
+	}
+	return result
+}
+
+func centerText(text string, width int) string {
+	padding := (width - utf8.RuneCountInString(text)) / 2
+	if padding < 0 {
+		padding = 0
+	}
+	return strings.Repeat(" ", padding) + text
+}
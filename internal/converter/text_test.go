@@ -0,0 +1,142 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+)
+
+// TestTextConverter_Convert verifies headings, lists, quotes, images and
+// link footnotes in the plain-text output
+func TestTextConverter_Convert(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "book")
+
+	chapters := []downloader.Chapter{
+		{
+			Title: "Principles of Shaping",
+			Content: `<div class="content">
+                        <h1 class="intro__title"><a href="#toc">Principles of Shaping</a></h1>
+                        <p>When we shape the work, we need to do it at the right level of abstraction: not too vague and not too concrete. See <a href="/shapeup/1.2#appetite">Set Boundaries</a> and <a href="https://basecamp.com">Basecamp</a>.</p>
+                        <h2>Levels of abstraction</h2>
+                        <ol><li>Wireframes are too concrete</li><li>Words are too abstract</li></ol>
+                        <blockquote><p>Fixed time, variable scope.</p></blockquote>
+                        <figure><img src="sketch.png" alt="A fat marker sketch"></figure>
+                      </div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+		},
+		{
+			Title:   "Set Boundaries",
+			Content: "<div class='content'><h2 id='appetite'>Appetite</h2></div>",
+			URL:     "https://basecamp.com/shapeup/1.2",
+			Number:  2,
+		},
+	}
+
+	conv := NewTextConverter(testFile, 40)
+	if err := conv.Convert(chapters, ""); err != nil {
+		t.Fatalf("Convert() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testFile + ".txt")
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	output := string(data)
+
+	expected := []string{
+		"Principles of Shaping\n=====================",
+		"Levels of abstraction\n---------------------",
+		"1. Wireframes are too concrete\n2. Words are too abstract",
+		"    Fixed time, variable scope.",
+		"[A fat marker sketch]",
+		"Boundaries[1]",
+		"Basecamp[2]",
+		"[1] See \"Set Boundaries\" (#appetite)",
+		"[2] https://basecamp.com",
+	}
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("Convert() output missing %q", want)
+		}
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if utf8.RuneCountInString(line) > 40 {
+			t.Errorf("line exceeds configured width: %q", line)
+		}
+	}
+}
+
+// TestWrapText verifies word wrapping and hard line breaks
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{"fits", "short text", 20, []string{"short text"}},
+		{"wraps", "one two three four", 9, []string{"one two", "three", "four"}},
+		{"long word", "a supercalifragilistic b", 5, []string{"a", "supercalifragilistic", "b"}},
+		{"hard break", "one\ntwo", 20, []string{"one", "two"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapText(tt.text, tt.width)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("wrapText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnderline verifies headings wrap to the width with a rule as long as
+// their longest line
+func TestUnderline(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		level int
+		width int
+		want  []string
+	}{
+		{"fits", "Set Boundaries", 2, 40, []string{"Set Boundaries", "--------------"}},
+		{"wraps", "Find the elements of the shaped work", 1, 20, []string{"Find the elements of", "the shaped work", "===================="}},
+		{"empty", " ", 1, 20, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := underline(tt.title, tt.level, tt.width)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("underline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTextRenderer_Table verifies table cells are kept apart and long rows
+// wrap to the width
+func TestTextRenderer_Table(t *testing.T) {
+	body, err := parseBody(`<table><thead><tr><th>Appetite</th><th>Team</th></tr></thead>
+        <tbody><tr><td>Small batch</td><td>One designer and one or two programmers</td></tr></tbody></table>`)
+	if err != nil {
+		t.Fatalf("parseBody() error = %v", err)
+	}
+
+	got := (&textRenderer{}).blocks(body, 40)
+	want := []string{
+		"Appetite | Team",
+		"Small batch | One designer and one or",
+		"    two programmers",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("blocks() = %q, want %q", got, want)
+	}
+}
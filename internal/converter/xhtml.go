@@ -0,0 +1,201 @@
+package converter
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	xhtmlNamespace = "http://www.w3.org/1999/xhtml"
+	epubNamespace  = "http://www.idpf.org/2007/ops"
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	mathNamespace  = "http://www.w3.org/1998/Math/MathML"
+)
+
+// xmlName matches names that are valid XML element and attribute names,
+// optionally with a namespace prefix
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*(:[A-Za-z_][A-Za-z0-9._-]*)?$`)
+
+// xhtmlVoidElements are written as self-closing tags. Every other element
+// gets an explicit end tag, since readers that parse XHTML as HTML treat
+// <div/> as an open tag.
+var xhtmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// xhtmlEscaper escapes text and attribute values for XML
+var xhtmlEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+)
+
+// xhtmlFromHTML parses an HTML fragment and returns it as well-formed XHTML
+func xhtmlFromHTML(content string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse content: %w", err)
+	}
+	return xhtmlFragment(doc)
+}
+
+// xhtmlFragment serializes the contents of a document's body as XHTML and
+// checks the result is well-formed by parsing it back
+func xhtmlFragment(doc *html.Node) (string, error) {
+	body := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "body"
+	})
+	if body == nil {
+		return "", fmt.Errorf("could not find document body")
+	}
+
+	var buf strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := renderXHTML(&buf, c); err != nil {
+			return "", err
+		}
+	}
+
+	if err := validateXHTML(buf.String()); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderXHTML writes n as XHTML. Comments, doctypes and nodes that can't be
+// expressed in XML are dropped.
+func renderXHTML(w io.Writer, n *html.Node) error {
+	var buf strings.Builder
+	writeXHTMLNode(&buf, n)
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+func writeXHTMLNode(buf *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(xhtmlEscaper.Replace(xmlChars(n.Data)))
+		return
+	case html.DocumentNode:
+		writeXHTMLChildren(buf, n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	name := n.Data
+	if !xmlName.MatchString(name) || strings.Contains(name, ":") {
+		// Keep the content of elements XML can't name
+		writeXHTMLChildren(buf, n)
+		return
+	}
+
+	buf.WriteString("<" + name)
+
+	// Foreign content needs its namespace declared where it starts
+	if n.Parent == nil || n.Parent.Namespace != n.Namespace {
+		switch n.Namespace {
+		case "svg":
+			buf.WriteString(` xmlns="` + svgNamespace + `" xmlns:xlink="http://www.w3.org/1999/xlink"`)
+		case "math":
+			buf.WriteString(` xmlns="` + mathNamespace + `"`)
+		}
+	}
+
+	for _, attr := range n.Attr {
+		key := attr.Key
+		if attr.Namespace != "" {
+			key = attr.Namespace + ":" + attr.Key
+		}
+		if !xhtmlAttributeAllowed(key) {
+			continue
+		}
+		buf.WriteString(" " + key + `="` + xhtmlEscaper.Replace(xmlChars(attr.Val)) + `"`)
+	}
+
+	if n.Namespace == "" && xhtmlVoidElements[name] {
+		buf.WriteString("/>")
+		return
+	}
+	if n.Namespace != "" && n.FirstChild == nil {
+		buf.WriteString("/>")
+		return
+	}
+
+	buf.WriteString(">")
+	writeXHTMLChildren(buf, n)
+	buf.WriteString("</" + name + ">")
+}
+
+func writeXHTMLChildren(buf *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeXHTMLNode(buf, c)
+	}
+}
+
+// xhtmlAttributeAllowed reports whether an attribute can be written. The
+// only prefixes allowed are the ones declared on every content document.
+func xhtmlAttributeAllowed(key string) bool {
+	if !xmlName.MatchString(key) || key == "xmlns" {
+		return false
+	}
+	prefix, _, found := strings.Cut(key, ":")
+	return !found || prefix == "xml" || prefix == "epub" || prefix == "xlink"
+}
+
+// xmlChars removes characters that aren't allowed anywhere in an XML
+// document
+func xmlChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20, r >= 0xD800 && r <= 0xDFFF, r == 0xFFFE, r == 0xFFFF:
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// validateXHTML parses an XHTML fragment with encoding/xml and returns an
+// error with the line and text of the first problem
+func validateXHTML(fragment string) error {
+	// The wrapper shares the first line, so line numbers match the fragment
+	doc := `<body xmlns="` + xhtmlNamespace + `" xmlns:epub="` + epubNamespace + `">` + fragment + "</body>"
+
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	decoder.Strict = true
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			continue
+		}
+
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			lines := strings.Split(fragment, "\n")
+			line := ""
+			if syntaxErr.Line >= 1 && syntaxErr.Line <= len(lines) {
+				line = strings.TrimSpace(lines[syntaxErr.Line-1])
+				if len(line) > 80 {
+					line = line[:80] + "..."
+				}
+			}
+			return fmt.Errorf("invalid XHTML on line %d: %s: %q", syntaxErr.Line, syntaxErr.Msg, line)
+		}
+		return fmt.Errorf("invalid XHTML: %w", err)
+	}
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestXHTMLFromHTML verifies HTML is serialized as well-formed XHTML
+func TestXHTMLFromHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "void elements",
+			input: `<p>One<br>Two</p><img src="a.png" alt="A"><hr>`,
+			want:  `<p>One<br/>Two</p><img src="a.png" alt="A"/><hr/>`,
+		},
+		{
+			name:  "nested document wrappers",
+			input: `<section><html><head></head><body><p>Text</p></body></html></section>`,
+			want:  `<section><p>Text</p></section>`,
+		},
+		{
+			name:  "entities and escaping",
+			input: `<p title="&quot;Q&quot; &amp; A">Fish &amp; chips&nbsp;&lt;3 &copy;</p>`,
+			want:  `<p title="&quot;Q&quot; &amp; A">Fish &amp; chips` + "\u00a0" + `&lt;3 ©</p>`,
+		},
+		{
+			name:  "empty elements keep an end tag",
+			input: `<a id="top"></a><div></div>`,
+			want:  `<a id="top"></a><div></div>`,
+		},
+		{
+			name:  "invalid attributes and comments",
+			input: `<p @click="x" foo:bar="y" epub:type="note" data-id="1"><!-- gone -->Text` + "\x01" + `</p>`,
+			want:  `<p epub:type="note" data-id="1">Text</p>`,
+		},
+		{
+			name:  "svg namespace",
+			input: `<svg viewBox="0 0 1 1"><image xlink:href="a.png"></image></svg>`,
+			want:  `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 1 1"><image xlink:href="a.png"/></svg>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xhtmlFromHTML(tt.input)
+			if err != nil {
+				t.Fatalf("xhtmlFromHTML() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("xhtmlFromHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestValidateXHTML verifies malformed XHTML is reported with its line
+func TestValidateXHTML(t *testing.T) {
+	if err := validateXHTML("<p>One</p>\n<p>Two<br/></p>"); err != nil {
+		t.Errorf("validateXHTML() error = %v for well-formed input", err)
+	}
+
+	err := validateXHTML("<p>One</p>\n<p>Two<br></p>")
+	if err == nil {
+		t.Fatal("validateXHTML() expected error for unclosed <br>")
+	}
+	for _, want := range []string{"line 2", "<p>Two<br></p>"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validateXHTML() error %q missing %q", err, want)
+		}
+	}
+
+	if err := validateXHTML("<p>&nbsp;</p>"); err == nil {
+		t.Error("validateXHTML() expected error for an HTML-only entity")
+	}
+}
@@ -0,0 +1,179 @@
+// Package cssprune removes the rules of a stylesheet that no element of a
+// document uses, along with the fonts and animations only those rules
+// referred to.
+package cssprune
+
+import "strings"
+
+// groupingRules are the at-rules whose blocks hold further rules
+var groupingRules = map[string]bool{
+	"@media":     true,
+	"@supports":  true,
+	"@layer":     true,
+	"@container": true,
+	"@document":  true,
+	"@scope":     true,
+}
+
+// rule is one rule of a stylesheet
+type rule struct {
+	// prelude is the selector list of a style rule, or the at-keyword and
+	// prelude of an at-rule
+	prelude string
+	// block is the text between the braces, unless the rule groups others
+	block string
+	// rules holds the rules inside a grouping at-rule
+	rules []rule
+	// blockless is set for at-rules that end in a semicolon, like @charset
+	blockless bool
+}
+
+func (r rule) isAtRule() bool {
+	return strings.HasPrefix(r.prelude, "@")
+}
+
+// atKeyword returns the lowercased at-keyword of an at-rule
+func (r rule) atKeyword() string {
+	keyword := r.prelude
+	if end := strings.IndexAny(keyword, " \t\n("); end >= 0 {
+		keyword = keyword[:end]
+	}
+	return strings.ToLower(keyword)
+}
+
+// groups reports whether the rule is an at-rule holding further rules
+func (r rule) groups() bool {
+	return r.isAtRule() && (groupingRules[r.atKeyword()] || strings.HasSuffix(r.atKeyword(), "-document"))
+}
+
+// parse splits a stylesheet into rules. Comments are dropped. It doesn't
+// validate the CSS; text it can't make sense of ends up in a rule of its
+// own, which is kept.
+func parse(css string) []rule {
+	var rules []rule
+	s := stripComments(css)
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return rules
+		}
+
+		end := scanTo(s, "{;")
+		if end == len(s) {
+			// A trailing rule without a block
+			return append(rules, rule{prelude: s, blockless: true})
+		}
+		prelude := strings.TrimSpace(s[:end])
+		if s[end] == ';' {
+			rules = append(rules, rule{prelude: prelude, blockless: true})
+			s = s[end+1:]
+			continue
+		}
+
+		closing := matchingBrace(s, end)
+		r := rule{prelude: prelude, block: s[end+1 : closing]}
+		if r.groups() {
+			r.rules = parse(r.block)
+			r.block = ""
+		}
+		rules = append(rules, r)
+		if closing == len(s) {
+			return rules
+		}
+		s = s[closing+1:]
+	}
+}
+
+// stripComments removes /* */ comments outside strings
+func stripComments(css string) string {
+	var out strings.Builder
+	for i := 0; i < len(css); i++ {
+		switch c := css[i]; {
+		case c == '"' || c == '\'':
+			end := skipString(css, i)
+			out.WriteString(css[i:end])
+			i = end - 1
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return out.String()
+			}
+			i += end + 3
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// scanTo returns the index of the first of chars in s outside strings and
+// brackets, or len(s)
+func scanTo(s, chars string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\'':
+			i = skipString(s, i) - 1
+		case c == '(' || c == '[':
+			depth++
+		case (c == ')' || c == ']') && depth > 0:
+			depth--
+		case depth == 0 && strings.IndexByte(chars, c) >= 0:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or
+// len(s) if it's never closed
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i = skipString(s, i) - 1
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// skipString returns the index just past the string starting at start
+func skipString(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// format writes rules back out as CSS
+func format(rules []rule) string {
+	var out strings.Builder
+	for i, r := range rules {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		switch {
+		case r.blockless:
+			out.WriteString(r.prelude + ";")
+		case r.groups():
+			out.WriteString(r.prelude + " {\n" + format(r.rules) + "\n}")
+		default:
+			out.WriteString(r.prelude + " {" + r.block + "}")
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,213 @@
+package cssprune
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Prune returns css without the style rules whose selectors match no
+// element in docs, and without the @font-face and @keyframes rules that
+// only those style rules used. Selectors are matched without their
+// pseudo-classes and pseudo-elements, so a rule for :hover is kept for any
+// element that could be hovered. Selectors Prune can't parse are kept.
+func Prune(css string, docs ...*html.Node) string {
+	p := pruner{matched: make(map[string]bool)}
+	for _, doc := range docs {
+		p.collect(doc)
+	}
+
+	rules := p.pruneSelectors(parse(css))
+	u := usage{fonts: make(map[string]bool), animations: make(map[string]bool)}
+	u.collect(rules)
+	for _, style := range p.inlineStyles {
+		u.addDeclarations(style)
+	}
+	return format(u.prune(rules))
+}
+
+type pruner struct {
+	elements []*html.Node
+	// inlineStyles holds the elements' style attributes, which can use
+	// fonts and animations too
+	inlineStyles []string
+	// matched caches whether each selector matched
+	matched map[string]bool
+}
+
+func (p *pruner) collect(n *html.Node) {
+	if n.Type == html.ElementNode {
+		p.elements = append(p.elements, n)
+		if style := attr(n, "style"); style != "" {
+			p.inlineStyles = append(p.inlineStyles, style)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.collect(c)
+	}
+}
+
+// pruneSelectors drops the selectors that match nothing from each style
+// rule, and the rules left without any
+func (p *pruner) pruneSelectors(rules []rule) []rule {
+	var kept []rule
+	for _, r := range rules {
+		switch {
+		case r.groups():
+			if r.rules = p.pruneSelectors(r.rules); len(r.rules) == 0 {
+				continue
+			}
+		case r.isAtRule() || r.blockless:
+		default:
+			var selectors []string
+			for _, s := range splitSelectors(r.prelude) {
+				if p.matches(s) {
+					selectors = append(selectors, s)
+				}
+			}
+			if len(selectors) == 0 {
+				continue
+			}
+			r.prelude = strings.Join(selectors, ", ")
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// matches reports whether a selector applies to any element. Selectors
+// that can't be parsed match.
+func (p *pruner) matches(s string) bool {
+	if matched, ok := p.matched[s]; ok {
+		return matched
+	}
+	sel, ok := parseSelector(s)
+	matched := !ok
+	for _, n := range p.elements {
+		if matched {
+			break
+		}
+		matched = sel.matches(n)
+	}
+	p.matched[s] = matched
+	return matched
+}
+
+// usage records the font families and animations the kept rules use
+type usage struct {
+	fonts      map[string]bool
+	animations map[string]bool
+}
+
+func (u usage) collect(rules []rule) {
+	for _, r := range rules {
+		switch {
+		case r.groups():
+			u.collect(r.rules)
+		case !r.isAtRule() && !r.blockless:
+			u.addDeclarations(r.block)
+		}
+	}
+}
+
+// addDeclarations records the fonts and animations a declaration block
+// names. The font and animation shorthands mix names with other values, so
+// every word in them counts. So does every word of a custom property, which
+// a font or animation declaration can take its names from with var().
+func (u usage) addDeclarations(block string) {
+	for _, declaration := range splitDeclarations(block) {
+		property, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		switch {
+		case property == "font-family":
+			for _, family := range strings.Split(value, ",") {
+				u.fonts[normalizeName(family)] = true
+			}
+		case property == "font":
+			u.addFontWords(value)
+		case property == "animation" || property == "animation-name":
+			u.addAnimationWords(value)
+		case strings.HasPrefix(property, "--"):
+			u.addFontWords(value)
+			u.addAnimationWords(value)
+		}
+	}
+}
+
+// addFontWords records each run of words up to a comma in value as a font
+// family
+func (u usage) addFontWords(value string) {
+	for _, part := range strings.Split(value, ",") {
+		words := strings.Fields(part)
+		for i := range words {
+			u.fonts[normalizeName(strings.Join(words[i:], " "))] = true
+		}
+	}
+}
+
+// addAnimationWords records each word in value as an animation name
+func (u usage) addAnimationWords(value string) {
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		u.animations[normalizeName(word)] = true
+	}
+}
+
+// prune drops unused @font-face and @keyframes rules, and groups left
+// empty
+func (u usage) prune(rules []rule) []rule {
+	var kept []rule
+	for _, r := range rules {
+		keyword := r.atKeyword()
+		switch {
+		case r.groups():
+			if r.rules = u.prune(r.rules); len(r.rules) == 0 {
+				continue
+			}
+		case keyword == "@font-face":
+			if family := fontFaceFamily(r.block); family != "" && !u.fonts[family] {
+				continue
+			}
+		case r.isAtRule() && strings.HasSuffix(keyword, "keyframes"):
+			name := normalizeName(r.prelude[len(keyword):])
+			if !u.animations[name] {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// fontFaceFamily returns the family an @font-face block declares
+func fontFaceFamily(block string) string {
+	for _, declaration := range splitDeclarations(block) {
+		property, value, ok := strings.Cut(declaration, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(property), "font-family") {
+			return normalizeName(value)
+		}
+	}
+	return ""
+}
+
+// splitDeclarations splits a declaration block at its semicolons
+func splitDeclarations(block string) []string {
+	var declarations []string
+	for {
+		end := scanTo(block, ";")
+		declarations = append(declarations, block[:end])
+		if end == len(block) {
+			return declarations
+		}
+		block = block[end+1:]
+	}
+}
+
+// normalizeName returns a font or animation name as it compares: without
+// quotes or !important, and in lowercase
+func normalizeName(name string) string {
+	name = strings.TrimSuffix(strings.TrimSpace(name), "!important")
+	return strings.ToLower(strings.Trim(strings.TrimSpace(name), `"'`))
+}
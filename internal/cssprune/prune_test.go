@@ -0,0 +1,121 @@
+package cssprune
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><body>
+<div class="content">
+  <h1 id="title">Shape Up</h1>
+  <p class="lead intro">Intro <a href="https://basecamp.com">link</a></p>
+  <p style="font-family: Inline Sans">Styled inline</p>
+  <ul class="toc"><li><a href="#one">One</a></li></ul>
+</div>
+</body></html>`
+
+// TestPrune verifies unused rules, fonts and animations are removed and
+// everything that could apply is kept
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{
+			name: "unused selectors",
+			css:  "h1 { color: red; }\n.sidebar { float: left; }\n.content p, .footer p { margin: 0; }",
+			want: "h1 { color: red; }\n.content p { margin: 0; }",
+		},
+		{
+			name: "combinators and attributes",
+			css: `.content > h1 + p { a: 1; }
+.content > p { b: 2; }
+ul ~ p { c: 3; }
+h1 ~ ul.toc li { d: 4; }
+a[href^="https://"] { e: 5; }
+a[href$=".pdf"] { f: 6; }
+p[class~=intro] { g: 7; }`,
+			want: ".content > h1 + p { a: 1; }\n.content > p { b: 2; }\nh1 ~ ul.toc li { d: 4; }\n" +
+				`a[href^="https://"] { e: 5; }` + "\np[class~=intro] { g: 7; }",
+		},
+		{
+			name: "pseudo-classes and unparsable selectors",
+			css:  "a:hover { x: 1; }\nbutton:focus { x: 2; }\n.toc a::before { x: 3; }\n& .nested { x: 4; }",
+			want: "a:hover { x: 1; }\n.toc a::before { x: 3; }\n& .nested { x: 4; }",
+		},
+		{
+			name: "media queries",
+			css:  "@media (max-width: 40em) { h1 { font-size: 2em; } .sidebar { display: none; } }\n@media print { nav { display: none; } }",
+			want: "@media (max-width: 40em) {\nh1 { font-size: 2em; }\n}",
+		},
+		{
+			name: "fonts",
+			css: `@font-face { font-family: "Book Sans"; src: url(a.woff2); }
+@font-face { font-family: Sidebar; src: url(b.woff2); }
+@font-face { font-family: 'Inline Sans'; src: url(c.woff2); }
+body { font: 16px/1.5 "Book Sans", sans-serif; }
+.sidebar { font-family: Sidebar; }`,
+			want: `@font-face { font-family: "Book Sans"; src: url(a.woff2); }` + "\n" +
+				`@font-face { font-family: 'Inline Sans'; src: url(c.woff2); }` + "\n" +
+				`body { font: 16px/1.5 "Book Sans", sans-serif; }`,
+		},
+		{
+			name: "fonts and animations through custom properties",
+			css: `@font-face { font-family: "Book Serif"; src: url(a.woff2); }
+@font-face { font-family: Unused; src: url(b.woff2); }
+@keyframes fade { from { opacity: 0; } }
+:root { --body-font: "Book Serif", serif; --intro: fade 1s; }
+body { font-family: var(--body-font); }
+h1 { animation: var(--intro); }`,
+			want: `@font-face { font-family: "Book Serif"; src: url(a.woff2); }` + "\n" +
+				"@keyframes fade { from { opacity: 0; } }\n" +
+				`:root { --body-font: "Book Serif", serif; --intro: fade 1s; }` + "\n" +
+				"body { font-family: var(--body-font); }\nh1 { animation: var(--intro); }",
+		},
+		{
+			name: "animations",
+			css:  "@keyframes fade { from { opacity: 0; } to { opacity: 1; } }\n@keyframes spin { to { transform: rotate(1turn); } }\nh1 { animation: fade 1s; }\n.spinner { animation-name: spin; }",
+			want: "@keyframes fade { from { opacity: 0; } to { opacity: 1; } }\nh1 { animation: fade 1s; }",
+		},
+		{
+			name: "other at-rules and comments",
+			css:  "@charset \"utf-8\";\n/* layout */\n@page { margin: 1in; }\n.missing { x: 1; }",
+			want: "@charset \"utf-8\";\n@page { margin: 1in; }",
+		},
+	}
+
+	doc, err := html.Parse(strings.NewReader(testPage))
+	if err != nil {
+		t.Fatalf("failed to parse page: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Prune(tt.css, doc); got != tt.want {
+				t.Errorf("Prune() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPrune_SeveralDocuments verifies a rule is kept if any document uses
+// it
+func TestPrune_SeveralDocuments(t *testing.T) {
+	var docs []*html.Node
+	for _, page := range []string{`<p class="one">1</p>`, `<p class="two">2</p>`} {
+		doc, err := html.Parse(strings.NewReader(page))
+		if err != nil {
+			t.Fatalf("failed to parse page: %v", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	got := Prune(".one { a: 1; }\n.two { b: 2; }\n.three { c: 3; }", docs...)
+	if want := ".one { a: 1; }\n.two { b: 2; }"; got != want {
+		t.Errorf("Prune() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,357 @@
+package cssprune
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a complex selector: compound selectors joined by
+// combinators, the subject last
+type selector struct {
+	compounds []compound
+	// combinators[i] joins compounds[i] and compounds[i+1]: ' ', '>', '+'
+	// or '~'
+	combinators []byte
+}
+
+// compound is a run of simple selectors that all apply to one element.
+// Pseudo-classes and pseudo-elements are left out, so it matches any
+// element they could apply to.
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attribute
+}
+
+// attribute is an attribute selector such as [href^="http" i]
+type attribute struct {
+	name  string
+	op    string
+	value string
+	fold  bool
+}
+
+// splitSelectors splits a selector list at its top-level commas
+func splitSelectors(list string) []string {
+	var selectors []string
+	for {
+		end := scanTo(list, ",")
+		if s := strings.TrimSpace(list[:end]); s != "" {
+			selectors = append(selectors, s)
+		}
+		if end == len(list) {
+			return selectors
+		}
+		list = list[end+1:]
+	}
+}
+
+// parseSelector parses a complex selector. It reports false for syntax it
+// doesn't understand.
+func parseSelector(s string) (selector, bool) {
+	s = strings.TrimSpace(s)
+	var sel selector
+	var cur compound
+	started := false
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSpace(c) || c == '>' || c == '+' || c == '~':
+			combinator := byte(' ')
+			for ; i < len(s) && (isSpace(s[i]) || strings.IndexByte(">+~", s[i]) >= 0); i++ {
+				if !isSpace(s[i]) {
+					if combinator != ' ' {
+						return selector{}, false
+					}
+					combinator = s[i]
+				}
+			}
+			// Relative selectors, as in nested rules, aren't supported
+			if !started {
+				return selector{}, false
+			}
+			sel.compounds = append(sel.compounds, cur)
+			sel.combinators = append(sel.combinators, combinator)
+			cur, started = compound{}, false
+			continue
+		case c == '*':
+			i++
+		case c == '.' || c == '#':
+			name, next := ident(s, i+1)
+			if name == "" {
+				return selector{}, false
+			}
+			if c == '.' {
+				cur.classes = append(cur.classes, name)
+			} else {
+				cur.id = name
+			}
+			i = next
+		case c == '[':
+			end := i + 1 + scanTo(s[i+1:], "]")
+			if end >= len(s) {
+				return selector{}, false
+			}
+			attr, ok := parseAttribute(s[i+1 : end])
+			if !ok {
+				return selector{}, false
+			}
+			cur.attrs = append(cur.attrs, attr)
+			i = end + 1
+		case c == ':':
+			i++
+			if i < len(s) && s[i] == ':' {
+				i++
+			}
+			name, next := ident(s, i)
+			if name == "" {
+				return selector{}, false
+			}
+			i = next
+			if i < len(s) && s[i] == '(' {
+				end := i + 1 + scanTo(s[i+1:], ")")
+				if end >= len(s) {
+					return selector{}, false
+				}
+				i = end + 1
+			}
+		default:
+			name, next := ident(s, i)
+			if name == "" {
+				return selector{}, false
+			}
+			cur.tag = strings.ToLower(name)
+			i = next
+		}
+		started = true
+	}
+
+	if !started {
+		return selector{}, false
+	}
+	sel.compounds = append(sel.compounds, cur)
+	return sel, true
+}
+
+// parseAttribute parses the inside of an attribute selector
+func parseAttribute(s string) (attribute, bool) {
+	s = strings.TrimSpace(s)
+	name, i := ident(s, 0)
+	if name == "" {
+		return attribute{}, false
+	}
+	attr := attribute{name: strings.ToLower(name)}
+
+	rest := strings.TrimSpace(s[i:])
+	if rest == "" {
+		return attr, true
+	}
+	for _, op := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(rest, op) {
+			attr.op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if attr.op == "" || rest == "" {
+		return attribute{}, false
+	}
+
+	if rest[0] == '"' || rest[0] == '\'' {
+		end := skipString(rest, 0)
+		if end < 2 || rest[end-1] != rest[0] {
+			return attribute{}, false
+		}
+		var value strings.Builder
+		for j := 1; j < end-1; j++ {
+			if rest[j] == '\\' && j+1 < end-1 {
+				j++
+			}
+			value.WriteByte(rest[j])
+		}
+		attr.value, rest = value.String(), rest[end:]
+	} else {
+		attr.value, i = ident(rest, 0)
+		rest = rest[i:]
+	}
+
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "":
+	case "i":
+		attr.fold = true
+	case "s":
+	default:
+		return attribute{}, false
+	}
+	return attr, true
+}
+
+// ident reads the identifier starting at start, resolving escapes, and
+// returns it with the index after it
+func ident(s string, start int) (string, int) {
+	var out strings.Builder
+	i := start
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			hex := i + 1
+			for hex < len(s) && hex < i+7 && isHex(s[hex]) {
+				hex++
+			}
+			if hex == i+1 {
+				out.WriteByte(s[i+1])
+				i += 2
+				continue
+			}
+			code, _ := strconv.ParseUint(s[i+1:hex], 16, 32)
+			out.WriteRune(rune(code))
+			i = hex
+			if i < len(s) && isSpace(s[i]) {
+				i++
+			}
+		case c == '-' || c == '_' || c >= 0x80 ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9':
+			out.WriteByte(c)
+			i++
+		default:
+			return out.String(), i
+		}
+	}
+	return out.String(), i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// matches reports whether the selector applies to an element
+func (sel selector) matches(n *html.Node) bool {
+	return sel.matchAt(n, len(sel.compounds)-1)
+}
+
+// matchAt reports whether compounds[:i+1] match with n as the subject of
+// compounds[i]
+func (sel selector) matchAt(n *html.Node, i int) bool {
+	if !sel.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch sel.combinators[i-1] {
+	case ' ':
+		for p := parentElement(n); p != nil; p = parentElement(p) {
+			if sel.matchAt(p, i-1) {
+				return true
+			}
+		}
+	case '>':
+		p := parentElement(n)
+		return p != nil && sel.matchAt(p, i-1)
+	case '+':
+		p := previousElement(n)
+		return p != nil && sel.matchAt(p, i-1)
+	case '~':
+		for p := previousElement(n); p != nil; p = previousElement(p) {
+			if sel.matchAt(p, i-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c compound) matches(n *html.Node) bool {
+	if c.tag != "" && !strings.EqualFold(n.Data, c.tag) {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(attr(n, "class"))
+		for _, class := range c.classes {
+			if !slices.Contains(classes, class) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attribute) matches(n *html.Node) bool {
+	var value string
+	found := false
+	for _, at := range n.Attr {
+		if strings.EqualFold(at.Key, a.name) {
+			value, found = at.Val, true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	want := a.value
+	if a.fold {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == want
+	case "~=":
+		return slices.Contains(strings.Fields(value), want)
+	case "|=":
+		return value == want || strings.HasPrefix(value, want+"-")
+	case "^=":
+		return want != "" && strings.HasPrefix(value, want)
+	case "$=":
+		return want != "" && strings.HasSuffix(value, want)
+	case "*=":
+		return want != "" && strings.Contains(value, want)
+	}
+	return false
+}
+
+func parentElement(n *html.Node) *html.Node {
+	if p := n.Parent; p != nil && p.Type == html.ElementNode {
+		return p
+	}
+	return nil
+}
+
+func previousElement(n *html.Node) *html.Node {
+	for p := n.PrevSibling; p != nil; p = p.PrevSibling {
+		if p.Type == html.ElementNode {
+			return p
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package cssprune
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// TestSelector_Matches verifies selectors match the elements they should,
+// including escaped names and case-insensitive attributes
+func TestSelector_Matches(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div id="main" class="w-1/2 card"><span lang="en-GB" data-kind="Note">x</span><em>y</em></div>`))
+	if err != nil {
+		t.Fatalf("failed to parse page: %v", err)
+	}
+	var span *html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "span" {
+			span = n
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"span", true},
+		{"SPAN", true},
+		{"*", true},
+		{"div#main > span", true},
+		{`.w-1\/2 span`, true},
+		{`.w-1\2f 2 span`, true},
+		{".card.missing span", false},
+		{"em + span", false},
+		{"body span", true},
+		{"section span", false},
+		{`span[lang|="en"]`, true},
+		{`span[data-kind="note"]`, false},
+		{`span[data-kind="note" i]`, true},
+		{"span[data-kind]", true},
+		{"span[title]", false},
+		{"span:not(.x)::after", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, ok := parseSelector(tt.selector)
+			if !ok {
+				t.Fatalf("parseSelector(%q) failed", tt.selector)
+			}
+			if got := sel.matches(span); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseSelector_Unsupported verifies syntax the parser doesn't know is
+// reported rather than guessed at
+func TestParseSelector_Unsupported(t *testing.T) {
+	for _, s := range []string{"", "> a", "a > > b", "a[", "a:not(", "a[href=]", "a[x=y z]", "%"} {
+		if _, ok := parseSelector(s); ok {
+			t.Errorf("parseSelector(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package device describes the readers a book can be tuned for: how its
+// images are prepared, which EPUB version it's packaged as, whether its
+// fonts are embedded, and the CSS it needs on that reader.
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
+)
+
+// Profile holds the settings for one device
+type Profile struct {
+	// Extends names the profile a user-defined profile starts from
+	Extends     string `json:"extends,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Images says how images are downscaled, converted and re-encoded
+	Images imaging.Options `json:"images"`
+	// EPUBVersion is the EPUB version the device reads best, or 0 for the
+	// converter's default
+	EPUBVersion int `json:"epubVersion,omitempty"`
+	// EmbedFonts keeps the book's web fonts. Devices that ignore embedded
+	// fonts, or render their own better, leave it off.
+	EmbedFonts bool `json:"embedFonts"`
+	// Margins is the CSS margin around the text, such as "0" for readers
+	// that add their own
+	Margins string `json:"margins,omitempty"`
+	// CSS is added after the book's stylesheet to work around the device's
+	// quirks
+	CSS string `json:"css,omitempty"`
+}
+
+// Default leaves the book as it was downloaded
+var Default = Profile{EmbedFonts: true}
+
+// e-ink screens show 16 shades of gray and can't display WebP or SVG
+var einkImages = imaging.Options{
+	Grayscale:  true,
+	GrayLevels: 16,
+	Dither:     true,
+	Quality:    75,
+	Formats:    []string{"image/jpeg", "image/png", "image/gif"},
+}
+
+// readerCSS keeps images inside the page on readers that don't do it
+// themselves
+const readerCSS = "img { max-width: 100%; height: auto; }"
+
+// Builtin are the profiles that ship with the tool, by name
+var Builtin = map[string]Profile{
+	"kindle": {
+		Description: "Amazon Kindle (6\" e-ink)",
+		Images:      withMaxDimension(einkImages, 1448),
+		EPUBVersion: 3,
+		Margins:     "0",
+		// Kindle sets its own line spacing and ignores page breaks inside
+		// figures
+		CSS: readerCSS + " body { line-height: normal; } figure { page-break-inside: avoid; }",
+	},
+	"kindle-paperwhite": {
+		Description: "Amazon Kindle Paperwhite",
+		Images:      withMaxDimension(einkImages, 1648),
+		EPUBVersion: 3,
+		Margins:     "0",
+		CSS:         readerCSS + " body { line-height: normal; } figure { page-break-inside: avoid; }",
+	},
+	"kobo": {
+		Description: "Kobo e-readers",
+		Images:      withMaxDimension(einkImages, 1448),
+		EPUBVersion: 3,
+		EmbedFonts:  true,
+		Margins:     "0",
+		CSS:         readerCSS,
+	},
+	"kobo-clara": {
+		Description: "Kobo Clara",
+		Images:      withMaxDimension(einkImages, 1448),
+		EPUBVersion: 3,
+		EmbedFonts:  true,
+		Margins:     "0",
+		CSS:         readerCSS,
+	},
+	"remarkable": {
+		Description: "reMarkable tablets",
+		Images:      withMaxDimension(einkImages, 1872),
+		// The reMarkable reader handles the flat EPUB 2 layout best
+		EPUBVersion: 2,
+		Margins:     "1em",
+		CSS:         readerCSS + " pre, code { white-space: pre-wrap; }",
+	},
+	"apple-books": {
+		Description: "Apple Books on iPhone, iPad and Mac",
+		Images: imaging.Options{
+			MaxDimension: 2048,
+			Quality:      85,
+			Formats:      []string{"image/jpeg", "image/png", "image/gif", "image/svg+xml"},
+		},
+		EPUBVersion: 3,
+		EmbedFonts:  true,
+	},
+	"generic-phone": {
+		Description: "Reading apps on phones",
+		Images: imaging.Options{
+			MaxDimension: 1280,
+			Quality:      80,
+			Formats:      []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/svg+xml"},
+		},
+		EPUBVersion: 3,
+		EmbedFonts:  true,
+		Margins:     "0.5em",
+		CSS:         readerCSS + " pre { white-space: pre-wrap; }",
+	},
+	"tablet": {
+		Description: "Reading apps on tablets",
+		Images: imaging.Options{
+			MaxDimension: 2048,
+			Quality:      85,
+			Formats:      []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/svg+xml"},
+		},
+		EPUBVersion: 3,
+		EmbedFonts:  true,
+		CSS:         readerCSS,
+	},
+}
+
+func withMaxDimension(opts imaging.Options, limit int) imaging.Options {
+	opts.MaxDimension = limit
+	return opts
+}
+
+// fontFace matches an @font-face rule
+var fontFace = regexp.MustCompile(`@font-face\s*\{[^}]*\}\s*`)
+
+// StyleSheet returns css as the device should get it: without @font-face
+// rules unless the device embeds fonts, and followed by the device's
+// margins and CSS
+func (p Profile) StyleSheet(css string) string {
+	if !p.EmbedFonts {
+		css = fontFace.ReplaceAllString(css, "")
+	}
+
+	var rules []string
+	if p.Margins != "" {
+		rules = append(rules, "body { margin: "+p.Margins+"; }")
+	}
+	if p.CSS != "" {
+		rules = append(rules, p.CSS)
+	}
+	for _, rule := range rules {
+		if css != "" && !strings.HasSuffix(css, "\n") {
+			css += "\n"
+		}
+		css += rule + "\n"
+	}
+	return css
+}
+
+// config is the layout of the device config file
+type config struct {
+	Devices map[string]json.RawMessage `json:"devices"`
+}
+
+// DefaultConfigPath returns where the device config file is looked for
+// when none is given
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "shape-up-downloader", "devices.json")
+}
+
+// Load returns the built-in profiles together with those defined in the
+// config file at path. A user-defined profile replaces a built-in one of
+// the same name, and can start from another profile with "extends". With
+// optional set, a missing file isn't an error.
+func Load(path string, optional bool) (map[string]Profile, error) {
+	profiles := make(map[string]Profile, len(Builtin))
+	for name, profile := range Builtin {
+		profiles[name] = profile
+	}
+	if path == "" {
+		return profiles, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && optional {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device config: %w", err)
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse device config %s: %w", path, err)
+	}
+
+	// Resolve each profile after the one it extends
+	resolving := make(map[string]bool)
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		raw, ok := cfg.Devices[name]
+		if !ok {
+			return nil
+		}
+		if resolving[name] {
+			return fmt.Errorf("device %q extends itself through another device", name)
+		}
+		resolving[name] = true
+
+		var head struct {
+			Extends string `json:"extends"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return fmt.Errorf("failed to parse device %q: %w", name, err)
+		}
+
+		var profile Profile
+		if head.Extends != "" {
+			// A profile can extend the built-in profile it replaces
+			if head.Extends != name {
+				if err := resolve(head.Extends); err != nil {
+					return err
+				}
+			}
+			base, ok := profiles[head.Extends]
+			if !ok {
+				return fmt.Errorf("device %q extends unknown device %q", name, head.Extends)
+			}
+			profile = base
+			// Decoding would otherwise write into the base profile's list
+			profile.Images.Formats = slices.Clone(base.Images.Formats)
+		}
+		// Fields the file leaves out keep the extended profile's values
+		if err := json.Unmarshal(raw, &profile); err != nil {
+			return fmt.Errorf("failed to parse device %q: %w", name, err)
+		}
+		// Images in other formats are converted to the first one
+		if formats := profile.Images.Formats; len(formats) > 0 && !imaging.Encodable(formats[0]) {
+			return fmt.Errorf("device %q can't have images converted to %s; list image/jpeg, image/png or image/gif first", name, formats[0])
+		}
+		profiles[name] = profile
+		delete(cfg.Devices, name)
+		return nil
+	}
+
+	for _, name := range sortedKeys(cfg.Devices) {
+		if err := resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// Lookup returns the profile with a name, ignoring case
+func Lookup(profiles map[string]Profile, name string) (Profile, error) {
+	for _, known := range sortedKeys(profiles) {
+		if strings.EqualFold(name, known) {
+			return profiles[known], nil
+		}
+	}
+	return Profile{}, fmt.Errorf("invalid device: %q (must be one of: %s)", name, strings.Join(sortedKeys(profiles), ", "))
+}
+
+// Names returns the built-in profile names in order
+func Names() []string {
+	return sortedKeys(Builtin)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,179 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
+)
+
+// TestProfile_StyleSheet verifies web fonts are dropped for devices that
+// don't embed them and the device's rules follow the book's
+func TestProfile_StyleSheet(t *testing.T) {
+	css := "@font-face { font-family: Book; src: url(book.woff2); }\nbody { color: #222; }\n"
+
+	tests := []struct {
+		name    string
+		profile Profile
+		css     string
+		want    string
+	}{
+		{"default", Default, css, css},
+		{"no CSS", Default, "", ""},
+		{
+			name:    "fonts dropped",
+			profile: Profile{},
+			css:     css,
+			want:    "body { color: #222; }\n",
+		},
+		{
+			name:    "margins and quirks",
+			profile: Profile{EmbedFonts: true, Margins: "0", CSS: "img { max-width: 100%; }"},
+			css:     "body { color: #222; }",
+			want:    "body { color: #222; }\nbody { margin: 0; }\nimg { max-width: 100%; }\n",
+		},
+		{
+			name:    "device rules only",
+			profile: Profile{Margins: "1em"},
+			css:     "",
+			want:    "body { margin: 1em; }\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.StyleSheet(tt.css); got != tt.want {
+				t.Errorf("StyleSheet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoad verifies user-defined profiles are read from the config file and
+// start from the profiles they extend
+func TestLoad(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "devices.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		return path
+	}
+
+	kindle := Builtin["kindle"]
+	smallKindle := kindle
+	smallKindle.Extends = "kindle"
+	smallKindle.Images = withMaxDimension(kindle.Images, 800)
+	smallKindle.Images.Formats = []string{"image/png"}
+
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]Profile
+		wantErr bool
+	}{
+		{
+			name:   "new profile",
+			config: `{"devices": {"boox": {"description": "Onyx Boox", "images": {"maxDimension": 1680, "grayscale": true}, "margins": "2em"}}}`,
+			want: map[string]Profile{"boox": {
+				Description: "Onyx Boox",
+				Images:      imaging.Options{MaxDimension: 1680, Grayscale: true},
+				Margins:     "2em",
+			}},
+		},
+		{
+			name:   "extends a built-in profile",
+			config: `{"devices": {"small-kindle": {"extends": "kindle", "images": {"maxDimension": 800, "formats": ["image/png"]}}}}`,
+			want:   map[string]Profile{"small-kindle": smallKindle, "kindle": kindle},
+		},
+		{
+			name:   "replaces a built-in profile",
+			config: `{"devices": {"kindle": {"extends": "kindle", "images": {"maxDimension": 800, "formats": ["image/png"]}}}}`,
+			want:   map[string]Profile{"kindle": smallKindle},
+		},
+		{
+			name: "extends a user-defined profile",
+			config: `{"devices": {
+                "a": {"extends": "b", "margins": "3em"},
+                "b": {"epubVersion": 2, "margins": "1em"}
+            }}`,
+			want: map[string]Profile{
+				"a": {Extends: "b", EPUBVersion: 2, Margins: "3em"},
+				"b": {EPUBVersion: 2, Margins: "1em"},
+			},
+		},
+		{
+			name:    "unknown base",
+			config:  `{"devices": {"a": {"extends": "walkman"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "cycle",
+			config:  `{"devices": {"a": {"extends": "b"}, "b": {"extends": "a"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "first format can't be written",
+			config:  `{"devices": {"a": {"images": {"formats": ["image/webp", "image/png"]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			config:  `{"devices": {"a": {"margins": 0}}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles, err := Load(write(t, tt.config), false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := profiles[name]; !reflect.DeepEqual(got, want) {
+					t.Errorf("profile %s = %+v, want %+v", name, got, want)
+				}
+			}
+		})
+	}
+
+	if got := Builtin["kindle"].Images.Formats; !reflect.DeepEqual(got, einkImages.Formats) {
+		t.Errorf("built-in kindle formats changed to %v", got)
+	}
+}
+
+// TestLoad_MissingFile verifies a missing config file is only an error
+// when it was asked for
+func TestLoad_MissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "devices.json")
+
+	profiles, err := Load(missing, true)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(profiles, Builtin) {
+		t.Errorf("Load() = %v, want the built-in profiles", profiles)
+	}
+
+	if _, err := Load(missing, false); err == nil {
+		t.Error("Load() should fail when the config file is missing")
+	}
+}
+
+// TestLookup verifies profiles are found regardless of case
+func TestLookup(t *testing.T) {
+	got, err := Lookup(Builtin, "Apple-Books")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, Builtin["apple-books"]) {
+		t.Errorf("Lookup() = %+v, want apple-books", got)
+	}
+
+	if _, err := Lookup(Builtin, "walkman"); err == nil {
+		t.Error("Lookup() should fail for an unknown device")
+	}
+}
@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+)
+
+// AssetScheme prefixes the placeholder URLs that stand in for downloaded
+// images and fonts in chapter content and CSS
+const AssetScheme = "asset:"
+
+// Asset is a downloaded image or font, kept as binary data rather than
+// inlined in the chapter content or CSS
+type Asset struct {
+	// Name is unique to the asset's content and ends in its extension
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// NewAsset returns an asset for image or font data, named by its content
+// hash so repeated files share a name
+func NewAsset(data []byte, mediaType string) Asset {
+	sum := sha256.Sum256(data)
+	return Asset{
+		Name:      hex.EncodeToString(sum[:])[:16] + Extension(mediaType),
+		MediaType: mediaType,
+		Data:      data,
+	}
+}
+
+// URL returns the placeholder URL the chapter refers to the asset by
+func (a Asset) URL() string {
+	return AssetScheme + a.Name
+}
+
+// Extension returns the file extension for an image or font media type
+func Extension(mediaType string) string {
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	case "image/webp":
+		return ".webp"
+	case "font/woff2":
+		return ".woff2"
+	case "font/woff":
+		return ".woff"
+	case "font/ttf":
+		return ".ttf"
+	case "font/otf":
+		return ".otf"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".img"
+}
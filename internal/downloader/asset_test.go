@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNewAsset verifies assets are named by content and media type
+func TestNewAsset(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		mediaType string
+		wantExt   string
+	}{
+		{"PNG", "png data", "image/png", ".png"},
+		{"JPEG", "jpeg data", "image/jpeg", ".jpg"},
+		{"SVG", "<svg/>", "image/svg+xml", ".svg"},
+		{"WOFF2", "wOF2", "font/woff2", ".woff2"},
+		{"unknown", "data", "application/x-unknown", ".img"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset := NewAsset([]byte(tt.data), tt.mediaType)
+			if !strings.HasSuffix(asset.Name, tt.wantExt) || len(asset.Name) != 16+len(tt.wantExt) {
+				t.Errorf("Name = %q, want 16 hex digits and %s", asset.Name, tt.wantExt)
+			}
+			if asset.URL() != AssetScheme+asset.Name {
+				t.Errorf("URL() = %q, want %q", asset.URL(), AssetScheme+asset.Name)
+			}
+			if again := NewAsset([]byte(tt.data), tt.mediaType); again.Name != asset.Name {
+				t.Errorf("same content named %q and %q", asset.Name, again.Name)
+			}
+		})
+	}
+}
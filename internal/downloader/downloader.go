@@ -1,23 +1,35 @@
 package downloader
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
 	"golang.org/x/net/html"
 )
 
 const baseURL = "https://basecamp.com/shapeup"
 
 type Downloader struct {
-	client  *http.Client
-	mainCSS string
+	client   *http.Client
+	mainCSS  string
+	metadata Metadata
+	images   imaging.Options
+	// stylesheets caches downloaded stylesheets by URL
+	stylesheets map[string]stylesheet
+	// Warnings receives problems that don't stop the download, such as
+	// fonts that can't be embedded
+	Warnings io.Writer
+	// EmbedUncheckedFonts embeds fonts whose license can't be read, such
+	// as WOFF2 ones, instead of leaving them out
+	EmbedUncheckedFonts bool
 }
 
 func New() *Downloader {
@@ -25,13 +37,23 @@ func New() *Downloader {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		Warnings: os.Stderr,
 	}
 }
 
+// SetImageOptions sets how downloaded images are prepared for the device
+// the book will be read on
+func (d *Downloader) SetImageOptions(opts imaging.Options) {
+	d.images = opts
+}
+
 type Chapter struct {
-	URL      string
-	Title    string
-	Content  string
+	URL     string
+	Title   string
+	Content string
+	// Assets holds the images in Content and the fonts and images in CSS,
+	// by the placeholder URLs that refer to them
+	Assets   map[string]Asset
 	CSS      string
 	Sections []Section
 	Number   int
@@ -87,7 +109,7 @@ func (d *Downloader) FetchTOC() ([]Chapter, error) {
 	}
 
 	// Fetch the main web-book CSS
-	mainCSS, err := d.fetchCSS(doc)
+	mainCSS, _, err := d.fetchCSS(doc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch main CSS: %w", err)
 	}
@@ -95,6 +117,9 @@ func (d *Downloader) FetchTOC() ([]Chapter, error) {
 	// Store the main CSS for later use
 	d.mainCSS = mainCSS
 
+	// Collect what the page says about the book
+	d.metadata = extractMetadata(doc, resp.Header)
+
 	var chapters []Chapter
 	chapterNumber := 1 // Start with 2 for the first chapter as index 1 will be the TOC
 
@@ -156,7 +181,7 @@ func (d *Downloader) FetchChapter(chapter Chapter) (*Chapter, error) {
 	}
 
 	// Fetch CSS
-	css, err := d.fetchCSS(doc)
+	css, cssAssets, err := d.fetchCSS(doc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch CSS: %w", err)
 	}
@@ -180,9 +205,13 @@ func (d *Downloader) FetchChapter(chapter Chapter) (*Chapter, error) {
 	}
 
 	// Process images before converting to string
-	if err := d.processImages(mainContent); err != nil {
+	assets, err := d.processImages(mainContent)
+	if err != nil {
 		return nil, fmt.Errorf("failed to process images: %w", err)
 	}
+	for src, asset := range cssAssets {
+		assets[src] = asset
+	}
 
 	// Convert main content to string
 	var content strings.Builder
@@ -194,6 +223,7 @@ func (d *Downloader) FetchChapter(chapter Chapter) (*Chapter, error) {
 		URL:     chapter.URL,
 		Title:   chapter.Title,
 		Content: content.String(),
+		Assets:  assets,
 		CSS:     css,
 		Number:  chapter.Number, // Preserve the chapter number
 	}, nil
@@ -224,7 +254,7 @@ func getAttr(n *html.Node, key string) string {
 	return ""
 }
 
-func (d *Downloader) downloadImage(url string) (string, error) {
+func (d *Downloader) downloadImage(url string) (Asset, error) {
 	// Handle relative URLs by converting to absolute
 	if strings.HasPrefix(url, "/") {
 		url = "https://basecamp.com" + url
@@ -232,40 +262,70 @@ func (d *Downloader) downloadImage(url string) (string, error) {
 
 	resp, err := d.client.Get(url)
 	if err != nil {
-		return "", fmt.Errorf("failed to download image: %w", err)
+		return Asset{}, fmt.Errorf("failed to download image: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Asset{}, fmt.Errorf("failed to download image %s: HTTP %d", url, resp.StatusCode)
+	}
+
 	// Read image data
 	imageData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read image data: %w", err)
+		return Asset{}, fmt.Errorf("failed to read image data: %w", err)
+	}
+
+	// Determine MIME type from the data, as servers don't always label
+	// images correctly. Sniffing doesn't recognise SVG, so fall back to
+	// what the server or the file name says.
+	mimeType := http.DetectContentType(imageData)
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mimeType == "" {
+			mimeType, _, _ = mime.ParseMediaType(mime.TypeByExtension(path.Ext(url)))
+		}
 	}
 
-	// Determine MIME type
-	mimeType := resp.Header.Get("Content-Type")
-	if mimeType == "" {
-		mimeType = mime.TypeByExtension(path.Ext(url))
+	processed, processedType, err := imaging.Process(imageData, mimeType, d.images)
+	switch {
+	case errors.Is(err, imaging.ErrUndisplayable):
+		return Asset{}, fmt.Errorf("failed to prepare image %s: %w", url, err)
+	case err != nil:
+		fmt.Fprintf(d.Warnings, "warning: keeping image %s as it is: %v\n", url, err)
+	default:
+		imageData, mimeType = processed, processedType
 	}
 
-	// Convert to base64
-	b64Data := base64.StdEncoding.EncodeToString(imageData)
-	return fmt.Sprintf("data:%s;base64,%s", mimeType, b64Data), nil
+	return NewAsset(imageData, mimeType), nil
 }
-func (d *Downloader) processImages(doc *html.Node) error {
+
+// processImages downloads the images in doc, points their src attributes
+// at placeholder URLs and returns the images by those URLs. Images the
+// device can't display are left out with a warning.
+func (d *Downloader) processImages(doc *html.Node) (map[string]Asset, error) {
+	assets := make(map[string]Asset)
+	var undisplayable []*html.Node
+
 	var processNode func(*html.Node) error
 	processNode = func(n *html.Node) error {
 		if n.Type == html.ElementNode && n.Data == "img" {
 			// Find src attribute
 			for i, attr := range n.Attr {
 				if attr.Key == "src" {
-					// Download and convert image
-					b64URL, err := d.downloadImage(attr.Val)
+					// Download the image
+					asset, err := d.downloadImage(attr.Val)
+					if errors.Is(err, imaging.ErrUndisplayable) {
+						fmt.Fprintf(d.Warnings, "warning: leaving out image %s: %v\n", attr.Val, err)
+						undisplayable = append(undisplayable, n)
+						break
+					}
 					if err != nil {
 						return err
 					}
 					// Update src attribute
-					n.Attr[i].Val = b64URL
+					n.Attr[i].Val = asset.URL()
+					assets[asset.URL()] = asset
 					break
 				}
 			}
@@ -280,12 +340,18 @@ func (d *Downloader) processImages(doc *html.Node) error {
 		return nil
 	}
 
-	return processNode(doc)
+	if err := processNode(doc); err != nil {
+		return nil, err
+	}
+	for _, n := range undisplayable {
+		n.Parent.RemoveChild(n)
+	}
+	return assets, nil
 }
 
-func (d *Downloader) fetchCSS(doc *html.Node) (string, error) {
-	var cssContent string
-
+// fetchCSS downloads the page's stylesheet, with the stylesheets it
+// imports inlined and the fonts and images it refers to as assets
+func (d *Downloader) fetchCSS(doc *html.Node) (string, map[string]Asset, error) {
 	// Find CSS link in head
 	cssLink := findNode(doc, func(n *html.Node) bool {
 		if n.Type == html.ElementNode && n.Data == "link" {
@@ -300,7 +366,7 @@ func (d *Downloader) fetchCSS(doc *html.Node) (string, error) {
 	})
 
 	if cssLink == nil {
-		return "", fmt.Errorf("could not find CSS link")
+		return "", nil, fmt.Errorf("could not find CSS link")
 	}
 
 	// Get href attribute and handle relative URLs
@@ -316,18 +382,9 @@ func (d *Downloader) fetchCSS(doc *html.Node) (string, error) {
 		}
 	}
 
-	// Download CSS
-	resp, err := d.client.Get(cssURL)
+	s, err := d.loadStylesheet(cssURL)
 	if err != nil {
-		return "", fmt.Errorf("failed to download CSS: %w", err)
+		return "", nil, err
 	}
-	defer resp.Body.Close()
-
-	css, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read CSS: %w", err)
-	}
-
-	cssContent = string(css)
-	return cssContent, nil
+	return s.css, s.assets, nil
 }
@@ -1,8 +1,16 @@
 package downloader
 
 import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
+	"golang.org/x/net/html"
 )
 
 func TestDownloader_FetchChapter(t *testing.T) {
@@ -132,3 +140,167 @@ func TestDownloader_FetchChapter_Errors(t *testing.T) {
 		})
 	}
 }
+
+// TestDownloader_DownloadImage verifies downloaded images go through the
+// image pipeline and are kept as binary assets
+func TestDownloader_DownloadImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png; charset=binary")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	d := New()
+	d.SetImageOptions(imaging.Options{MaxDimension: 100})
+	asset, err := d.downloadImage(server.URL + "/figure.png")
+	if err != nil {
+		t.Fatalf("downloadImage() error = %v", err)
+	}
+
+	if asset.MediaType != "image/png" || !strings.HasSuffix(asset.Name, ".png") {
+		t.Errorf("downloadImage() = %s (%s), want a PNG", asset.Name, asset.MediaType)
+	}
+	if !strings.HasPrefix(asset.URL(), AssetScheme) {
+		t.Errorf("URL() = %q, want an %s placeholder", asset.URL(), AssetScheme)
+	}
+	data := asset.Data
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	if config.Width != 100 || config.Height != 50 {
+		t.Errorf("image size = %dx%d, want 100x50", config.Width, config.Height)
+	}
+}
+
+// TestDownloader_DownloadImageResponses verifies failed responses are
+// rejected, the media type is sniffed from the data and images that can't
+// be prepared for the device are kept as they are
+func TestDownloader_DownloadImageResponses(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"/>`
+
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantErr     bool
+		wantType    string
+		wantWarning bool
+	}{
+		{"not found", http.StatusNotFound, "text/html", "<h1>Not found</h1>", true, "", false},
+		{"mislabelled", http.StatusOK, "text/html", buf.String(), false, "image/png", false},
+		{"svg", http.StatusOK, "image/svg+xml", svg, false, "image/svg+xml", false},
+		{"undecodable", http.StatusOK, "image/png", "not a png", false, "image/png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			var warnings bytes.Buffer
+			d := New()
+			d.Warnings = &warnings
+			d.SetImageOptions(imaging.Options{MaxDimension: 5})
+
+			asset, err := d.downloadImage(server.URL + "/figure")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if asset.MediaType != tt.wantType {
+				t.Errorf("downloadImage() media type = %q, want %q", asset.MediaType, tt.wantType)
+			}
+			if (warnings.Len() > 0) != tt.wantWarning {
+				t.Errorf("downloadImage() warnings = %q, want warning %v", warnings.String(), tt.wantWarning)
+			}
+			if tt.wantWarning && string(asset.Data) != tt.body {
+				t.Errorf("downloadImage() data = %q, want the original image", asset.Data)
+			}
+		})
+	}
+}
+
+// TestDownloader_ProcessImages verifies images are replaced by placeholder
+// URLs and each distinct image is kept once
+func TestDownloader_ProcessImages(t *testing.T) {
+	images := map[string][]byte{"/a.png": []byte("image a"), "/b.png": []byte("image b")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(images[r.URL.Path])
+	}))
+	defer server.Close()
+
+	doc, err := html.Parse(strings.NewReader(`<main><img src="` + server.URL + `/a.png"><p><img src="` + server.URL + `/b.png"></p><img src="` + server.URL + `/a.png"></main>`))
+	if err != nil {
+		t.Fatalf("failed to parse HTML: %v", err)
+	}
+
+	assets, err := New().processImages(doc)
+	if err != nil {
+		t.Fatalf("processImages() error = %v", err)
+	}
+	if len(assets) != 2 {
+		t.Fatalf("processImages() returned %d assets, want 2", len(assets))
+	}
+
+	a, b := NewAsset(images["/a.png"], "image/png"), NewAsset(images["/b.png"], "image/png")
+	var srcs []string
+	for _, img := range findAllNodes(doc, isElement("img")) {
+		src := getAttr(img, "src")
+		srcs = append(srcs, src)
+		if string(assets[src].Data) == "" {
+			t.Errorf("no asset for %s", src)
+		}
+	}
+	want := []string{a.URL(), b.URL(), a.URL()}
+	if strings.Join(srcs, " ") != strings.Join(want, " ") {
+		t.Errorf("img srcs = %v, want %v", srcs, want)
+	}
+}
+
+// TestDownloader_ProcessImages_Undisplayable verifies images the device
+// can't display are left out with a warning
+func TestDownloader_ProcessImages_Undisplayable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"/>`))
+	}))
+	defer server.Close()
+
+	doc, err := html.Parse(strings.NewReader(`<main><p>Sketch <img src="` + server.URL + `/sketch.svg"></p></main>`))
+	if err != nil {
+		t.Fatalf("failed to parse HTML: %v", err)
+	}
+
+	var warnings bytes.Buffer
+	d := New()
+	d.Warnings = &warnings
+	d.SetImageOptions(imaging.Options{Formats: []string{"image/png"}})
+
+	assets, err := d.processImages(doc)
+	if err != nil {
+		t.Fatalf("processImages() error = %v", err)
+	}
+	if len(assets) != 0 || len(findAllNodes(doc, isElement("img"))) != 0 {
+		t.Errorf("processImages() kept the SVG: %d assets", len(assets))
+	}
+	if !strings.Contains(warnings.String(), "sketch.svg") {
+		t.Errorf("warnings = %q, want one about the SVG", warnings.String())
+	}
+}
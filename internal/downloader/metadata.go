@@ -0,0 +1,262 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/imaging"
+	"golang.org/x/net/html"
+)
+
+// Metadata describes the book as a whole
+type Metadata struct {
+	Title       string
+	Subtitle    string
+	Description string
+	Author      string
+	Publisher   string
+	ISBN        string
+	// Date is the publication date as YYYY, YYYY-MM or YYYY-MM-DD
+	Date      string
+	Language  string
+	Copyright string
+	// Cover is the URL or path of the cover image
+	Cover string
+	// CoverImage is the cover image as a data URI, once FetchCover has
+	// found it
+	CoverImage string
+	// URL is the canonical address of the book online
+	URL string
+	// Modified is when the source page last changed, if the server said
+	Modified time.Time
+}
+
+// DefaultMetadata describes Shape Up as published by Basecamp. It fills in
+// anything the source page and the command line leave out.
+var DefaultMetadata = Metadata{
+	Title:       "Shape Up",
+	Subtitle:    "Stop Running in Circles and Ship Work that Matters",
+	Description: "Stop Running in Circles and Ship Work that Matters",
+	Author:      "Ryan Singer",
+	Publisher:   "Basecamp",
+	Language:    "en",
+	Cover:       "https://basecamp.com/assets/images/books/shapeup/cover_summary.jpeg",
+	URL:         baseURL,
+}
+
+// copyrightYear matches the year in a copyright notice
+var copyrightYear = regexp.MustCompile(`(?:©|\(c\)|[Cc]opyright)\s*(?:[Cc]opyright\s*)?(\d{4})`)
+
+// Merge returns m with its empty fields taken from fallback
+func (m Metadata) Merge(fallback Metadata) Metadata {
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&m.Title, fallback.Title)
+	fill(&m.Subtitle, fallback.Subtitle)
+	fill(&m.Description, fallback.Description)
+	fill(&m.Author, fallback.Author)
+	fill(&m.Publisher, fallback.Publisher)
+	fill(&m.ISBN, fallback.ISBN)
+	fill(&m.Date, fallback.Date)
+	fill(&m.Language, fallback.Language)
+	fill(&m.Copyright, fallback.Copyright)
+	fill(&m.Cover, fallback.Cover)
+	fill(&m.CoverImage, fallback.CoverImage)
+	fill(&m.URL, fallback.URL)
+	if m.Modified.IsZero() {
+		m.Modified = fallback.Modified
+	}
+	return m
+}
+
+// Metadata returns the book metadata found on the TOC page by FetchTOC
+func (d *Downloader) Metadata() Metadata {
+	return d.metadata
+}
+
+// FetchCover returns the cover image at a URL or local path as a data URI.
+// Anything that isn't an image is an error, so a moved cover can't end up
+// as an error page on the front of the book.
+func (d *Downloader) FetchCover(src string) (string, error) {
+	var data []byte
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := d.client.Get(src)
+		if err != nil {
+			return "", fmt.Errorf("failed to download cover: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download cover: HTTP %d", resp.StatusCode)
+		}
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("failed to read cover: %w", err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(src)
+		if err != nil {
+			return "", fmt.Errorf("failed to read cover: %w", err)
+		}
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", fmt.Errorf("cover %s is not an image (%s)", src, mimeType)
+	}
+	data, mimeType, err := imaging.Process(data, mimeType, d.images)
+	if err != nil {
+		return "", fmt.Errorf("failed to optimize cover: %w", err)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// extractMetadata collects what the TOC page says about the book: the
+// landing title block, <meta> tags, the canonical link, the copyright
+// notice and the Last-Modified header. Relative URLs are resolved against
+// the site.
+func extractMetadata(doc *html.Node, header http.Header) Metadata {
+	var m Metadata
+
+	meta := make(map[string]string)
+	for _, n := range findAllNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "meta"
+	}) {
+		key := strings.ToLower(getAttr(n, "name"))
+		if key == "" {
+			key = strings.ToLower(getAttr(n, "property"))
+		}
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = strings.TrimSpace(getAttr(n, "content"))
+		}
+	}
+
+	// The landing block on the page is the most specific source
+	m.Title = classText(doc, "landing-title")
+	m.Subtitle = classText(doc, "landing-subtitle")
+	m.Author = strings.TrimPrefix(classText(doc, "landing-author"), "by ")
+
+	if m.Title == "" {
+		title := meta["og:title"]
+		if title == "" {
+			if n := findNode(doc, isElement("title")); n != nil {
+				title = normalizeSpace(extractText(n))
+			}
+		}
+		// "Shape Up: Stop Running in Circles..." is a title and subtitle
+		if before, after, found := strings.Cut(title, ": "); found && m.Subtitle == "" {
+			m.Title, m.Subtitle = before, after
+		} else {
+			m.Title = title
+		}
+	}
+	if m.Author == "" {
+		m.Author = meta["author"]
+	}
+
+	m.Description = meta["description"]
+	if m.Description == "" {
+		m.Description = meta["og:description"]
+	}
+	m.Publisher = meta["og:site_name"]
+	m.Cover = absoluteURL(meta["og:image"])
+
+	if n := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "link" && getAttr(n, "rel") == "canonical"
+	}); n != nil {
+		m.URL = absoluteURL(getAttr(n, "href"))
+	}
+	if m.URL == "" {
+		m.URL = absoluteURL(meta["og:url"])
+	}
+
+	if n := findNode(doc, isElement("html")); n != nil {
+		m.Language = getAttr(n, "lang")
+	}
+
+	m.Copyright = meta["copyright"]
+	if m.Copyright == "" {
+		if n := findNode(doc, func(n *html.Node) bool {
+			return n.Type == html.ElementNode && (hasClass(n, "copyright") || n.Data == "footer") &&
+				copyrightYear.MatchString(extractText(n))
+		}); n != nil {
+			m.Copyright = normalizeSpace(extractText(n))
+		}
+	}
+
+	for _, key := range []string{"article:published_time", "dc.date", "dcterms.date", "date"} {
+		if date := publicationDate(meta[key]); date != "" {
+			m.Date = date
+			break
+		}
+	}
+	if match := copyrightYear.FindStringSubmatch(m.Copyright); m.Date == "" && match != nil {
+		m.Date = match[1]
+	}
+
+	if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		m.Modified = modified
+	}
+
+	return m
+}
+
+// publicationDate returns the date part of a timestamp as YYYY-MM-DD, or ""
+// if it isn't one
+func publicationDate(value string) string {
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(layout[:min(len(layout), len("2006-01-02"))])
+		}
+	}
+	return ""
+}
+
+// classText returns the text of the first element with a class
+func classText(doc *html.Node, class string) string {
+	if n := findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && hasClass(n, class)
+	}); n != nil {
+		return normalizeSpace(extractText(n))
+	}
+	return ""
+}
+
+// absoluteURL resolves a site-relative URL
+func absoluteURL(href string) string {
+	if strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "//") {
+		return "https://basecamp.com" + href
+	}
+	return href
+}
+
+func isElement(tag string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == tag
+	}
+}
+
+func findAllNodes(n *html.Node, criteria func(*html.Node) bool) []*html.Node {
+	var nodes []*html.Node
+	if criteria(n) {
+		nodes = append(nodes, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, findAllNodes(c, criteria)...)
+	}
+	return nodes
+}
+
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
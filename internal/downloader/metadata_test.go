@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// TestExtractMetadata verifies book metadata is read from the TOC page
+func TestExtractMetadata(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		page   string
+		header http.Header
+		want   Metadata
+	}{
+		{
+			name: "landing block and meta tags",
+			page: `<html lang="en-US"><head>
+                <title>Shape Up: Stop Running in Circles and Ship Work that Matters</title>
+                <meta name="description" content="A book about product development.">
+                <meta property="og:site_name" content="Basecamp">
+                <meta property="og:image" content="/assets/images/books/shapeup/cover.png">
+                <link rel="canonical" href="https://basecamp.com/shapeup">
+                </head><body>
+                <div class="content">
+                  <h1 class="landing-title">Shape Up</h1>
+                  <p class="landing-subtitle">Stop Running in Circles<br> and Ship Work that Matters</p>
+                  <p class="landing-author"><em>by Ryan Singer</em></p>
+                </div>
+                <footer>© 2019 Basecamp</footer>
+                </body></html>`,
+			header: http.Header{"Last-Modified": {modified.Format(http.TimeFormat)}},
+			want: Metadata{
+				Title:       "Shape Up",
+				Subtitle:    "Stop Running in Circles and Ship Work that Matters",
+				Description: "A book about product development.",
+				Author:      "Ryan Singer",
+				Publisher:   "Basecamp",
+				Date:        "2019",
+				Language:    "en-US",
+				Copyright:   "© 2019 Basecamp",
+				Cover:       "https://basecamp.com/assets/images/books/shapeup/cover.png",
+				URL:         "https://basecamp.com/shapeup",
+				Modified:    modified,
+			},
+		},
+		{
+			name: "title element and published time",
+			page: `<html><head>
+                <title>Shape Up: Stop Running in Circles</title>
+                <meta name="author" content="Ryan Singer">
+                <meta property="og:url" content="/shapeup">
+                <meta property="article:published_time" content="2019-07-01T09:00:00Z">
+                <meta name="copyright" content="Copyright 2019 Basecamp">
+                </head><body></body></html>`,
+			want: Metadata{
+				Title:     "Shape Up",
+				Subtitle:  "Stop Running in Circles",
+				Author:    "Ryan Singer",
+				Date:      "2019-07-01",
+				Copyright: "Copyright 2019 Basecamp",
+				URL:       "https://basecamp.com/shapeup",
+			},
+		},
+		{
+			name: "nothing to find",
+			page: `<html><body><p>Hello</p></body></html>`,
+			want: Metadata{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.page))
+			if err != nil {
+				t.Fatalf("failed to parse page: %v", err)
+			}
+
+			got := extractMetadata(doc, tt.header)
+			if got != tt.want {
+				t.Errorf("extractMetadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMetadata_Merge verifies empty fields are filled from the fallback
+func TestMetadata_Merge(t *testing.T) {
+	overrides := Metadata{Title: "Shape Up (2nd edition)", ISBN: "9780000000000"}
+	page := Metadata{Title: "Shape Up", Publisher: "Basecamp", Date: "2019"}
+
+	got := overrides.Merge(page).Merge(DefaultMetadata)
+
+	want := DefaultMetadata
+	want.Title = "Shape Up (2nd edition)"
+	want.ISBN = "9780000000000"
+	want.Date = "2019"
+	if got != want {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+}
+
+// TestDownloader_FetchCover verifies covers are read from URLs and files as
+// data URIs, and that anything other than an image is rejected
+func TestDownloader_FetchCover(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover.png":
+			w.Write(png)
+		case "/page":
+			w.Write([]byte("<html><body>Moved</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(file, png, 0644); err != nil {
+		t.Fatalf("failed to write cover: %v", err)
+	}
+
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{"URL", server.URL + "/cover.png", want, false},
+		{"file", file, want, false},
+		{"not found", server.URL + "/missing.png", "", true},
+		{"not an image", server.URL + "/page", "", true},
+		{"missing file", filepath.Join(t.TempDir(), "missing.png"), "", true},
+	}
+
+	d := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.FetchCover(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchCover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FetchCover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/fonts"
+)
+
+var (
+	// cssImport matches an @import rule with its URL and media query
+	cssImport = regexp.MustCompile(`@import\s+(?:url\(\s*["']?([^"')]+?)["']?\s*\)|["']([^"']+)["'])\s*([^;]*);`)
+	// cssURL matches a url() reference
+	cssURL = regexp.MustCompile(`url\(\s*["']?([^"')]+?)["']?\s*\)`)
+	// fontFace matches an @font-face rule
+	fontFace = regexp.MustCompile(`@font-face\s*\{[^}]*\}`)
+	// fontFamily matches the family name an @font-face rule declares
+	fontFamily = regexp.MustCompile(`font-family\s*:\s*["']?([^;"'}]+)`)
+)
+
+// fontTypes maps font file extensions to media types, since servers often
+// send fonts as application/octet-stream
+var fontTypes = map[string]string{
+	".woff2": "font/woff2",
+	".woff":  "font/woff",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+}
+
+// stylesheet is a downloaded stylesheet with the stylesheets it imports
+// inlined, and the fonts and images it refers to as assets
+type stylesheet struct {
+	css    string
+	assets map[string]Asset
+}
+
+// loadStylesheet downloads the stylesheet at href, resolving its @import
+// chain and downloading its fonts and images. Every chapter links to the
+// same stylesheet, so each is downloaded once.
+func (d *Downloader) loadStylesheet(href string) (stylesheet, error) {
+	if s, ok := d.stylesheets[href]; ok {
+		return s, nil
+	}
+
+	css, err := d.resolveImports(href, make(map[string]bool))
+	if err != nil {
+		return stylesheet{}, err
+	}
+
+	s := stylesheet{assets: make(map[string]Asset)}
+	var out strings.Builder
+	last := 0
+	for _, loc := range fontFace.FindAllStringIndex(css, -1) {
+		out.WriteString(d.embedImages(css[last:loc[0]], s.assets))
+		out.WriteString(d.embedFont(css[loc[0]:loc[1]], s.assets))
+		last = loc[1]
+	}
+	out.WriteString(d.embedImages(css[last:], s.assets))
+	s.css = out.String()
+
+	if d.stylesheets == nil {
+		d.stylesheets = make(map[string]stylesheet)
+	}
+	d.stylesheets[href] = s
+	return s, nil
+}
+
+// resolveImports downloads the stylesheet at href and replaces its @import
+// rules with the stylesheets they import. Its url() references are made
+// absolute, since the CSS no longer lives at its own address. A stylesheet
+// imported more than once is only included the first time.
+func (d *Downloader) resolveImports(href string, seen map[string]bool) (string, error) {
+	if seen[href] {
+		return "", nil
+	}
+	seen[href] = true
+
+	base, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid CSS URL %s: %w", href, err)
+	}
+	data, _, err := d.get(href)
+	if err != nil {
+		return "", fmt.Errorf("failed to download CSS: %w", err)
+	}
+
+	css := cssURL.ReplaceAllStringFunc(string(data), func(ref string) string {
+		target, err := base.Parse(cssURL.FindStringSubmatch(ref)[1])
+		if err != nil || target.Scheme == "data" {
+			return ref
+		}
+		return `url("` + target.String() + `")`
+	})
+
+	var importErr error
+	css = cssImport.ReplaceAllStringFunc(css, func(rule string) string {
+		m := cssImport.FindStringSubmatch(rule)
+		target, err := base.Parse(m[1] + m[2])
+		if err != nil {
+			if importErr == nil {
+				importErr = fmt.Errorf("invalid CSS import %s: %w", m[1]+m[2], err)
+			}
+			return ""
+		}
+		imported, err := d.resolveImports(target.String(), seen)
+		if err != nil {
+			if importErr == nil {
+				importErr = err
+			}
+			return ""
+		}
+		if media := strings.TrimSpace(m[3]); media != "" {
+			return "@media " + media + " {\n" + imported + "\n}"
+		}
+		return imported
+	})
+	return css, importErr
+}
+
+// embedFont downloads the fonts an @font-face rule refers to. The rule is
+// dropped, with a warning, if the font's license doesn't allow embedding,
+// or can't be read and EmbedUncheckedFonts isn't set.
+func (d *Downloader) embedFont(rule string, assets map[string]Asset) string {
+	family := "unnamed"
+	if m := fontFamily.FindStringSubmatch(rule); m != nil {
+		family = strings.TrimSpace(m[1])
+	}
+
+	found := make(map[string]Asset)
+	checked := false
+	for _, m := range cssURL.FindAllStringSubmatch(rule, -1) {
+		src := m[1]
+		if !isRemote(src) {
+			continue
+		}
+		font, err := d.downloadFont(src)
+		if err != nil {
+			fmt.Fprintf(d.Warnings, "warning: not embedding font %q from %s: %v\n", family, src, err)
+			continue
+		}
+
+		// The sources are the same font in different formats, so one that
+		// can be read speaks for all of them
+		embeddable, err := fonts.Embeddable(font.Data)
+		switch {
+		case errors.Is(err, fonts.ErrUnsupported):
+		case err != nil:
+			fmt.Fprintf(d.Warnings, "warning: not embedding font %q from %s: %v\n", family, src, err)
+			continue
+		case !embeddable:
+			fmt.Fprintf(d.Warnings, "warning: skipping font %q: its license doesn't allow embedding\n", family)
+			return ""
+		default:
+			checked = true
+		}
+		found[src] = font
+	}
+	if len(found) > 0 && !checked {
+		if !d.EmbedUncheckedFonts {
+			fmt.Fprintf(d.Warnings, "warning: skipping font %q: couldn't read its license (--embed-unchecked-fonts embeds it anyway)\n", family)
+			return ""
+		}
+		fmt.Fprintf(d.Warnings, "warning: couldn't read the license of font %q; embedding it anyway\n", family)
+	}
+
+	return cssURL.ReplaceAllStringFunc(rule, func(ref string) string {
+		font, ok := found[cssURL.FindStringSubmatch(ref)[1]]
+		if !ok {
+			return ref
+		}
+		assets[font.URL()] = font
+		return `url("` + font.URL() + `")`
+	})
+}
+
+// embedImages downloads the images, such as backgrounds, that css refers to
+func (d *Downloader) embedImages(css string, assets map[string]Asset) string {
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		src := cssURL.FindStringSubmatch(ref)[1]
+		if !isRemote(src) {
+			return ref
+		}
+		image, err := d.downloadImage(src)
+		if err != nil {
+			fmt.Fprintf(d.Warnings, "warning: not embedding CSS image %s: %v\n", src, err)
+			return ref
+		}
+		assets[image.URL()] = image
+		return `url("` + image.URL() + `")`
+	})
+}
+
+// downloadFont downloads a font, taking its media type from the file
+// extension before the Content-Type header
+func (d *Downloader) downloadFont(src string) (Asset, error) {
+	data, contentType, err := d.get(src)
+	if err != nil {
+		return Asset{}, err
+	}
+
+	var mediaType string
+	if u, err := url.Parse(src); err == nil {
+		mediaType = fontTypes[strings.ToLower(path.Ext(u.Path))]
+	}
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+	return NewAsset(data, mediaType), nil
+}
+
+// get downloads a URL and returns its body and Content-Type
+func (d *Downloader) get(src string) ([]byte, string, error) {
+	resp, err := d.client.Get(src)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: HTTP %d", src, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+func isRemote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
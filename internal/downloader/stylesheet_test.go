@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// restrictedFont returns the Go font with its OS/2 fsType set to forbid
+// embedding
+func restrictedFont(t *testing.T) []byte {
+	t.Helper()
+	font := bytes.Clone(goregular.TTF)
+	numTables := int(binary.BigEndian.Uint16(font[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + i*16
+		if string(font[record:record+4]) == "OS/2" {
+			offset := int(binary.BigEndian.Uint32(font[record+8:]))
+			binary.BigEndian.PutUint16(font[offset+8:], 0x0002)
+			return font
+		}
+	}
+	t.Fatal("Go font has no OS/2 table")
+	return nil
+}
+
+// TestDownloader_LoadStylesheet verifies the @import chain is inlined,
+// fonts and background images become assets, and fonts whose license
+// forbids embedding or can't be read are dropped with a warning
+func TestDownloader_LoadStylesheet(t *testing.T) {
+	files := map[string]string{
+		"/css/main.css": `@import url("base.css");
+@import "print.css" print;
+body { background: url(../img/bg.png); }`,
+		"/css/base.css": `@import "main.css";
+@font-face { font-family: "Free"; src: url(../fonts/free.woff2) format("woff2"), url(../fonts/free.ttf) format("truetype"); }
+@font-face { font-family: Locked; src: url(/fonts/locked.ttf); }
+@font-face { font-family: Unchecked; src: url("../fonts/unchecked.woff2"); }
+.logo { background: url(data:image/png;base64,AAAA); }`,
+		"/css/print.css":         `body { color: black; }`,
+		"/img/bg.png":            "background",
+		"/fonts/free.woff2":      "wOF2 free",
+		"/fonts/free.ttf":        string(goregular.TTF),
+		"/fonts/locked.ttf":      string(restrictedFont(t)),
+		"/fonts/unchecked.woff2": "wOF2 unchecked",
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/img/") {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	var warnings bytes.Buffer
+	d := New()
+	d.Warnings = &warnings
+
+	s, err := d.loadStylesheet(server.URL + "/css/main.css")
+	if err != nil {
+		t.Fatalf("loadStylesheet() error = %v", err)
+	}
+
+	woff2 := NewAsset([]byte(files["/fonts/free.woff2"]), "font/woff2")
+	ttf := NewAsset(goregular.TTF, "font/ttf")
+	bg := NewAsset([]byte(files["/img/bg.png"]), "image/png")
+
+	wantCSS := []string{
+		`url("` + woff2.URL() + `") format("woff2"), url("` + ttf.URL() + `") format("truetype")`,
+		"@media print {\nbody { color: black; }\n}",
+		`body { background: url("` + bg.URL() + `"); }`,
+		`url(data:image/png;base64,AAAA)`,
+	}
+	for _, want := range wantCSS {
+		if !strings.Contains(s.css, want) {
+			t.Errorf("CSS missing %s:\n%s", want, s.css)
+		}
+	}
+	if strings.Contains(s.css, "Locked") || strings.Contains(s.css, "Unchecked") || strings.Contains(s.css, "@import") {
+		t.Errorf("CSS should have no restricted or unchecked font or @import rules:\n%s", s.css)
+	}
+
+	for _, asset := range []Asset{woff2, ttf, bg} {
+		if got, ok := s.assets[asset.URL()]; !ok || got.MediaType != asset.MediaType {
+			t.Errorf("assets[%s] = %+v, want %s", asset.URL(), got, asset.MediaType)
+		}
+	}
+	if len(s.assets) != 3 {
+		t.Errorf("loadStylesheet() returned %d assets, want 3", len(s.assets))
+	}
+
+	for _, want := range []string{
+		`skipping font "Locked": its license doesn't allow embedding`,
+		`skipping font "Unchecked": couldn't read its license`,
+	} {
+		if !strings.Contains(warnings.String(), want) {
+			t.Errorf("warnings missing %q:\n%s", want, warnings.String())
+		}
+	}
+	if strings.Contains(warnings.String(), `"Free"`) {
+		t.Errorf("Free font should embed without a warning:\n%s", warnings.String())
+	}
+
+	// The stylesheet is downloaded once
+	before := requests
+	if _, err := d.loadStylesheet(server.URL + "/css/main.css"); err != nil {
+		t.Fatalf("loadStylesheet() error = %v", err)
+	}
+	if requests != before {
+		t.Errorf("loadStylesheet() made %d more requests for a cached stylesheet", requests-before)
+	}
+
+	// Fonts whose license can't be read are embedded when asked for
+	warnings.Reset()
+	d = New()
+	d.Warnings = &warnings
+	d.EmbedUncheckedFonts = true
+	s, err = d.loadStylesheet(server.URL + "/css/main.css")
+	if err != nil {
+		t.Fatalf("loadStylesheet() error = %v", err)
+	}
+	unchecked := NewAsset([]byte(files["/fonts/unchecked.woff2"]), "font/woff2")
+	if !strings.Contains(s.css, `url("`+unchecked.URL()+`")`) || len(s.assets) != 4 {
+		t.Errorf("loadStylesheet() left out the unchecked font:\n%s", s.css)
+	}
+	if !strings.Contains(warnings.String(), `couldn't read the license of font "Unchecked"; embedding it anyway`) {
+		t.Errorf("warnings missing the unchecked font:\n%s", warnings.String())
+	}
+}
+
+// TestDownloader_LoadStylesheet_MissingImport verifies a broken @import
+// chain is an error
+func TestDownloader_LoadStylesheet_MissingImport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/main.css" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`@import "missing.css"; body { color: black; }`))
+	}))
+	defer server.Close()
+
+	if _, err := New().loadStylesheet(server.URL + "/main.css"); err == nil {
+		t.Error("loadStylesheet() should fail when an import is missing")
+	}
+}
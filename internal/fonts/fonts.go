@@ -0,0 +1,141 @@
+// Package fonts reads the embedding permissions a font declares in its
+// OS/2 table, so only fonts whose license allows it are embedded in a book.
+package fonts
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupported is returned for fonts in a format whose tables can't be
+// read, such as Brotli-compressed WOFF2
+var ErrUnsupported = errors.New("unsupported font format")
+
+// fsType flags, from the OpenType OS/2 table
+const (
+	// usageMask covers the usage permission bits. Only one is meant to be
+	// set; if several are, the least restrictive applies.
+	usageMask = 0x000f
+	// restrictedLicense forbids embedding the font at all
+	restrictedLicense = 0x0002
+	// bitmapOnly allows embedding only the font's bitmaps, not its outlines
+	bitmapOnly = 0x0200
+)
+
+// Embeddable reports whether a TrueType, OpenType or WOFF font's license
+// allows embedding it in a document. A font without an OS/2 table places
+// no restrictions.
+func Embeddable(data []byte) (bool, error) {
+	fsType, err := embeddingFlags(data)
+	if err != nil {
+		return false, err
+	}
+	return fsType&usageMask != restrictedLicense && fsType&bitmapOnly == 0, nil
+}
+
+// embeddingFlags returns the fsType field of a font's OS/2 table
+func embeddingFlags(data []byte) (uint16, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("font is too short")
+	}
+
+	var os2 []byte
+	var err error
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true":
+		os2, err = sfntTable(data, 0, "OS/2")
+	case "ttcf":
+		// The fonts in a collection share a license; read the first
+		if len(data) < 16 {
+			return 0, fmt.Errorf("font collection is too short")
+		}
+		os2, err = sfntTable(data, int(binary.BigEndian.Uint32(data[12:])), "OS/2")
+	case "wOFF":
+		os2, err = woffTable(data, "OS/2")
+	case "wOF2":
+		return 0, fmt.Errorf("WOFF2: %w", ErrUnsupported)
+	default:
+		return 0, ErrUnsupported
+	}
+	if err != nil {
+		return 0, err
+	}
+	if os2 == nil {
+		return 0, nil
+	}
+	if len(os2) < 10 {
+		return 0, fmt.Errorf("OS/2 table is too short")
+	}
+	return binary.BigEndian.Uint16(os2[8:]), nil
+}
+
+// sfntTable returns the table with a tag from the TrueType or OpenType
+// font starting at offset, or nil if the font has no such table
+func sfntTable(data []byte, offset int, tag string) ([]byte, error) {
+	if offset < 0 || offset+12 > len(data) {
+		return nil, fmt.Errorf("font header is out of range")
+	}
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+
+	for i := 0; i < numTables; i++ {
+		record := offset + 12 + i*16
+		if record+16 > len(data) {
+			return nil, fmt.Errorf("font table directory is out of range")
+		}
+		if string(data[record:record+4]) != tag {
+			continue
+		}
+		start := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if start+length > len(data) {
+			return nil, fmt.Errorf("font table %s is out of range", tag)
+		}
+		return data[start : start+length], nil
+	}
+	return nil, nil
+}
+
+// woffTable returns the table with a tag from a WOFF font, decompressing
+// it if needed, or nil if the font has no such table
+func woffTable(data []byte, tag string) ([]byte, error) {
+	if len(data) < 44 {
+		return nil, fmt.Errorf("WOFF header is too short")
+	}
+	numTables := int(binary.BigEndian.Uint16(data[12:]))
+
+	for i := 0; i < numTables; i++ {
+		entry := 44 + i*20
+		if entry+20 > len(data) {
+			return nil, fmt.Errorf("WOFF table directory is out of range")
+		}
+		if string(data[entry:entry+4]) != tag {
+			continue
+		}
+		start := int(binary.BigEndian.Uint32(data[entry+4:]))
+		compLength := int(binary.BigEndian.Uint32(data[entry+8:]))
+		origLength := int(binary.BigEndian.Uint32(data[entry+12:]))
+		if start+compLength > len(data) {
+			return nil, fmt.Errorf("WOFF table %s is out of range", tag)
+		}
+		table := data[start : start+compLength]
+		if compLength == origLength {
+			return table, nil
+		}
+
+		r, err := zlib.NewReader(bytes.NewReader(table))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress WOFF table %s: %w", tag, err)
+		}
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, int64(origLength)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress WOFF table %s: %w", tag, err)
+		}
+		return out, nil
+	}
+	return nil, nil
+}
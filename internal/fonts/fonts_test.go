@@ -0,0 +1,103 @@
+package fonts
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// sfnt returns a TrueType font with only an OS/2 table, or no tables at
+// all if os2 is false
+func sfnt(fsType uint16, os2 bool) []byte {
+	table := make([]byte, 78)
+	binary.BigEndian.PutUint16(table[8:], fsType)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 1, 0, 0})
+	if !os2 {
+		buf.Write(make([]byte, 8))
+		return buf.Bytes()
+	}
+	binary.Write(&buf, binary.BigEndian, []uint16{1, 16, 0, 0})
+	buf.WriteString("OS/2")
+	binary.Write(&buf, binary.BigEndian, []uint32{0, 28, uint32(len(table))})
+	buf.Write(table)
+	return buf.Bytes()
+}
+
+// woff returns a WOFF font with a compressed OS/2 table
+func woff(fsType uint16) []byte {
+	table := make([]byte, 78)
+	binary.BigEndian.PutUint16(table[8:], fsType)
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(table)
+	w.Close()
+
+	header := make([]byte, 44)
+	copy(header, "wOFF")
+	binary.BigEndian.PutUint16(header[12:], 1)
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteString("OS/2")
+	binary.Write(&buf, binary.BigEndian, []uint32{64, uint32(compressed.Len()), uint32(len(table)), 0})
+	buf.Write(compressed.Bytes())
+	return buf.Bytes()
+}
+
+// TestEmbeddable verifies the OS/2 embedding permissions are read from
+// TrueType and WOFF fonts
+func TestEmbeddable(t *testing.T) {
+	tests := []struct {
+		name    string
+		font    []byte
+		want    bool
+		wantErr error
+	}{
+		{"installable", sfnt(0x0000, true), true, nil},
+		{"restricted", sfnt(0x0002, true), false, nil},
+		{"preview and print", sfnt(0x0004, true), true, nil},
+		{"editable", sfnt(0x0008, true), true, nil},
+		{"restricted and editable", sfnt(0x000a, true), true, nil},
+		{"bitmap only", sfnt(0x0208, true), false, nil},
+		{"no OS/2 table", sfnt(0, false), true, nil},
+		{"Go font", goregular.TTF, true, nil},
+		{"WOFF installable", woff(0x0000), true, nil},
+		{"WOFF restricted", woff(0x0002), false, nil},
+		{"WOFF2", []byte("wOF2" + string(make([]byte, 44))), false, ErrUnsupported},
+		{"not a font", []byte("<html>"), false, ErrUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Embeddable(tt.font)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Embeddable() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Embeddable() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Embeddable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEmbeddable_Truncated verifies damaged fonts are reported rather than
+// read out of range
+func TestEmbeddable_Truncated(t *testing.T) {
+	for _, font := range [][]byte{sfnt(0, true)[:20], woff(0)[:50], []byte("ttcf")} {
+		if _, err := Embeddable(font); err == nil {
+			t.Errorf("Embeddable(%q) should fail", font)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Icon fits an image into a square PNG of the given size, centered over a
+// background of the color along its edges, and returns the PNG with that
+// color
+func Icon(data []byte, size int) ([]byte, color.RGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, color.RGBA{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	background := edgeColor(img)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() >= b.Dy() {
+		h = max(b.Dy()*size/b.Dx(), 1)
+	} else {
+		w = max(b.Dx()*size/b.Dy(), 1)
+	}
+	target := image.Rect((size-w)/2, (size-h)/2, (size-w)/2+w, (size-h)/2+h)
+	draw.CatmullRom.Scale(dst, target, img, b, draw.Over, nil)
+
+	out, err := encode(dst, "image/png", 0)
+	if err != nil {
+		return nil, color.RGBA{}, err
+	}
+	return out, background, nil
+}
+
+// edgeColor returns the average opaque color of the pixels around img's
+// border, or white if it has none
+func edgeColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	var r, g, bl, n uint64
+	add := func(x, y int) {
+		cr, cg, cb, ca := img.At(x, y).RGBA()
+		if ca == 0 {
+			return
+		}
+		// Undo the alpha premultiplication
+		r += uint64(cr) * 0xffff / uint64(ca)
+		g += uint64(cg) * 0xffff / uint64(ca)
+		bl += uint64(cb) * 0xffff / uint64(ca)
+		n++
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		add(x, b.Min.Y)
+		add(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		add(b.Min.X, y)
+		add(b.Max.X-1, y)
+	}
+	if n == 0 {
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}
+	}
+	return color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff}
+}
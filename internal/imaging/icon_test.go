@@ -0,0 +1,57 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// TestIcon verifies images are letterboxed into a square over their edge
+// color
+func TestIcon(t *testing.T) {
+	cover := image.NewNRGBA(image.Rect(0, 0, 100, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 100; x++ {
+			cover.Set(x, y, color.NRGBA{R: 0x1d, G: 0x2d, B: 0x35, A: 255})
+		}
+	}
+	cover.Set(50, 80, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	tests := []struct {
+		name string
+		data []byte
+		size int
+		want color.RGBA
+	}{
+		{"tall cover", encodeTest(t, cover, "image/png"), 192, color.RGBA{0x1d, 0x2d, 0x35, 0xff}},
+		{"transparent", encodeTest(t, image.NewNRGBA(image.Rect(0, 0, 10, 10)), "image/png"), 48, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, background, err := Icon(tt.data, tt.size)
+			if err != nil {
+				t.Fatalf("Icon() error = %v", err)
+			}
+			if background != tt.want {
+				t.Errorf("Icon() background = %v, want %v", background, tt.want)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Icon() isn't a PNG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.size || b.Dy() != tt.size {
+				t.Errorf("Icon() is %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.size, tt.size)
+			}
+			if got := color.RGBAModel.Convert(img.At(0, 0)); got != tt.want {
+				t.Errorf("Icon() corner = %v, want the background %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := Icon([]byte("<svg/>"), 192); err == nil {
+		t.Error("Icon() should fail for an image it can't decode")
+	}
+}
@@ -0,0 +1,205 @@
+// Package imaging prepares downloaded images for the device a book will be
+// read on: it downscales them, optionally turns them into dithered
+// grayscale for e-ink screens, and re-encodes them in a format the device
+// can display.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Options describe how images are prepared for a device
+type Options struct {
+	// MaxDimension is the longest side an image may have, in pixels. Zero
+	// leaves images at their original size.
+	MaxDimension int `json:"maxDimension,omitempty"`
+	// Grayscale converts images to GrayLevels shades of gray
+	Grayscale  bool `json:"grayscale,omitempty"`
+	GrayLevels int  `json:"grayLevels,omitempty"`
+	// Dither applies Floyd-Steinberg error diffusion when reducing colors
+	Dither bool `json:"dither,omitempty"`
+	// Quality is the JPEG quality, 1-100. Zero keeps JPEGs as they are
+	// unless they need changing for another reason.
+	Quality int `json:"quality,omitempty"`
+	// Formats lists the media types the device can display. Images in
+	// other formats are converted to the first one. Empty means any.
+	Formats []string `json:"formats,omitempty"`
+}
+
+// ErrUndisplayable is returned for an image the device can't display and
+// Process can't convert, such as an SVG for a reader that only shows
+// bitmaps
+var ErrUndisplayable = errors.New("the device can't display this image")
+
+// decodable lists the media types Process can decode. Anything else, such
+// as SVG, is passed through untouched if the device can display it.
+var decodable = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Process prepares an image for a device and returns it with its media
+// type, which changes if the image had to be converted. Images Process
+// can't decode are returned as they are, or with ErrUndisplayable if the
+// device can't display them. An animated GIF keeps only its first frame
+// once it has to be re-encoded.
+func Process(data []byte, mediaType string, opts Options) ([]byte, string, error) {
+	if !decodable[mediaType] && !opts.displays(mediaType) {
+		return nil, "", fmt.Errorf("%w: %s", ErrUndisplayable, mediaType)
+	}
+	if !decodable[mediaType] || opts.isZero() {
+		return data, mediaType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	target := mediaType
+	if !opts.displays(mediaType) {
+		target = opts.Formats[0]
+	}
+
+	changed := target != mediaType
+	if scaled := downscale(img, opts.MaxDimension); scaled != img {
+		img, changed = scaled, true
+	}
+	if opts.Grayscale {
+		img, changed = toGray(img, opts.GrayLevels, opts.Dither), true
+		// JPEG compression smears a dither pattern
+		if opts.Dither && target == "image/jpeg" && opts.displays("image/png") {
+			target = "image/png"
+		}
+	}
+	if !changed && (target != "image/jpeg" || opts.Quality == 0) {
+		return data, mediaType, nil
+	}
+
+	out, err := encode(img, target, opts.Quality)
+	if err != nil {
+		return nil, "", err
+	}
+	// Re-encoding an image that needed nothing else can make it bigger
+	if !changed && len(out) >= len(data) {
+		return data, mediaType, nil
+	}
+	return out, target, nil
+}
+
+// isZero reports whether o leaves images as they are
+func (o Options) isZero() bool {
+	return o.MaxDimension == 0 && !o.Grayscale && o.Quality == 0 && len(o.Formats) == 0
+}
+
+// displays reports whether the device can show images of a media type
+func (o Options) displays(mediaType string) bool {
+	if len(o.Formats) == 0 {
+		return true
+	}
+	for _, format := range o.Formats {
+		if format == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// downscale returns img scaled down so neither side is longer than limit,
+// or img itself if it already fits
+func downscale(img image.Image, limit int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if limit <= 0 || (w <= limit && h <= limit) {
+		return img
+	}
+
+	if w >= h {
+		w, h = limit, h*limit/w
+	} else {
+		w, h = w*limit/h, limit
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// toGray converts img to levels evenly spaced shades of gray. With fewer
+// than 2 or more than 256 levels, all 256 are used.
+func toGray(img image.Image, levels int, dither bool) image.Image {
+	if levels < 2 || levels > 256 {
+		levels = 256
+	}
+
+	palette := make(color.Palette, levels)
+	for i := range palette {
+		palette[i] = color.Gray{Y: uint8(i * 255 / (levels - 1))}
+	}
+
+	dst := image.NewPaletted(img.Bounds(), palette)
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), flatten(img), img.Bounds().Min, draw.Src)
+	if dither {
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), gray, gray.Bounds().Min)
+	} else {
+		draw.Draw(dst, dst.Bounds(), gray, gray.Bounds().Min, draw.Src)
+	}
+	return dst
+}
+
+// Encodable reports whether Process can write images in a media type, and
+// so convert images to it
+func Encodable(mediaType string) bool {
+	switch mediaType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// encode writes img in the given media type
+func encode(img image.Image, mediaType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case "image/jpeg":
+		if quality <= 0 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	case "image/png":
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("cannot encode images as %s", mediaType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten draws img over a white background, so transparent parts of
+// diagrams don't turn black in formats without transparency
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,207 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a width x height image with a color gradient on the
+// left half and transparency on the right
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeTest(t *testing.T, img image.Image, mediaType string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch mediaType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s: %v", mediaType, err)
+	}
+	return buf.Bytes()
+}
+
+// TestProcess verifies images are scaled, converted to grayscale and
+// re-encoded as each profile asks
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name       string
+		mediaType  string
+		size       image.Point
+		opts       Options
+		wantType   string
+		wantSize   image.Point
+		wantGray   bool
+		wantSmall  bool
+		wantSameAs bool
+	}{
+		{
+			name:       "no options",
+			mediaType:  "image/png",
+			size:       image.Pt(400, 200),
+			wantType:   "image/png",
+			wantSize:   image.Pt(400, 200),
+			wantSameAs: true,
+		},
+		{
+			name:      "downscaled keeping aspect ratio",
+			mediaType: "image/png",
+			size:      image.Pt(400, 200),
+			opts:      Options{MaxDimension: 100},
+			wantType:  "image/png",
+			wantSize:  image.Pt(100, 50),
+		},
+		{
+			name:       "already small enough",
+			mediaType:  "image/png",
+			size:       image.Pt(80, 160),
+			opts:       Options{MaxDimension: 200},
+			wantType:   "image/png",
+			wantSize:   image.Pt(80, 160),
+			wantSameAs: true,
+		},
+		{
+			name:      "portrait downscaled",
+			mediaType: "image/jpeg",
+			size:      image.Pt(200, 400),
+			opts:      Options{MaxDimension: 100, Quality: 60},
+			wantType:  "image/jpeg",
+			wantSize:  image.Pt(50, 100),
+		},
+		{
+			name:      "JPEG re-encoded at a lower quality",
+			mediaType: "image/jpeg",
+			size:      image.Pt(400, 200),
+			opts:      Options{Quality: 30},
+			wantType:  "image/jpeg",
+			wantSize:  image.Pt(400, 200),
+			wantSmall: true,
+		},
+		{
+			name:      "e-ink grayscale with dithering",
+			mediaType: "image/png",
+			size:      image.Pt(400, 200),
+			opts:      Options{MaxDimension: 1648, Grayscale: true, GrayLevels: 16, Dither: true, Quality: 75, Formats: []string{"image/jpeg", "image/png"}},
+			wantType:  "image/png",
+			wantSize:  image.Pt(400, 200),
+			wantGray:  true,
+		},
+		{
+			name:      "dithered JPEG stored as PNG",
+			mediaType: "image/jpeg",
+			size:      image.Pt(400, 200),
+			opts:      Options{Grayscale: true, GrayLevels: 16, Dither: true, Formats: []string{"image/jpeg", "image/png"}},
+			wantType:  "image/png",
+			wantSize:  image.Pt(400, 200),
+			wantGray:  true,
+		},
+		{
+			name:      "format the device can't display",
+			mediaType: "image/gif",
+			size:      image.Pt(40, 20),
+			opts:      Options{Formats: []string{"image/jpeg"}},
+			wantType:  "image/jpeg",
+			wantSize:  image.Pt(40, 20),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeTest(t, testImage(tt.size.X, tt.size.Y), tt.mediaType)
+
+			got, gotType, err := Process(data, tt.mediaType, tt.opts)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if gotType != tt.wantType {
+				t.Errorf("Process() media type = %s, want %s", gotType, tt.wantType)
+			}
+			if tt.wantSameAs && !bytes.Equal(got, data) {
+				t.Error("Process() changed an image that needed no changes")
+			}
+			if tt.wantSmall && len(got) >= len(data) {
+				t.Errorf("Process() = %d bytes, want fewer than %d", len(got), len(data))
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("failed to decode result: %v", err)
+			}
+			if "image/"+format != tt.wantType {
+				t.Errorf("result is %s, want %s", format, tt.wantType)
+			}
+			if size := img.Bounds().Size(); size != tt.wantSize {
+				t.Errorf("result size = %v, want %v", size, tt.wantSize)
+			}
+
+			if tt.wantGray {
+				levels := make(map[uint32]bool)
+				for y := 0; y < tt.wantSize.Y; y++ {
+					for x := 0; x < tt.wantSize.X; x++ {
+						r, g, b, _ := img.At(x, y).RGBA()
+						if r != g || g != b {
+							t.Fatalf("pixel (%d, %d) is not gray", x, y)
+						}
+						levels[r] = true
+					}
+				}
+				if len(levels) > tt.opts.GrayLevels {
+					t.Errorf("result has %d shades of gray, want at most %d", len(levels), tt.opts.GrayLevels)
+				}
+				// The transparent half of a PNG is white, not black
+				if r, _, _, _ := img.At(tt.wantSize.X-1, 0).RGBA(); tt.mediaType == "image/png" && r != 0xffff {
+					t.Errorf("transparent pixel = %#x, want white", r)
+				}
+			}
+		})
+	}
+}
+
+// TestProcess_Passthrough verifies images that can't be decoded are left
+// alone if the device displays them and rejected if it doesn't, unless
+// they claim to be a format that can, and that no options means no changes
+func TestProcess_Passthrough(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)
+	for _, formats := range [][]string{nil, {"image/png", "image/svg+xml"}} {
+		got, gotType, err := Process(svg, "image/svg+xml", Options{MaxDimension: 100, Formats: formats})
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		if !bytes.Equal(got, svg) || gotType != "image/svg+xml" {
+			t.Errorf("Process() = %q, %s, want the SVG unchanged", got, gotType)
+		}
+	}
+
+	if _, _, err := Process(svg, "image/svg+xml", Options{Formats: []string{"image/png"}}); !errors.Is(err, ErrUndisplayable) {
+		t.Errorf("Process() error = %v, want ErrUndisplayable for an SVG on a bitmap-only device", err)
+	}
+
+	if _, _, err := Process([]byte("not a png"), "image/png", Options{MaxDimension: 100}); err == nil {
+		t.Error("Process() should fail on a corrupt PNG")
+	}
+
+	got, gotType, err := Process([]byte("not a png"), "image/png", Options{})
+	if err != nil || string(got) != "not a png" || gotType != "image/png" {
+		t.Errorf("Process() = %q, %s, %v, want the image unchanged", got, gotType, err)
+	}
+}
@@ -0,0 +1,173 @@
+// Package search builds the compact full-text index behind the HTML
+// output's search box. Terms are stemmed the same way by Stem here and by
+// the stem function in search.js, so queries typed in the browser meet the
+// terms indexed here.
+package search
+
+import (
+	_ "embed"
+	"encoding/json"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Script is the search box's JavaScript and CSS its stylesheet. The script
+// reads the index from the JSON script element with the id IndexElementID.
+var (
+	//go:embed search.js
+	Script string
+	//go:embed search.css
+	CSS string
+)
+
+// IndexElementID is the id of the script element holding the index
+const IndexElementID = "search-index"
+
+// snippetLength is the most characters of a section's text kept as its
+// snippet
+const snippetLength = 160
+
+// Section is a searchable part of the book: a chapter's opening or one of
+// its headed sections
+type Section struct {
+	// ID is the section's anchor on the page
+	ID      string
+	Chapter string
+	Title   string
+	Text    string
+}
+
+// Index maps stemmed terms to the sections they appear in
+type Index struct {
+	Sections []Entry `json:"sections"`
+	// Terms holds, for each term, the indexes into Sections where it
+	// appears, in book order
+	Terms map[string][]int `json:"terms"`
+}
+
+// Entry is what a search result shows for a section
+type Entry struct {
+	ID      string `json:"id"`
+	Chapter string `json:"chapter"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+// Build indexes the sections' titles and text
+func Build(sections []Section) Index {
+	index := Index{Sections: []Entry{}, Terms: make(map[string][]int)}
+	for _, section := range sections {
+		i := len(index.Sections)
+		index.Sections = append(index.Sections, Entry{
+			ID:      section.ID,
+			Chapter: section.Chapter,
+			Title:   section.Title,
+			Snippet: snippet(section.Text),
+		})
+		for _, term := range Terms(section.Title + " " + section.Text) {
+			postings := index.Terms[term]
+			if len(postings) == 0 || postings[len(postings)-1] != i {
+				index.Terms[term] = append(postings, i)
+			}
+		}
+	}
+	return index
+}
+
+// JSON returns the index as JSON that's safe to put in a script element
+func (ix Index) JSON() (string, error) {
+	// json.Marshal escapes <, > and &, so the index can't close the
+	// element
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// snippet returns the start of text, cut at a word
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= snippetLength {
+		return text
+	}
+	cut := strings.LastIndexByte(text[:snippetLength], ' ')
+	if cut <= 0 {
+		cut = snippetLength
+	}
+	return strings.TrimRight(text[:cut], ",;:.") + "…"
+}
+
+// stopWords are too common to be worth indexing
+var stopWords = map[string]bool{
+	"a": true, "about": true, "all": true, "an": true, "and": true, "are": true,
+	"as": true, "at": true, "be": true, "been": true, "but": true, "by": true,
+	"can": true, "do": true, "for": true, "from": true, "had": true, "has": true,
+	"have": true, "how": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "its": true, "not": true, "of": true, "on": true, "or": true,
+	"our": true, "so": true, "than": true, "that": true, "the": true, "their": true,
+	"them": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "those": true, "to": true, "was": true, "we": true, "were": true,
+	"what": true, "when": true, "which": true, "will": true, "with": true,
+	"you": true, "your": true,
+}
+
+// Terms splits text into lowercase words, drops stop words and words of one
+// character, and stems the rest. Each term is returned once, in the order
+// it first appears.
+func Terms(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 2 || stopWords[word] {
+			continue
+		}
+		if term := Stem(word); !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Stem reduces a lowercase English word to a stem shared by its plural,
+// -ing, -ed and -ly forms, so "shapes", "shaping" and "shaped" all become
+// "shap". It's much lighter than Porter's algorithm, so search.js can
+// repeat it exactly.
+func Stem(word string) string {
+	// Only ASCII words are stemmed, where Go and JavaScript agree on length
+	if len(word) <= 3 || strings.IndexFunc(word, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+		if !ok {
+			continue
+		}
+		if len(stem) >= 3 && strings.ContainsAny(stem, "aeiouy") {
+			word = stem
+			// Undo the doubled consonant of "betting" and "stopped"
+			if n := len(word); word[n-1] == word[n-2] && !strings.ContainsRune("aeiouylsz", rune(word[n-1])) {
+				word = word[:n-1]
+			}
+		}
+		break
+	}
+
+	if len(word) >= 4 && strings.HasSuffix(word, "e") {
+		word = word[:len(word)-1]
+	}
+	return word
+}
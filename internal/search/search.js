@@ -0,0 +1,174 @@
+// Search box for the single-page HTML book. It reads the index built by
+// the search package from the #search-index element; tokenize and stem
+// must match Terms and Stem there.
+(function () {
+  "use strict";
+
+  var maxResults = 20;
+  var stopWords = {};
+  ("a about all an and are as at be been but by can do for from had has " +
+    "have how if in into is it its not of on or our so than that the their " +
+    "them then there these they this those to was we were what when which " +
+    "will with you your").split(" ").forEach(function (w) { stopWords[w] = true; });
+
+  function stem(word) {
+    if (word.length <= 3 || /[^\x00-\x7f]/.test(word)) {
+      return word;
+    }
+
+    if (/sses$/.test(word)) {
+      word = word.slice(0, -2);
+    } else if (/ies$/.test(word) && word.length > 4) {
+      word = word.slice(0, -3) + "y";
+    } else if (/s$/.test(word) && !/(ss|us|is)$/.test(word)) {
+      word = word.slice(0, -1);
+    }
+
+    var suffixes = ["ingly", "edly", "ing", "ed", "ly"];
+    for (var i = 0; i < suffixes.length; i++) {
+      var suffix = suffixes[i];
+      if (word.slice(-suffix.length) !== suffix) {
+        continue;
+      }
+      var s = word.slice(0, -suffix.length);
+      if (s.length >= 3 && /[aeiouy]/.test(s)) {
+        word = s;
+        var n = word.length;
+        if (word[n - 1] === word[n - 2] && "aeiouylsz".indexOf(word[n - 1]) < 0) {
+          word = word.slice(0, -1);
+        }
+      }
+      break;
+    }
+
+    if (word.length >= 4 && /e$/.test(word)) {
+      word = word.slice(0, -1);
+    }
+    return word;
+  }
+
+  // tokenize returns the query's stemmed terms in order, keeping repeats
+  function tokenize(text) {
+    var terms = [];
+    text.toLowerCase().split(/[^\p{L}\p{N}]+/u).forEach(function (word) {
+      if (Array.from(word).length < 2 || stopWords[word]) {
+        return;
+      }
+      terms.push(stem(word));
+    });
+    return terms;
+  }
+
+  // postings returns the sections containing a term. While the last word
+  // is being typed it matches as a prefix, of the word as typed.
+  function postings(index, term, word, prefix) {
+    if (!prefix) {
+      return index.terms[term] || [];
+    }
+    var found = {};
+    Object.keys(index.terms).forEach(function (t) {
+      if (t === term || t.indexOf(word) === 0) {
+        index.terms[t].forEach(function (i) { found[i] = true; });
+      }
+    });
+    return Object.keys(found).map(Number).sort(function (a, b) { return a - b; });
+  }
+
+  // search returns the sections holding every term of the query, those
+  // whose title matches first
+  function search(index, query) {
+    var words = query.toLowerCase().trim().split(/\s+/);
+    var terms = tokenize(query);
+    if (terms.length === 0) {
+      return [];
+    }
+    var lastWord = words[words.length - 1];
+    var typing = !/\s$/.test(query) && stem(lastWord) === terms[terms.length - 1];
+
+    var result = null;
+    terms.forEach(function (term, i) {
+      var list = postings(index, term, lastWord, typing && i === terms.length - 1);
+      result = result === null ? list : result.filter(function (s) { return list.indexOf(s) >= 0; });
+    });
+
+    var sections = result.map(function (i) { return index.sections[i]; });
+    var inTitle = function (section) {
+      var title = tokenize(section.title);
+      return terms.every(function (t) { return title.indexOf(t) >= 0; });
+    };
+    return sections.filter(inTitle).concat(sections.filter(function (s) { return !inTitle(s); }));
+  }
+
+  function render(list, sections, query) {
+    list.textContent = "";
+    if (query.trim() === "") {
+      return;
+    }
+    if (sections.length === 0) {
+      var empty = document.createElement("li");
+      empty.className = "search-empty";
+      empty.textContent = "No matches";
+      list.appendChild(empty);
+      return;
+    }
+    sections.slice(0, maxResults).forEach(function (section) {
+      var item = document.createElement("li");
+      var link = document.createElement("a");
+      link.href = "#" + section.id;
+      var title = document.createElement("strong");
+      title.textContent = section.title;
+      link.appendChild(title);
+      if (section.chapter && section.chapter !== section.title) {
+        var chapter = document.createElement("span");
+        chapter.className = "search-chapter";
+        chapter.textContent = section.chapter;
+        link.appendChild(chapter);
+      }
+      var snippet = document.createElement("span");
+      snippet.className = "search-snippet";
+      snippet.textContent = section.snippet;
+      link.appendChild(snippet);
+      item.appendChild(link);
+      list.appendChild(item);
+    });
+  }
+
+  function init() {
+    var data = document.getElementById("search-index");
+    var input = document.getElementById("search-input");
+    var list = document.getElementById("search-results");
+    if (!data || !input || !list) {
+      return;
+    }
+    var index = JSON.parse(data.textContent);
+
+    input.addEventListener("input", function () {
+      render(list, search(index, input.value), input.value);
+    });
+    input.addEventListener("keydown", function (event) {
+      if (event.key === "Enter") {
+        var first = list.querySelector("a");
+        if (first) {
+          location.hash = first.getAttribute("href");
+        }
+      } else if (event.key === "Escape") {
+        input.value = "";
+        render(list, [], "");
+      }
+    });
+    document.addEventListener("keydown", function (event) {
+      if (event.key === "/" && document.activeElement !== input) {
+        event.preventDefault();
+        input.focus();
+      }
+    });
+  }
+
+  if (typeof module !== "undefined" && module.exports) {
+    module.exports = { stem: stem, tokenize: tokenize, search: search };
+  } else if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();
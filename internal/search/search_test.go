@@ -0,0 +1,166 @@
+package search
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestStem verifies the forms of a word share a stem
+func TestStem(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"shape", "shapes", "shaped", "shaping"}, "shap"},
+		{[]string{"appetite", "appetites"}, "appetit"},
+		{[]string{"breadboard", "breadboards", "breadboarding", "breadboarded"}, "breadboard"},
+		{[]string{"bet", "bets", "betting"}, "bet"},
+		{[]string{"story", "stories"}, "story"},
+		{[]string{"process", "processes"}, "process"},
+		{[]string{"need", "needs"}, "need"},
+		{[]string{"thing", "things"}, "thing"},
+		{[]string{"café"}, "café"},
+	}
+
+	for _, tt := range tests {
+		for _, word := range tt.words {
+			if got := Stem(word); got != tt.want {
+				t.Errorf("Stem(%q) = %q, want %q", word, got, tt.want)
+			}
+		}
+	}
+}
+
+// TestTerms verifies text is split, stop words dropped and each stem kept
+// once
+func TestTerms(t *testing.T) {
+	got := Terms("The circuit breaker: shaping, and shaped work's appetite! A 6-week cycle.")
+	want := []string{"circuit", "breaker", "shap", "work", "appetit", "week", "cycl"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms() = %q, want %q", got, want)
+	}
+}
+
+// TestBuild verifies each term lists the sections it appears in once, in
+// book order, and long text is cut into a snippet
+func TestBuild(t *testing.T) {
+	long := strings.Repeat("betting table ", 20)
+	index := Build([]Section{
+		{ID: "1.1", Chapter: "Principles of Shaping", Title: "Principles of Shaping", Text: "Shaped work has an appetite."},
+		{ID: "wireframes-are-too-concrete", Chapter: "Principles of Shaping", Title: "Wireframes are too concrete", Text: long},
+		{ID: "2.1", Chapter: "Set Boundaries", Title: "Set Boundaries", Text: "Appetites, not estimates. Shape the work."},
+	})
+
+	wantTerms := map[string][]int{
+		"shap":    {0, 2},
+		"appetit": {0, 2},
+		"bet":     {1},
+		"concret": {1},
+	}
+	for term, want := range wantTerms {
+		if got := index.Terms[term]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Terms[%q] = %v, want %v", term, got, want)
+		}
+	}
+
+	snippet := index.Sections[1].Snippet
+	if len(snippet) > snippetLength+len("…") || !strings.HasSuffix(snippet, "table…") {
+		t.Errorf("snippet = %q, want it cut at a word", snippet)
+	}
+	if index.Sections[0].Snippet != "Shaped work has an appetite." {
+		t.Errorf("short snippet = %q", index.Sections[0].Snippet)
+	}
+
+	data, err := index.JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	var decoded Index
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+		t.Fatalf("index JSON doesn't decode: %v", err)
+	}
+}
+
+// TestJSON verifies the index JSON can't close the script element it's
+// embedded in
+func TestJSON(t *testing.T) {
+	data, err := Build([]Section{{ID: "a", Title: "</script><b>"}}).JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	if strings.Contains(data, "</") || strings.Contains(data, "<b>") {
+		t.Errorf("JSON() = %s, want < escaped", data)
+	}
+}
+
+// TestScript_MatchesGo verifies search.js stems and searches like this
+// package. It needs Node.js, and is skipped without it.
+func TestScript_MatchesGo(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not installed")
+	}
+
+	words := []string{"shaping", "shapes", "appetites", "breadboarding", "betting", "stories", "processes", "stopped", "hills", "early", "café", "need", "uses", "used", "rabbit", "holes"}
+	index := Build([]Section{
+		{ID: "1.1", Chapter: "Principles of Shaping", Title: "Principles of Shaping", Text: "Shaped work has an appetite."},
+		{ID: "rabbit-holes", Chapter: "Find the Elements", Title: "Rabbit holes", Text: "Look for rabbit holes while breadboarding."},
+	})
+	data, err := index.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "search.js")
+	if err := os.WriteFile(script, []byte(Script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	harness := `const s = require(` + jsValue(script) + `);
+const index = ` + data + `;
+console.log(JSON.stringify({
+  stems: ` + jsValue(words) + `.map(s.stem),
+  shaping: s.search(index, "shaping").map(r => r.id),
+  rabbit: s.search(index, "rabbit hole").map(r => r.id),
+  typing: s.search(index, "breadb").map(r => r.id),
+}));`
+	out, err := exec.Command(node, "-e", harness).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node failed: %v\n%s", err, out)
+	}
+
+	var got struct {
+		Stems   []string `json:"stems"`
+		Shaping []string `json:"shaping"`
+		Rabbit  []string `json:"rabbit"`
+		Typing  []string `json:"typing"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("unexpected node output %s: %v", out, err)
+	}
+	for i, word := range words {
+		if got.Stems[i] != Stem(word) {
+			t.Errorf("stem(%q) = %q in search.js, %q in Go", word, got.Stems[i], Stem(word))
+		}
+	}
+	if !reflect.DeepEqual(got.Shaping, []string{"1.1"}) {
+		t.Errorf(`search("shaping") = %v, want [1.1]`, got.Shaping)
+	}
+	if !reflect.DeepEqual(got.Rabbit, []string{"rabbit-holes"}) {
+		t.Errorf(`search("rabbit hole") = %v, want [rabbit-holes]`, got.Rabbit)
+	}
+	if !reflect.DeepEqual(got.Typing, []string{"rabbit-holes"}) {
+		t.Errorf(`search("breadb") = %v, want [rabbit-holes]`, got.Typing)
+	}
+}
+
+// jsValue returns v as a JavaScript literal
+func jsValue(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,527 @@
+package validator
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	epubMimetype      = "application/epub+zip"
+	containerPath     = "META-INF/container.xml"
+	packageMediaType  = "application/oebps-package+xml"
+	xhtmlMediaType    = "application/xhtml+xml"
+	ncxMediaType      = "application/x-dtbncx+xml"
+	xlinkNamespace    = "http://www.w3.org/1999/xlink"
+	maxReportedLength = 80
+)
+
+// Problem is a single issue found in an EPUB. Line is 0 when the problem
+// isn't tied to a line.
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	if p.File != "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return p.Message
+}
+
+// reference is a link from one file in the EPUB to another
+type reference struct {
+	Href string
+	Line int
+	// Nav marks references from the navigation document or NCX
+	Nav bool
+}
+
+// document holds the ids and outgoing references of a parsed content
+// document
+type document struct {
+	IDs        map[string]bool
+	References []reference
+}
+
+type manifestItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type packageDocument struct {
+	Version  string         `xml:"version,attr"`
+	Manifest []manifestItem `xml:"manifest>item"`
+	Spine    struct {
+		TOC      string `xml:"toc,attr"`
+		ItemRefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type validator struct {
+	zip       *zip.Reader
+	files     map[string]*zip.File
+	documents map[string]*document
+	problems  []Problem
+}
+
+// ValidateEPUB checks an EPUB file and returns the problems found. The error
+// is only set when the file can't be read as a ZIP archive at all.
+func ValidateEPUB(filename string) ([]Problem, error) {
+	r, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	return validate(&r.Reader), nil
+}
+
+func validate(r *zip.Reader) []Problem {
+	v := &validator{
+		zip:       r,
+		files:     make(map[string]*zip.File),
+		documents: make(map[string]*document),
+	}
+	for _, f := range r.File {
+		v.files[f.Name] = f
+	}
+
+	v.checkMimetype()
+	packagePath := v.checkContainer()
+	if packagePath != "" {
+		v.checkPackage(packagePath)
+	}
+
+	return v.problems
+}
+
+func (v *validator) report(file string, line int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkMimetype checks the mimetype file is the first entry, stored
+// uncompressed and without extra fields, so readers can sniff the type
+func (v *validator) checkMimetype() {
+	if len(v.zip.File) == 0 || v.zip.File[0].Name != "mimetype" {
+		v.report("mimetype", 0, "must be the first file in the archive")
+		if v.files["mimetype"] == nil {
+			return
+		}
+	}
+
+	f := v.files["mimetype"]
+	if f.Method != zip.Store {
+		v.report("mimetype", 0, "must be stored uncompressed")
+	}
+	if len(f.Extra) > 0 {
+		v.report("mimetype", 0, "must not have extra fields in its ZIP header")
+	}
+
+	data, err := v.read("mimetype")
+	if err != nil {
+		return
+	}
+	if string(data) != epubMimetype {
+		v.report("mimetype", 0, "content is %q, expected %q", truncate(string(data)), epubMimetype)
+	}
+}
+
+// checkContainer checks META-INF/container.xml and returns the path of the
+// package document it points to
+func (v *validator) checkContainer() string {
+	data, err := v.read(containerPath)
+	if err != nil {
+		return ""
+	}
+	if !v.checkWellFormed(containerPath, data) {
+		return ""
+	}
+
+	var container struct {
+		RootFiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(data, &container); err != nil {
+		v.report(containerPath, 0, "failed to parse: %v", err)
+		return ""
+	}
+
+	for _, rootFile := range container.RootFiles {
+		if rootFile.MediaType != packageMediaType {
+			continue
+		}
+		if v.files[rootFile.FullPath] == nil {
+			v.report(containerPath, 0, "package document %s does not exist", rootFile.FullPath)
+			return ""
+		}
+		return rootFile.FullPath
+	}
+
+	v.report(containerPath, 0, "no rootfile with media type %s", packageMediaType)
+	return ""
+}
+
+// checkPackage checks the manifest and spine, then every document and image
+// they list
+func (v *validator) checkPackage(packagePath string) {
+	data, err := v.read(packagePath)
+	if err != nil || !v.checkWellFormed(packagePath, data) {
+		return
+	}
+
+	var pkg packageDocument
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		v.report(packagePath, 0, "failed to parse: %v", err)
+		return
+	}
+
+	baseDir := path.Dir(packagePath)
+	items := make(map[string]manifestItem)
+	listed := make(map[string]bool)
+	var navItems []string
+
+	for _, item := range pkg.Manifest {
+		line := elementLine(data, "id", item.ID)
+		if _, ok := items[item.ID]; ok {
+			v.report(packagePath, line, "duplicate manifest id %q", item.ID)
+		}
+		items[item.ID] = item
+
+		target, ok := resolve(baseDir, item.Href)
+		if !ok || v.files[target] == nil {
+			v.report(packagePath, line, "manifest item %q points to missing file %s", item.ID, item.Href)
+			continue
+		}
+		listed[target] = true
+
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			navItems = append(navItems, target)
+		}
+	}
+
+	// Every content file must be declared in the manifest
+	for _, f := range v.zip.File {
+		if f.Name == "mimetype" || f.Name == packagePath || strings.HasPrefix(f.Name, "META-INF/") || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if !listed[f.Name] {
+			v.report(f.Name, 0, "file is not listed in the manifest")
+		}
+	}
+
+	if len(pkg.Spine.ItemRefs) == 0 {
+		v.report(packagePath, 0, "spine is empty")
+	}
+	for _, itemref := range pkg.Spine.ItemRefs {
+		item, ok := items[itemref.IDRef]
+		if !ok {
+			v.report(packagePath, elementLine(data, "idref", itemref.IDRef), "spine references unknown manifest id %q", itemref.IDRef)
+			continue
+		}
+		if item.MediaType != xhtmlMediaType {
+			v.report(packagePath, elementLine(data, "idref", itemref.IDRef), "spine item %q has media type %s, expected %s", itemref.IDRef, item.MediaType, xhtmlMediaType)
+		}
+	}
+
+	var ncxPath string
+	if pkg.Spine.TOC != "" {
+		item, ok := items[pkg.Spine.TOC]
+		switch {
+		case !ok:
+			v.report(packagePath, 0, "spine toc references unknown manifest id %q", pkg.Spine.TOC)
+		case item.MediaType != ncxMediaType:
+			v.report(packagePath, 0, "spine toc %q has media type %s, expected %s", pkg.Spine.TOC, item.MediaType, ncxMediaType)
+		default:
+			ncxPath, _ = resolve(baseDir, item.Href)
+		}
+	} else if !strings.HasPrefix(pkg.Version, "3") {
+		v.report(packagePath, 0, "EPUB 2 spine has no toc attribute pointing at the NCX")
+	}
+
+	if strings.HasPrefix(pkg.Version, "3") && len(navItems) != 1 {
+		v.report(packagePath, 0, "EPUB 3 manifest must have exactly one nav item, found %d", len(navItems))
+	}
+
+	// Parse every content document first, so fragment ids can be checked
+	// across documents
+	var documentPaths []string
+	for _, item := range pkg.Manifest {
+		target, ok := resolve(baseDir, item.Href)
+		if !ok || v.files[target] == nil {
+			continue
+		}
+		switch {
+		case item.MediaType == xhtmlMediaType:
+			if doc := v.parseDocument(target); doc != nil {
+				v.documents[target] = doc
+				documentPaths = append(documentPaths, target)
+			}
+		case strings.HasPrefix(item.MediaType, "image/"):
+			v.checkImage(target, item.MediaType)
+		}
+	}
+
+	for _, navPath := range navItems {
+		if doc := v.documents[navPath]; doc != nil {
+			for i := range doc.References {
+				doc.References[i].Nav = true
+			}
+		}
+	}
+
+	if ncxPath != "" {
+		if doc := v.parseNCX(ncxPath); doc != nil {
+			v.documents[ncxPath] = doc
+			documentPaths = append(documentPaths, ncxPath)
+		}
+	}
+
+	sort.Strings(documentPaths)
+	for _, docPath := range documentPaths {
+		v.checkReferences(docPath, listed)
+	}
+}
+
+// parseDocument checks an XHTML document is well-formed and collects its ids
+// and references
+func (v *validator) parseDocument(name string) *document {
+	data, err := v.read(name)
+	if err != nil || !v.checkWellFormed(name, data) {
+		return nil
+	}
+
+	doc := &document{IDs: make(map[string]bool)}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, _ := decoder.InputPos()
+
+		for _, attr := range start.Attr {
+			switch {
+			case attr.Name.Local == "id" && attr.Name.Space == "":
+				if doc.IDs[attr.Value] {
+					v.report(name, line, "duplicate id %q", attr.Value)
+				}
+				doc.IDs[attr.Value] = true
+			case isReferenceAttr(start.Name.Local, attr.Name):
+				doc.References = append(doc.References, reference{Href: attr.Value, Line: line})
+			}
+		}
+	}
+
+	return doc
+}
+
+// parseNCX checks the NCX is well-formed and collects its navPoint targets
+func (v *validator) parseNCX(name string) *document {
+	data, err := v.read(name)
+	if err != nil || !v.checkWellFormed(name, data) {
+		return nil
+	}
+
+	doc := &document{IDs: make(map[string]bool)}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "content" {
+			continue
+		}
+		line, _ := decoder.InputPos()
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "src" {
+				doc.References = append(doc.References, reference{Href: attr.Value, Line: line, Nav: true})
+			}
+		}
+	}
+
+	return doc
+}
+
+// checkReferences checks every internal reference in a document points to a
+// file in the manifest and, for fragments, to an existing id
+func (v *validator) checkReferences(name string, listed map[string]bool) {
+	for _, ref := range v.documents[name].References {
+		if ref.Href == "" || isExternal(ref.Href) {
+			continue
+		}
+
+		kind := "link"
+		if ref.Nav {
+			kind = "navigation entry"
+		}
+
+		target, fragment, _ := strings.Cut(ref.Href, "#")
+		targetPath := name
+		if target != "" {
+			var ok bool
+			targetPath, ok = resolve(path.Dir(name), target)
+			if !ok {
+				v.report(name, ref.Line, "%s has an invalid href %q", kind, truncate(ref.Href))
+				continue
+			}
+		}
+
+		if v.files[targetPath] == nil {
+			v.report(name, ref.Line, "%s points to missing file %s", kind, truncate(target))
+			continue
+		}
+		if !listed[targetPath] {
+			v.report(name, ref.Line, "%s points to %s, which is not in the manifest", kind, target)
+		}
+
+		if fragment == "" {
+			continue
+		}
+		if targetDoc := v.documents[targetPath]; targetDoc != nil && !targetDoc.IDs[fragment] {
+			v.report(name, ref.Line, "%s points to missing fragment #%s in %s", kind, fragment, path.Base(targetPath))
+		}
+	}
+}
+
+// checkImage compares an image's declared media type with its content
+func (v *validator) checkImage(name, declared string) {
+	data, err := v.read(name)
+	if err != nil {
+		return
+	}
+
+	if declared == "image/svg+xml" {
+		if !bytes.Contains(data, []byte("<svg")) {
+			v.report(name, 0, "declared as image/svg+xml but does not contain an <svg> element")
+		}
+		return
+	}
+
+	detected := http.DetectContentType(data)
+	if detected != declared {
+		v.report(name, 0, "declared as %s but the content is %s", declared, detected)
+	}
+}
+
+// checkWellFormed parses an XML file and reports the first syntax error
+func (v *validator) checkWellFormed(name string, data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err == nil {
+			continue
+		}
+
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			v.report(name, syntaxErr.Line, "not well-formed: %s", syntaxErr.Msg)
+		} else {
+			v.report(name, 0, "not well-formed: %v", err)
+		}
+		return false
+	}
+}
+
+func (v *validator) read(name string) ([]byte, error) {
+	f := v.files[name]
+	if f == nil {
+		v.report(name, 0, "file is missing")
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		v.report(name, 0, "failed to open: %v", err)
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		v.report(name, 0, "failed to read: %v", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// isReferenceAttr reports whether an attribute of an element refers to
+// another file
+func isReferenceAttr(element string, attr xml.Name) bool {
+	switch {
+	case attr.Space == xlinkNamespace && attr.Local == "href":
+		return true
+	case attr.Space != "":
+		return false
+	case attr.Local == "href":
+		return element == "a" || element == "area" || element == "link" || element == "image" || element == "use"
+	case attr.Local == "src":
+		return element == "img" || element == "script" || element == "source" ||
+			element == "audio" || element == "video" || element == "iframe" || element == "embed"
+	}
+	return false
+}
+
+// isExternal reports whether href points outside the EPUB
+func isExternal(href string) bool {
+	u, err := url.Parse(href)
+	return err == nil && (u.Scheme != "" || u.Host != "")
+}
+
+// resolve returns the archive path of a relative href
+func resolve(baseDir, href string) (string, bool) {
+	unescaped, err := url.PathUnescape(href)
+	if err != nil {
+		return "", false
+	}
+	resolved := path.Join(baseDir, unescaped)
+	if strings.HasPrefix(resolved, "../") || resolved == ".." {
+		return "", false
+	}
+	return resolved, true
+}
+
+// elementLine returns the line of the first element with attr="value", or 0
+func elementLine(data []byte, attr, value string) int {
+	i := bytes.Index(data, []byte(attr+`="`+value+`"`))
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
+
+func truncate(s string) string {
+	if len(s) > maxReportedLength {
+		return s[:maxReportedLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,255 @@
+package validator
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/converter"
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/go-shiori/go-epub"
+)
+
+const testContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="pub-id">
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img" href="cover.png" media-type="image/png"/>
+    <item id="gone" href="gone.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+    <itemref idref="missing"/>
+  </spine>
+</package>`
+
+const testNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Nav</title></head>
+<body>
+<nav epub:type="toc"><ol>
+<li><a href="ch1.xhtml">One</a></li>
+<li><a href="ch3.xhtml">Three</a></li>
+</ol></nav>
+</body>
+</html>`
+
+const testChapter1 = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>One</title></head>
+<body>
+<h1 id="top">One</h1>
+<p><a href="ch2.xhtml#fixed-time">fixed time</a></p>
+<p><a href="#nowhere">nowhere</a> <a href="https://basecamp.com">Basecamp</a></p>
+<img src="cover.png" alt=""/>
+</body>
+</html>`
+
+const testChapter2 = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Two</title></head>
+<body>
+<p>Broken<br></p>
+</body>
+</html>`
+
+// zipEntry is a file to write into a test archive
+type zipEntry struct {
+	Name   string
+	Data   string
+	Method uint16
+}
+
+func writeTestEPUB(t *testing.T, entries []zipEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: entry.Method})
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", entry.Name, err)
+		}
+		f.Write([]byte(entry.Data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write EPUB: %v", err)
+	}
+	return path
+}
+
+// TestValidateEPUB_Problems verifies each check reports the file and line
+// of the problem
+func TestValidateEPUB_Problems(t *testing.T) {
+	path := writeTestEPUB(t, []zipEntry{
+		{Name: "META-INF/container.xml", Data: testContainer, Method: zip.Deflate},
+		{Name: "mimetype", Data: "application/epub+zip", Method: zip.Deflate},
+		{Name: "EPUB/package.opf", Data: testPackage, Method: zip.Deflate},
+		{Name: "EPUB/nav.xhtml", Data: testNav, Method: zip.Deflate},
+		{Name: "EPUB/ch1.xhtml", Data: testChapter1, Method: zip.Deflate},
+		{Name: "EPUB/ch2.xhtml", Data: testChapter2, Method: zip.Deflate},
+		{Name: "EPUB/cover.png", Data: "\xff\xd8\xff\xe0 not a png", Method: zip.Deflate},
+		{Name: "EPUB/extra.css", Data: "body {}", Method: zip.Deflate},
+	})
+
+	problems, err := ValidateEPUB(path)
+	if err != nil {
+		t.Fatalf("ValidateEPUB() error = %v", err)
+	}
+
+	var reported []string
+	for _, problem := range problems {
+		reported = append(reported, problem.String())
+	}
+	report := strings.Join(reported, "\n")
+
+	expected := []string{
+		"mimetype: must be the first file in the archive",
+		"mimetype: must be stored uncompressed",
+		`EPUB/package.opf:8: manifest item "gone" points to missing file gone.xhtml`,
+		"EPUB/extra.css: file is not listed in the manifest",
+		`EPUB/package.opf:12: spine references unknown manifest id "missing"`,
+		"EPUB/cover.png: declared as image/png but the content is image/jpeg",
+		"EPUB/ch2.xhtml:5: not well-formed",
+		"EPUB/nav.xhtml:7: navigation entry points to missing file ch3.xhtml",
+		"EPUB/ch1.xhtml:7: link points to missing fragment #nowhere in ch1.xhtml",
+	}
+	for _, want := range expected {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+
+	// The fragment in ch2 can't be checked because ch2 isn't well-formed,
+	// and external links are never checked
+	for _, unwanted := range []string{"fixed-time", "basecamp.com"} {
+		if strings.Contains(report, unwanted) {
+			t.Errorf("report should not mention %q:\n%s", unwanted, report)
+		}
+	}
+}
+
+// TestValidateEPUB_GoEPUB verifies a book written by go-epub passes
+func TestValidateEPUB_GoEPUB(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	book, err := epub.NewEpub("Shape Up")
+	if err != nil {
+		t.Fatalf("NewEpub() error = %v", err)
+	}
+	imgPath, err := book.AddImage("data:image/png;base64,"+base64.StdEncoding.EncodeToString(img.Bytes()), "image.png")
+	if err != nil {
+		t.Fatalf("AddImage() error = %v", err)
+	}
+	if _, err := book.AddSection(`<h1 id="one">One</h1><p><a href="section0002.xhtml#two">Two</a></p><img src="`+imgPath+`" alt=""/>`, "One", "", ""); err != nil {
+		t.Fatalf("AddSection() error = %v", err)
+	}
+	if _, err := book.AddSection(`<h1 id="two">Two</h1><p><a href="section0001.xhtml#one">One</a></p>`, "Two", "", ""); err != nil {
+		t.Fatalf("AddSection() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := book.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	problems, err := ValidateEPUB(path)
+	if err != nil {
+		t.Fatalf("ValidateEPUB() error = %v", err)
+	}
+	for _, problem := range problems {
+		t.Errorf("unexpected problem: %s", problem)
+	}
+}
+
+// TestValidateEPUB_Converter verifies the books the EPUB converter writes,
+// in either version, pass validation
+func TestValidateEPUB_Converter(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	sketch := downloader.NewAsset(img.Bytes(), "image/png")
+
+	chapters := []downloader.Chapter{
+		{
+			Title: "Principles of Shaping",
+			Content: `<div class="toc"><a href="/shapeup/1.1">Principles of Shaping</a><a href="/shapeup/1.2">Set Boundaries</a></div>
+				<h1 class="intro__title"><a href="/shapeup">Principles of Shaping</a></h1>
+				<div class="content">
+					<p>Read about <a href="/shapeup/1.2#fixed-time">fixed time</a> and <a href="https://basecamp.com">Basecamp</a>.</p>
+					<figure><img src="` + sketch.URL() + `" alt="Sketch"><figcaption>A sketch</figcaption></figure>
+				</div>`,
+			URL:    "https://basecamp.com/shapeup/1.1",
+			Number: 1,
+			Assets: map[string]downloader.Asset{sketch.URL(): sketch},
+		},
+		{
+			Title: "Set Boundaries",
+			Content: `<h1 class="intro__title"><a href="/shapeup">Set Boundaries</a></h1>
+				<div class="content">
+					<h2 id="fixed-time">Fixed time, variable scope</h2>
+					<p>Back to <a href="/shapeup/1.1">the principles</a> or <a href="#fixed-time">the top</a>.</p>
+				</div>`,
+			URL:    "https://basecamp.com/shapeup/1.2",
+			Number: 2,
+		},
+	}
+
+	for _, version := range []int{converter.EPUBVersion2, converter.EPUBVersion3} {
+		t.Run(fmt.Sprintf("EPUB %d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.epub")
+			conv := converter.NewEPUBConverter(path)
+			conv.Version = version
+			conv.Log = io.Discard
+			if err := conv.Convert(chapters, "body { color: black; }"); err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+
+			problems, err := ValidateEPUB(path)
+			if err != nil {
+				t.Fatalf("ValidateEPUB() error = %v", err)
+			}
+			for _, problem := range problems {
+				t.Errorf("unexpected problem: %s", problem)
+			}
+		})
+	}
+}
+
+// TestValidateEPUB_NotZip verifies unreadable files return an error
+func TestValidateEPUB_NotZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(path, []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := ValidateEPUB(path); err == nil {
+		t.Error("ValidateEPUB() expected error for a file that isn't a ZIP archive")
+	}
+}
@@ -3,25 +3,111 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/benjaminkitt/shape-up-downloader/internal/converter"
+	"github.com/benjaminkitt/shape-up-downloader/internal/device"
 	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
+	"github.com/benjaminkitt/shape-up-downloader/internal/validator"
 	"github.com/spf13/cobra"
 )
 
+// formatExtensions maps each supported output format to the file extension
+// its output is written with. Formats that write a directory have none.
+var formatExtensions = map[string]string{
+	"html":        "",
+	"epub":        ".epub",
+	"mhtml":       ".mhtml",
+	"pandoc-json": ".json",
+	"json":        ".json",
+	"text":        ".txt",
+	"fb2":         ".fb2",
+	"asciidoc":    "",
+	"org":         "",
+	"gemini":      "",
+	"obsidian":    "",
+	"ssg":         "",
+}
+
+// supportedFormats lists the output formats in the order they are documented
+var supportedFormats = []string{"html", "mhtml", "epub", "fb2", "text", "asciidoc", "org", "gemini", "obsidian", "ssg", "json", "pandoc-json"}
+
+// options holds the command line flags
+type options struct {
+	format        string
+	output        string
+	width         int
+	splitSections bool
+	embedImages   bool
+	// uncheckedFonts embeds fonts whose license can't be read
+	uncheckedFonts bool
+	noCSSPrune     bool
+	noSearch       bool
+	pwa            bool
+	print          bool
+	theme          string
+	templateDir    string
+	ssg            string
+	epubVersion    int
+	a11y           string
+	// device names the profile of the reader the book is for, from the
+	// built-in profiles or the device config file
+	device       string
+	deviceConfig string
+	// meta overrides the metadata found on the source page
+	meta downloader.Metadata
+}
+
+// isbnPattern matches an ISBN-10 or ISBN-13 once hyphens and spaces are
+// removed
+var isbnPattern = regexp.MustCompile(`^(\d{9}[\dX]|\d{13})$`)
+
+// metadataOverrides checks the metadata flags and returns them ready to
+// merge over the source page's metadata
+func metadataOverrides(meta downloader.Metadata) (downloader.Metadata, error) {
+	if meta.ISBN != "" {
+		meta.ISBN = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(meta.ISBN))
+		if !isbnPattern.MatchString(meta.ISBN) {
+			return meta, fmt.Errorf("invalid ISBN: %s (must have 10 or 13 digits)", meta.ISBN)
+		}
+	}
+
+	if meta.Date != "" {
+		valid := false
+		for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+			if _, err := time.Parse(layout, meta.Date); err == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return meta, fmt.Errorf("invalid date: %s (must be YYYY, YYYY-MM or YYYY-MM-DD)", meta.Date)
+		}
+	}
+
+	if meta.Cover != "" && !strings.HasPrefix(meta.Cover, "http://") && !strings.HasPrefix(meta.Cover, "https://") {
+		if _, err := os.Stat(meta.Cover); err != nil {
+			return meta, fmt.Errorf("cover image not found: %s", meta.Cover)
+		}
+	}
+
+	return meta, nil
+}
+
 func validateFlags(format string, output string) error {
 	// Validate format
 	format = strings.ToLower(format)
-	if format != "html" && format != "epub" {
-		return fmt.Errorf("invalid format: %s (must be 'html' or 'epub')", format)
+	ext, ok := formatExtensions[format]
+	if !ok {
+		return fmt.Errorf("invalid format: %s (must be one of: %s)", format, strings.Join(supportedFormats, ", "))
 	}
 
 	// Validate output path
-	if format == "epub" {
-		if !strings.HasSuffix(output, ".epub") {
-			output = output + ".epub"
-		}
+	if ext != "" && !strings.HasSuffix(output, ext) {
+		output = output + ext
 	}
 
 	// Check if output directory/file exists
@@ -32,9 +118,122 @@ func validateFlags(format string, output string) error {
 	return nil
 }
 
+// deviceProfile returns the profile of the device the book is for, from
+// the built-in profiles and the device config file. Without a config path
+// the default config file is used if there is one. With no device, the
+// book is left as it was downloaded.
+func deviceProfile(name, configPath string) (device.Profile, error) {
+	optional := configPath == ""
+	if optional {
+		configPath = device.DefaultConfigPath()
+	}
+	profiles, err := device.Load(configPath, optional)
+	if err != nil {
+		return device.Profile{}, err
+	}
+	if name == "" {
+		return device.Default, nil
+	}
+	return device.Lookup(profiles, name)
+}
+
+// checkTheme makes sure an HTML theme is built in or in the template
+// directory's themes folder, before anything is downloaded
+func checkTheme(theme, templateDir string) error {
+	if theme == "" {
+		return nil
+	}
+	for _, builtin := range converter.HTMLThemes {
+		if theme == builtin {
+			return nil
+		}
+	}
+	if templateDir != "" {
+		if _, err := os.Stat(filepath.Join(templateDir, "themes", theme+".css")); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid theme: %q (must be one of: %s, or one in the template directory's themes folder)", theme, strings.Join(converter.HTMLThemes, ", "))
+}
+
+// needsCover reports whether the output format uses a cover image: EPUB
+// does, and HTML makes its web app icons from it
+func needsCover(opts options) bool {
+	switch strings.ToLower(opts.format) {
+	case "epub":
+		return true
+	case "html":
+		return opts.pwa
+	}
+	return false
+}
+
+// newConverter returns the converter for the requested output format
+func newConverter(opts options) (converter.Converter, error) {
+	switch strings.ToLower(opts.format) {
+	case "html":
+		conv := converter.NewHTMLConverter(opts.output)
+		conv.EmbedImages = opts.embedImages
+		conv.PruneCSS = !opts.noCSSPrune
+		if err := checkTheme(opts.theme, opts.templateDir); err != nil {
+			return nil, err
+		}
+		conv.Theme = opts.theme
+		conv.TemplateDir = opts.templateDir
+		conv.Search = !opts.noSearch
+		conv.PWA = opts.pwa
+		conv.Print = opts.print
+		return conv, nil
+	case "mhtml":
+		conv := converter.NewMHTMLConverter(opts.output)
+		conv.PruneCSS = !opts.noCSSPrune
+		return conv, nil
+	case "epub":
+		if opts.epubVersion != converter.EPUBVersion2 && opts.epubVersion != converter.EPUBVersion3 {
+			return nil, fmt.Errorf("invalid EPUB version: %d (must be 2 or 3)", opts.epubVersion)
+		}
+		conv := converter.NewEPUBConverter(opts.output)
+		conv.Version = opts.epubVersion
+		conv.PruneCSS = !opts.noCSSPrune
+		for _, mode := range converter.A11yModes {
+			if strings.EqualFold(opts.a11y, mode) {
+				conv.A11y = mode
+				return conv, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid accessibility mode: %q (must be one of: %s)", opts.a11y, strings.Join(converter.A11yModes, ", "))
+	case "fb2":
+		return converter.NewFB2Converter(opts.output), nil
+	case "text":
+		if opts.width < 20 {
+			return nil, fmt.Errorf("invalid width: %d (must be at least 20)", opts.width)
+		}
+		return converter.NewTextConverter(opts.output, opts.width), nil
+	case "obsidian":
+		return converter.NewObsidianConverter(opts.output, opts.splitSections), nil
+	case "ssg":
+		for _, generator := range converter.SSGGenerators {
+			if strings.EqualFold(opts.ssg, generator) {
+				return converter.NewSSGConverter(opts.output, generator), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid static site generator: %q (must be one of: %s)", opts.ssg, strings.Join(converter.SSGGenerators, ", "))
+	case "asciidoc":
+		return converter.NewAsciiDocConverter(opts.output), nil
+	case "org":
+		return converter.NewOrgConverter(opts.output), nil
+	case "gemini":
+		return converter.NewGeminiConverter(opts.output), nil
+	case "json":
+		return converter.NewJSONConverter(opts.output), nil
+	case "pandoc-json":
+		return converter.NewPandocConverter(opts.output), nil
+	}
+	return nil, fmt.Errorf("invalid format: %s", opts.format)
+}
+
 func main() {
-	var outputFormat string
-	var outputDir string
+	var opts options
 
 	rootCmd := &cobra.Command{
 		Use:   "shape-up-downloader",
@@ -42,18 +241,49 @@ func main() {
 		Long: `A CLI tool to download the Shape Up book by Ryan Singer, 
                published by Basecamp, and save it as HTML or EPUB`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := validateFlags(outputFormat, outputDir); err != nil {
+			if err := validateFlags(opts.format, opts.output); err != nil {
+				return err
+			}
+
+			profile, err := deviceProfile(opts.device, opts.deviceConfig)
+			if err != nil {
+				return err
+			}
+			// The device's EPUB version applies unless one was asked for
+			if profile.EPUBVersion != 0 && !cmd.Flags().Changed("epub-version") {
+				opts.epubVersion = profile.EPUBVersion
+			}
+
+			conv, err := newConverter(opts)
+			if err != nil {
+				return err
+			}
+			conv.SetDevice(profile)
+
+			overrides, err := metadataOverrides(opts.meta)
+			if err != nil {
 				return err
 			}
 
 			// Initialize downloader
 			dl := downloader.New()
+			dl.SetImageOptions(profile.Images)
+			dl.EmbedUncheckedFonts = opts.uncheckedFonts
 
 			// Fetch table of contents
 			chapters, err := dl.FetchTOC()
 			if err != nil {
 				return fmt.Errorf("failed to fetch table of contents: %w", err)
 			}
+			meta := overrides.Merge(dl.Metadata()).Merge(downloader.DefaultMetadata)
+			if needsCover(opts) {
+				// Without a cover image the converter renders one
+				meta.CoverImage, err = dl.FetchCover(meta.Cover)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: %v; using a generated cover\n", err)
+				}
+			}
+			conv.SetMetadata(meta)
 
 			// Download each chapter
 			for i, chapter := range chapters {
@@ -66,26 +296,95 @@ func main() {
 			}
 
 			// Convert to requested format
-			switch outputFormat {
-			case "html":
-				conv := converter.NewHTMLConverter(outputDir)
-				if err := conv.Convert(chapters, chapters[0].CSS); err != nil {
-					return fmt.Errorf("failed to convert to HTML: %w", err)
-				}
-			case "epub":
-				conv := converter.NewEPUBConverter(outputDir)
-				if err := conv.Convert(chapters, chapters[0].CSS); err != nil {
-					return fmt.Errorf("failed to convert to EPUB: %w", err)
-				}
+			if err := conv.Convert(chapters, chapters[0].CSS); err != nil {
+				return fmt.Errorf("failed to convert to %s: %w", strings.ToUpper(opts.format), err)
+			}
+
+			fmt.Printf("Successfully downloaded Shape Up book to %s\n", opts.output)
+			return nil
+		},
+	}
+
+	rootCmd.Flags().StringVarP(&opts.format, "format", "f", "html", "Output format ("+strings.Join(supportedFormats, ", ")+")")
+	rootCmd.Flags().StringVarP(&opts.output, "output", "o", "shape-up-book", "Output directory for HTML, AsciiDoc, Org, Gemini, Obsidian and SSG, or filename for other formats")
+	rootCmd.Flags().IntVarP(&opts.width, "width", "w", converter.DefaultTextWidth, "Line width for text output")
+	rootCmd.Flags().BoolVar(&opts.splitSections, "split-sections", false, "Write one Obsidian note per section as well as per chapter")
+	rootCmd.Flags().BoolVar(&opts.embedImages, "embed-images", false, "Inline HTML images in index.html instead of writing them to an images folder")
+	rootCmd.Flags().BoolVar(&opts.uncheckedFonts, "embed-unchecked-fonts", false, "Embed web fonts whose license can't be read, such as WOFF2 ones, instead of leaving them out")
+	rootCmd.Flags().BoolVar(&opts.noCSSPrune, "no-css-prune", false, "Keep the stylesheet rules the book doesn't use (HTML, MHTML and EPUB)")
+	rootCmd.Flags().BoolVar(&opts.noSearch, "no-search", false, "Leave the search box and its index out of HTML output")
+	rootCmd.Flags().BoolVar(&opts.pwa, "pwa", false, "Make HTML output an installable web app that works offline, with a manifest, icons from the cover and a service worker")
+	rootCmd.Flags().BoolVar(&opts.print, "print", false, "Add CSS Paged Media rules to HTML output for printing: chapter page breaks, running headers, page numbers and URLs as footnotes")
+	rootCmd.Flags().StringVar(&opts.theme, "theme", "", "Theme for HTML output ("+strings.Join(converter.HTMLThemes, ", ")+", or one in the template directory; default: the site's own styling)")
+	rootCmd.Flags().StringVar(&opts.templateDir, "template-dir", "", "Directory of HTML templates, themes and assets that replace the built-in ones (see templates export)")
+	rootCmd.Flags().IntVar(&opts.epubVersion, "epub-version", converter.EPUBVersion3, "EPUB version to write (2 for older readers, or 3)")
+	rootCmd.Flags().StringVar(&opts.a11y, "a11y", converter.A11yWarn, "What to do with EPUB images that have no alt text ("+strings.Join(converter.A11yModes, ", ")+")")
+	rootCmd.Flags().StringVar(&opts.device, "device", "", "Tune the book for a reader ("+strings.Join(device.Names(), ", ")+", or one from the device config); images are prepared as they download, for every format")
+	rootCmd.Flags().StringVar(&opts.deviceConfig, "device-config", "", "JSON file of user-defined device profiles (default: "+device.DefaultConfigPath()+" if it exists)")
+	rootCmd.Flags().StringVar(&opts.meta.Title, "title", "", "Book title (default: taken from the source page)")
+	rootCmd.Flags().StringVar(&opts.meta.Author, "author", "", "Book author (default: taken from the source page)")
+	rootCmd.Flags().StringVar(&opts.meta.Cover, "cover", "", "Cover image URL or file (default: taken from the source page, or generated)")
+	rootCmd.Flags().StringVar(&opts.meta.Publisher, "publisher", "", "Publisher (default: taken from the source page)")
+	rootCmd.Flags().StringVar(&opts.meta.ISBN, "isbn", "", "ISBN to identify the book by")
+	rootCmd.Flags().StringVar(&opts.meta.Date, "date", "", "Publication date as YYYY, YYYY-MM or YYYY-MM-DD")
+	rootCmd.Flags().StringVar(&opts.ssg, "ssg", "", "Static site generator for ssg output ("+strings.Join(converter.SSGGenerators, ", ")+")")
+
+	validateCmd := &cobra.Command{
+		Use:   "validate <file.epub>",
+		Short: "Check an EPUB file for common problems",
+		Args:  cobra.ExactArgs(1),
+		// Problems in the file aren't usage errors, and main prints the error
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			problems, err := validator.ValidateEPUB(args[0])
+			if err != nil {
+				return err
+			}
+
+			for _, problem := range problems {
+				fmt.Fprintln(cmd.OutOrStdout(), problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problems in %s", len(problems), args[0])
 			}
 
-			fmt.Printf("Successfully downloaded Shape Up book to %s\n", outputDir)
+			fmt.Fprintf(cmd.OutOrStdout(), "No problems found in %s\n", args[0])
 			return nil
 		},
 	}
+	rootCmd.AddCommand(validateCmd)
 
-	rootCmd.Flags().StringVarP(&outputFormat, "format", "f", "html", "Output format (html or epub)")
-	rootCmd.Flags().StringVarP(&outputDir, "output", "o", "shape-up-book", "Output directory for HTML or filename for EPUB")
+	templatesCmd := &cobra.Command{
+		Use:   "templates",
+		Short: "Work with the HTML output's templates",
+	}
+	exportCmd := &cobra.Command{
+		Use:   "export [dir]",
+		Short: "Write the built-in HTML templates and themes to a directory for customizing",
+		Args:  cobra.MaximumNArgs(1),
+		// A file in the way isn't a usage error, and main prints the error
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "shape-up-templates"
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			written, err := converter.ExportHTMLTemplates(dir)
+			for _, file := range written {
+				fmt.Fprintln(cmd.OutOrStdout(), file)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Use them with --template-dir %s\n", dir)
+			return nil
+		},
+	}
+	templatesCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(templatesCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -3,8 +3,12 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/benjaminkitt/shape-up-downloader/internal/device"
+	"github.com/benjaminkitt/shape-up-downloader/internal/downloader"
 )
 
 func TestValidateFlags(t *testing.T) {
@@ -43,6 +47,60 @@ func TestValidateFlags(t *testing.T) {
 			output:    filepath.Join(testDir, "test-output.epub"),
 			wantError: false,
 		},
+		{
+			name:      "mhtml format",
+			format:    "mhtml",
+			output:    filepath.Join(testDir, "test-output"),
+			wantError: false,
+		},
+		{
+			name:      "fb2 format",
+			format:    "fb2",
+			output:    filepath.Join(testDir, "test-output"),
+			wantError: false,
+		},
+		{
+			name:      "text format",
+			format:    "text",
+			output:    filepath.Join(testDir, "test-output"),
+			wantError: false,
+		},
+		{
+			name:      "asciidoc format",
+			format:    "asciidoc",
+			output:    filepath.Join(testDir, "test-asciidoc"),
+			wantError: false,
+		},
+		{
+			name:      "org format",
+			format:    "org",
+			output:    filepath.Join(testDir, "test-org"),
+			wantError: false,
+		},
+		{
+			name:      "gemini format",
+			format:    "gemini",
+			output:    filepath.Join(testDir, "test-capsule"),
+			wantError: false,
+		},
+		{
+			name:      "obsidian format",
+			format:    "obsidian",
+			output:    filepath.Join(testDir, "test-vault"),
+			wantError: false,
+		},
+		{
+			name:      "json format",
+			format:    "json",
+			output:    filepath.Join(testDir, "test-output"),
+			wantError: false,
+		},
+		{
+			name:      "pandoc json format",
+			format:    "pandoc-json",
+			output:    filepath.Join(testDir, "test-output"),
+			wantError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -105,3 +163,139 @@ func TestOutputPathHandling(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConverter(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      options
+		wantError bool
+	}{
+		{"html", options{format: "html", output: "out"}, false},
+		{"html with embedded images", options{format: "html", output: "out", embedImages: true}, false},
+		{"html without search", options{format: "html", output: "out", noSearch: true}, false},
+		{"html as a PWA", options{format: "html", output: "out", pwa: true}, false},
+		{"html for print", options{format: "html", output: "out", print: true}, false},
+		{"html with theme", options{format: "html", output: "out", theme: "sepia"}, false},
+		{"html with unknown theme", options{format: "html", output: "out", theme: "neon"}, true},
+		{"mhtml", options{format: "mhtml", output: "out"}, false},
+		{"mhtml without CSS pruning", options{format: "mhtml", output: "out", noCSSPrune: true}, false},
+		{"epub 2", options{format: "epub", output: "out", epubVersion: 2, a11y: "warn"}, false},
+		{"epub 4", options{format: "epub", output: "out", epubVersion: 4, a11y: "warn"}, true},
+		{"epub strict a11y", options{format: "epub", output: "out", epubVersion: 3, a11y: "strict"}, false},
+		{"epub unknown a11y", options{format: "epub", output: "out", epubVersion: 3, a11y: "loud"}, true},
+		{"text with width", options{format: "text", output: "out", width: 72}, false},
+		{"text too narrow", options{format: "text", output: "out", width: 10}, true},
+		{"ssg hugo", options{format: "ssg", output: "out", ssg: "hugo"}, false},
+		{"ssg without generator", options{format: "ssg", output: "out"}, true},
+		{"unknown format", options{format: "pdf", output: "out"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newConverter(tt.opts)
+			if (err != nil) != tt.wantError {
+				t.Errorf("newConverter() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+// TestMetadataOverrides verifies the metadata flags are checked and
+// normalized
+func TestMetadataOverrides(t *testing.T) {
+	cover := filepath.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(cover, []byte("png"), 0644); err != nil {
+		t.Fatalf("failed to write cover: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		meta      downloader.Metadata
+		want      downloader.Metadata
+		wantError bool
+	}{
+		{"no overrides", downloader.Metadata{}, downloader.Metadata{}, false},
+		{"hyphenated ISBN", downloader.Metadata{ISBN: "978-0-00-000000-2"}, downloader.Metadata{ISBN: "9780000000002"}, false},
+		{"ISBN-10 with X", downloader.Metadata{ISBN: "0-00-000000-x"}, downloader.Metadata{ISBN: "000000000X"}, false},
+		{"short ISBN", downloader.Metadata{ISBN: "12345"}, downloader.Metadata{}, true},
+		{"year", downloader.Metadata{Date: "2019"}, downloader.Metadata{Date: "2019"}, false},
+		{"full date", downloader.Metadata{Date: "2019-07-01"}, downloader.Metadata{Date: "2019-07-01"}, false},
+		{"bad date", downloader.Metadata{Date: "July 2019"}, downloader.Metadata{}, true},
+		{"cover file", downloader.Metadata{Cover: cover}, downloader.Metadata{Cover: cover}, false},
+		{"cover URL", downloader.Metadata{Cover: "https://example.com/cover.png"}, downloader.Metadata{Cover: "https://example.com/cover.png"}, false},
+		{"missing cover file", downloader.Metadata{Cover: "missing.png"}, downloader.Metadata{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := metadataOverrides(tt.meta)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("metadataOverrides() error = %v, wantError %v", err, tt.wantError)
+			}
+			if !tt.wantError && got != tt.want {
+				t.Errorf("metadataOverrides() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNeedsCover verifies the cover is fetched for the formats that use it,
+// however the format is spelled
+func TestNeedsCover(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want bool
+	}{
+		{"epub", options{format: "epub"}, true},
+		{"upper case epub", options{format: "EPUB"}, true},
+		{"html", options{format: "html"}, false},
+		{"html as a PWA", options{format: "HTML", pwa: true}, true},
+		{"markdown", options{format: "markdown", pwa: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsCover(tt.opts); got != tt.want {
+				t.Errorf("needsCover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceProfile(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(config, []byte(`{"devices": {"boox": {"extends": "kobo", "margins": "2em"}}}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	boox := device.Builtin["kobo"]
+	boox.Extends = "kobo"
+	boox.Margins = "2em"
+
+	tests := []struct {
+		name      string
+		device    string
+		config    string
+		want      device.Profile
+		wantError bool
+	}{
+		{"no device", "", "", device.Default, false},
+		{"built-in", "kindle", "", device.Builtin["kindle"], false},
+		{"any case", "Apple-Books", "", device.Builtin["apple-books"], false},
+		{"from config", "boox", config, boox, false},
+		{"unknown device", "walkman", "", device.Profile{}, true},
+		{"missing config", "kindle", filepath.Join(t.TempDir(), "missing.json"), device.Profile{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := deviceProfile(tt.device, tt.config)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("deviceProfile() error = %v, wantError %v", err, tt.wantError)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deviceProfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}